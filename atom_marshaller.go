@@ -3,12 +3,16 @@ package factstoredb
 import (
 	"errors"
 	"fmt"
+	"strconv"
 
 	"github.com/go-json-experiment/json"
 	"github.com/go-json-experiment/json/jsontext"
 	"github.com/google/mangle/ast"
 )
 
+// fnString wraps stored strings that would otherwise read back as names or bytes.
+const fnString = "fn:string"
+
 // constantJSON is a wrapper around ast.Constant that implements
 // json.MarshalerTo and json.UnmarshalerFrom for efficient serialization
 // without losing type information.
@@ -40,6 +44,26 @@ func (cj constantJSON) MarshalJSONTo(enc *jsontext.Encoder) error {
 		if err != nil {
 			return fmt.Errorf("failed to get string value: %w", err)
 		}
+		if isAmbiguousString(str) {
+			// Strings that would read back as a name or bytes are wrapped:
+			// {"fn:string": ["/looks/like/a/name"]}
+			if err := enc.WriteToken(jsontext.BeginObject); err != nil {
+				return err
+			}
+			if err := enc.WriteToken(jsontext.String(fnString)); err != nil {
+				return err
+			}
+			if err := enc.WriteToken(jsontext.BeginArray); err != nil {
+				return err
+			}
+			if err := enc.WriteToken(jsontext.String(str)); err != nil {
+				return err
+			}
+			if err := enc.WriteToken(jsontext.EndArray); err != nil {
+				return err
+			}
+			return enc.WriteToken(jsontext.EndObject)
+		}
 		return enc.WriteToken(jsontext.String(str))
 
 	case ast.BytesType:
@@ -183,11 +207,105 @@ func (cj constantJSON) MarshalJSONTo(enc *jsontext.Encoder) error {
 		}
 		return enc.WriteToken(jsontext.EndObject)
 
+	case refType:
+		// References to the constants dictionary: {"fn:ref": [id]}
+		return enc.WriteValue(jsontext.Value(refJSON(cj.NumValue)))
+
 	default:
 		return fmt.Errorf("unknown constant type: %d", cj.Type)
 	}
 }
 
+// isAmbiguousString reports whether a string value would be decoded as a
+// name ("/...") or as bytes (b"...") if it were stored as a plain JSON string.
+func isAmbiguousString(str string) bool {
+	if len(str) > 0 && str[0] == '/' {
+		return true
+	}
+	return len(str) >= 3 && str[:2] == `b"` && str[len(str)-1] == '"'
+}
+
+// constantFromJSONString decodes a stored JSON string, which holds a name
+// ("/..."), bytes (b"...") or a plain string.
+func constantFromJSONString(str string) (ast.Constant, error) {
+	if len(str) > 0 && str[0] == '/' {
+		// Name: starts with "/"
+		c, err := ast.Name(str)
+		if err != nil {
+			return ast.Constant{}, fmt.Errorf("failed to create name from %q: %w", str, err)
+		}
+		return c, nil
+	}
+	if len(str) >= 3 && str[:2] == `b"` && str[len(str)-1] == '"' {
+		// Bytes: starts with b" and ends with " (e.g., b"..." or b"")
+		// Extract the escaped content between b" and "
+		escapedContent := str[2 : len(str)-1]
+		unescaped, err := ast.Unescape(escapedContent, true /* isBytes */)
+		if err != nil {
+			return ast.Constant{}, fmt.Errorf("failed to unescape bytes: %w", err)
+		}
+		return ast.Bytes([]byte(unescaped)), nil
+	}
+	// String: regular string
+	return ast.String(str), nil
+}
+
+// jsonNumberConstant decodes the text of a JSON number. Like constantJSON,
+// it yields a NumberType for integral values and a Float64Type otherwise.
+func jsonNumberConstant(text string) (ast.Constant, error) {
+	f, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return ast.Constant{}, fmt.Errorf("invalid JSON number %q: %w", text, err)
+	}
+	if f != float64(int64(f)) {
+		return ast.Float64(f), nil
+	}
+	if i, err := strconv.ParseInt(text, 10, 64); err == nil {
+		return ast.Number(i), nil
+	}
+	return ast.Number(int64(f)), nil
+}
+
+// applyFnConstant builds the constant of a {"fn:pair"|"fn:map"|"fn:struct": [args]} object.
+func applyFnConstant(symbol string, args []ast.Constant) (ast.Constant, error) {
+	switch symbol {
+	case "fn:pair":
+		if len(args) != 2 {
+			return ast.Constant{}, fmt.Errorf("fn:pair expects 2 args, got %d", len(args))
+		}
+		return ast.Pair(&args[0], &args[1]), nil
+
+	case "fn:map":
+		if len(args)%2 != 0 {
+			return ast.Constant{}, fmt.Errorf("fn:map expects even number of args, got %d", len(args))
+		}
+		kvMap := make(map[*ast.Constant]*ast.Constant)
+		for i := 0; i < len(args); i += 2 {
+			kvMap[&args[i]] = &args[i+1]
+		}
+		return *ast.Map(kvMap), nil
+
+	case "fn:struct":
+		if len(args)%2 != 0 {
+			return ast.Constant{}, fmt.Errorf("fn:struct expects even number of args, got %d", len(args))
+		}
+		kvMap := make(map[*ast.Constant]*ast.Constant)
+		for i := 0; i < len(args); i += 2 {
+			kvMap[&args[i]] = &args[i+1]
+		}
+		return *ast.Struct(kvMap), nil
+
+	case fnRef:
+		if len(args) != 1 || args[0].Type != ast.NumberType {
+			return ast.Constant{}, fmt.Errorf("%s expects a single number arg", fnRef)
+		}
+		return refConstant(args[0].NumValue), nil
+
+	default:
+		return ast.Constant{}, fmt.Errorf("unknown function symbol: %s", symbol)
+	}
+}
+
 // UnmarshalJSONFrom implements json.UnmarshalerFrom for constantJSON.
 // Parses native JSON types and constructs BaseTerms, then evaluates with functional.EvalExpr.
 func (cj *constantJSON) UnmarshalJSONFrom(dec *jsontext.Decoder) error {
@@ -202,28 +320,11 @@ func (cj *constantJSON) UnmarshalJSONFrom(dec *jsontext.Decoder) error {
 		return errors.New("null values are not supported for constants")
 
 	case '"': // string
-		str := tok.String()
-		// Determine if this is a Name, Bytes, or String
-		if len(str) > 0 && str[0] == '/' {
-			// Name: starts with "/"
-			c, err := ast.Name(str)
-			if err != nil {
-				return fmt.Errorf("failed to create name from %q: %w", str, err)
-			}
-			cj.Constant = c
-		} else if len(str) >= 3 && str[:2] == `b"` && str[len(str)-1] == '"' {
-			// Bytes: starts with b" and ends with " (e.g., b"..." or b"")
-			// Extract the escaped content between b" and "
-			escapedContent := str[2 : len(str)-1]
-			unescaped, err := ast.Unescape(escapedContent, true /* isBytes */)
-			if err != nil {
-				return fmt.Errorf("failed to unescape bytes: %w", err)
-			}
-			cj.Constant = ast.Bytes([]byte(unescaped))
-		} else {
-			// String: regular string
-			cj.Constant = ast.String(str)
+		c, err := constantFromJSONString(tok.String())
+		if err != nil {
+			return err
 		}
+		cj.Constant = c
 
 	case '0': // number (integer or float)
 		if tok.Float() != float64(int64(tok.Float())) {
@@ -270,6 +371,23 @@ func (cj *constantJSON) UnmarshalJSONFrom(dec *jsontext.Decoder) error {
 			return fmt.Errorf("expected array for args, got %c", tok.Kind())
 		}
 
+		// A wrapped string holds a raw JSON string that must not be
+		// interpreted as a name or bytes.
+		if symbol == fnString {
+			tok, err := dec.ReadToken()
+			if err != nil || tok.Kind() != '"' {
+				return fmt.Errorf("%s expects a single string arg", fnString)
+			}
+			cj.Constant = ast.String(tok.String())
+			if tok, err := dec.ReadToken(); err != nil || tok.Kind() != ']' {
+				return fmt.Errorf("%s expects a single string arg", fnString)
+			}
+			if tok, err := dec.ReadToken(); err != nil || tok.Kind() != '}' {
+				return fmt.Errorf("expected object end '}'")
+			}
+			return nil
+		}
+
 		// Read array elements
 		var args []ast.Constant
 		for dec.PeekKind() != ']' {
@@ -291,38 +409,11 @@ func (cj *constantJSON) UnmarshalJSONFrom(dec *jsontext.Decoder) error {
 		}
 
 		// Construct the constant based on the function symbol
-		switch symbol {
-		case "fn:pair":
-			if len(args) != 2 {
-				return fmt.Errorf("fn:pair expects 2 args, got %d", len(args))
-			}
-			cj.Constant = ast.Pair(&args[0], &args[1])
-
-		case "fn:map":
-			if len(args)%2 != 0 {
-				return fmt.Errorf("fn:map expects even number of args, got %d", len(args))
-			}
-			kvMap := make(map[*ast.Constant]*ast.Constant)
-			for i := 0; i < len(args); i += 2 {
-				kvMap[&args[i]] = &args[i+1]
-			}
-			mapConst := ast.Map(kvMap)
-			cj.Constant = *mapConst
-
-		case "fn:struct":
-			if len(args)%2 != 0 {
-				return fmt.Errorf("fn:struct expects even number of args, got %d", len(args))
-			}
-			kvMap := make(map[*ast.Constant]*ast.Constant)
-			for i := 0; i < len(args); i += 2 {
-				kvMap[&args[i]] = &args[i+1]
-			}
-			structConst := ast.Struct(kvMap)
-			cj.Constant = *structConst
-
-		default:
-			return fmt.Errorf("unknown function symbol: %s", symbol)
+		c, err := applyFnConstant(symbol, args)
+		if err != nil {
+			return err
 		}
+		cj.Constant = c
 
 	default:
 		return fmt.Errorf("unexpected JSON token kind: %c", tok.Kind())
@@ -490,10 +581,15 @@ func (aj *atomJSON) UnmarshalJSONFrom(dec *jsontext.Decoder) error {
 // This is more efficient than unmarshalling args separately and then constructing the atom.
 // The predicateStr must be in "symbol_arity" format (e.g., "foo_2").
 func unmarshalAtom(predicateStr ast.PredicateSym, argsJSON string) (ast.Atom, error) {
+	return unmarshalArgs(predicateStr, []byte(argsJSON))
+}
 
+// unmarshalArgs unmarshals the JSON text of an args array into an ast.Atom of pred.
+// It does not retain data, so data may be a reused scan buffer.
+func unmarshalArgs(pred ast.PredicateSym, data []byte) (ast.Atom, error) {
 	// Unmarshal args directly to []constantJSON
 	var jsonConsts []constantJSON
-	if err := json.Unmarshal([]byte(argsJSON), &jsonConsts); err != nil {
+	if err := json.Unmarshal(data, &jsonConsts); err != nil {
 		return ast.Atom{}, fmt.Errorf("failed to unmarshal args: %w", err)
 	}
 
@@ -507,7 +603,16 @@ func unmarshalAtom(predicateStr ast.PredicateSym, argsJSON string) (ast.Atom, er
 	}
 
 	return ast.Atom{
-		Predicate: ast.PredicateSym{Symbol: predicateStr.Symbol, Arity: predicateStr.Arity},
+		Predicate: ast.PredicateSym{Symbol: pred.Symbol, Arity: pred.Arity},
 		Args:      baseTerms,
 	}, nil
 }
+
+// unmarshalConstant unmarshals the JSON text of a single argument into an ast.Constant.
+func unmarshalConstant(argJSON string) (ast.Constant, error) {
+	var cj constantJSON
+	if err := json.Unmarshal([]byte(argJSON), &cj); err != nil {
+		return ast.Constant{}, fmt.Errorf("failed to unmarshal arg: %w", err)
+	}
+	return cj.Constant, nil
+}
@@ -33,6 +33,9 @@ func TestConstantJSONRoundTrip(t *testing.T) {
 		{name: "string_simple", constant: ast.String("hello world")},
 		{name: "string_empty", constant: ast.String("")},
 		{name: "string_special", constant: ast.String(`foo"bar\baz`)},
+		{name: "string_like_name", constant: ast.String("/alice")},
+		{name: "string_like_bytes", constant: ast.String(`b"abc"`)},
+		{name: "string_slash_only", constant: ast.String("/")},
 		// Numbers
 		{name: "number_positive", constant: num42},
 		{name: "number_negative", constant: numNeg17},
@@ -0,0 +1,588 @@
+package factstoredb
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/go-json-experiment/json/jsontext"
+	"github.com/google/mangle/ast"
+)
+
+// ErrBackupVerification is returned when a backup does not check out: when
+// Backup verifies the file it wrote, or when RestoreFrom verifies its source.
+var ErrBackupVerification = errors.New("backup verification failed")
+
+// BackupPhase is the stage of a Backup or RestoreFrom reported to WithBackupProgress.
+type BackupPhase int
+
+const (
+	// BackupCopying copies facts into the backup, or into the store on restore.
+	BackupCopying BackupPhase = iota + 1
+	// BackupVerifying checks the backup.
+	BackupVerifying
+)
+
+// String returns a short name of the phase.
+func (p BackupPhase) String() string {
+	switch p {
+	case BackupCopying:
+		return "copying"
+	case BackupVerifying:
+		return "verifying"
+	default:
+		return "BackupPhase(" + strconv.Itoa(int(p)) + ")"
+	}
+}
+
+// BackupProgress reports the progress of a Backup or RestoreFrom.
+type BackupProgress struct {
+	Phase BackupPhase
+	// Done is the number of facts processed in the phase so far.
+	Done int
+	// Total is the number of facts of the phase, an estimate while a
+	// database copy is in progress, or 0 if unknown.
+	Total int
+}
+
+// BackupOption configures Backup and RestoreFrom.
+type BackupOption func(*backupConfig)
+
+type backupConfig struct {
+	progress func(BackupProgress)
+}
+
+// WithBackupProgress makes Backup and RestoreFrom call fn when a phase starts
+// and ends, and every thousand facts of a logical copy or check in between.
+// fn is called from the goroutine of the operation.
+func WithBackupProgress(fn func(BackupProgress)) BackupOption {
+	return func(c *backupConfig) {
+		c.progress = fn
+	}
+}
+
+func (c *backupConfig) report(phase BackupPhase, done, total int) {
+	if c.progress != nil {
+		c.progress(BackupProgress{Phase: phase, Done: done, Total: total})
+	}
+}
+
+// progressInterval is the number of facts between progress reports.
+const progressInterval = 1000
+
+// sqliteHeader starts every SQLite database file.
+const sqliteHeader = "SQLite format 3\x00"
+
+// Backup writes a consistent snapshot of the store to destPath, which must
+// not exist, and verifies it. Writers may continue while it runs; the
+// snapshot holds the facts of the moment the copy started.
+//
+// SQLite stores are copied with VACUUM INTO, which writes a compact database
+// that opens with NewFactStoreSQLite. The copy must pass PRAGMA
+// integrity_check and Verify. Other stores are written as a logical snapshot
+// in the format of WriteTo, read in a single REPEATABLE READ transaction. The
+// file is read back and must hold the same number of facts with the same
+// hashes. A backup that fails verification is removed and the error wraps
+// ErrBackupVerification.
+func (s *FactStoreDB) Backup(ctx context.Context, destPath string, opts ...BackupOption) error {
+	cfg := &backupConfig{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	if _, err := os.Stat(destPath); err == nil {
+		return fmt.Errorf("backup destination %q already exists", destPath)
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to check backup destination: %w", err)
+	}
+	if query := s.dialect.backupSQL(); query != "" {
+		return s.backupDatabase(ctx, query, destPath, cfg)
+	}
+	return s.backupSnapshot(ctx, destPath, cfg)
+}
+
+// backupDatabase copies the database to destPath with query and checks the copy.
+func (s *FactStoreDB) backupDatabase(ctx context.Context, query, destPath string, cfg *backupConfig) error {
+	total := s.EstimateFactCount()
+	cfg.report(BackupCopying, 0, total)
+	if _, err := s.db.ExecContext(ctx, query, destPath); err != nil {
+		os.Remove(destPath)
+		return fmt.Errorf("failed to copy database: %w", err)
+	}
+	cfg.report(BackupCopying, total, total)
+
+	backup, err := openDatabaseBackup(destPath)
+	if err == nil {
+		err = backup.verifyBackup(ctx, cfg)
+		backup.Close()
+	}
+	if err != nil {
+		os.Remove(destPath)
+		return err
+	}
+	return nil
+}
+
+// openDatabaseBackup opens a database written by Backup read-only, so that
+// checking and restoring it leave the file as it is.
+func openDatabaseBackup(path string) (*FactStoreDB, error) {
+	backup, err := NewFactStoreSQLiteReadOnly(path)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to open %q: %w", ErrBackupVerification, path, err)
+	}
+	return backup.s, nil
+}
+
+// verifyBackup checks the integrity of the database and the rows of its facts table.
+func (s *FactStoreDB) verifyBackup(ctx context.Context, cfg *backupConfig) error {
+	var result string
+	if err := s.db.QueryRowContext(ctx, "PRAGMA integrity_check").Scan(&result); err != nil {
+		return fmt.Errorf("failed to check integrity: %w", err)
+	}
+	if result != "ok" {
+		return fmt.Errorf("%w: integrity check: %s", ErrBackupVerification, result)
+	}
+	total := s.EstimateFactCount()
+	cfg.report(BackupVerifying, 0, total)
+	report, err := s.Verify(ctx)
+	if err != nil {
+		return err
+	}
+	if !report.OK() {
+		return fmt.Errorf("%w: %d of %d rows have issues, the first is %v",
+			ErrBackupVerification, len(report.Issues), report.Rows, report.Issues[0])
+	}
+	cfg.report(BackupVerifying, total, total)
+	return nil
+}
+
+// backupSnapshot writes a logical snapshot to destPath and reads it back.
+func (s *FactStoreDB) backupSnapshot(ctx context.Context, destPath string, cfg *backupConfig) error {
+	f, err := os.OpenFile(destPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to create backup: %w", err)
+	}
+	count, sum, err := s.writeSnapshotFile(ctx, f, cfg)
+	if closeErr := f.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("failed to close backup: %w", closeErr)
+	}
+	if err == nil {
+		err = verifySnapshot(destPath, count, sum, cfg)
+	}
+	if err != nil {
+		os.Remove(destPath)
+		return err
+	}
+	return nil
+}
+
+// writeSnapshotFile writes the snapshot to f and syncs it.
+func (s *FactStoreDB) writeSnapshotFile(ctx context.Context, f *os.File, cfg *backupConfig) (int, uint64, error) {
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to begin snapshot: %w", err)
+	}
+	defer tx.Rollback() // The transaction only reads
+
+	w := bufio.NewWriter(f)
+	count, sum, err := s.writeSnapshot(ctx, tx, w, cfg)
+	if err != nil {
+		return 0, 0, err
+	}
+	if err := w.Flush(); err != nil {
+		return 0, 0, fmt.Errorf("failed to write backup: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		return 0, 0, fmt.Errorf("failed to sync backup: %w", err)
+	}
+	return count, sum, nil
+}
+
+// writeSnapshot writes the facts visible in tx to w in the format of WriteTo
+// and returns their number and the sum of their hashes.
+func (s *FactStoreDB) writeSnapshot(ctx context.Context, tx *sql.Tx, w io.Writer, cfg *backupConfig) (int, uint64, error) {
+	tables := make([]*typedTable, 0, len(s.typed))
+	for _, t := range s.typed {
+		tables = append(tables, t)
+	}
+	sort.Slice(tables, func(i, j int) bool { return tables[i].name < tables[j].name })
+
+	total := 0
+	for _, table := range append([]string{"facts"}, typedTableNames(tables)...) {
+		var n int
+		if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+table).Scan(&n); err != nil {
+			return 0, 0, fmt.Errorf("failed to count facts: %w", err)
+		}
+		total += n
+	}
+	cfg.report(BackupCopying, 0, total)
+
+	enc := jsontext.NewEncoder(w)
+	if err := enc.WriteToken(jsontext.BeginArray); err != nil {
+		return 0, 0, err
+	}
+	count := 0
+	var sum uint64
+	emit := func(fact ast.Atom) error {
+		hash, err := atomHash(fact)
+		if err != nil {
+			return err
+		}
+		if err := (atomJSON{fact}).MarshalJSONTo(enc); err != nil {
+			return err
+		}
+		count++
+		sum += uint64(hash)
+		if count%progressInterval == 0 {
+			cfg.report(BackupCopying, count, total)
+		}
+		return nil
+	}
+
+	rows, err := tx.QueryContext(ctx, "SELECT predicate, "+s.codec.selectSQL(s.dialect)+" FROM facts ORDER BY predicate")
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to query facts: %w", err)
+	}
+	defer rows.Close()
+	var predicate string
+	var args sql.RawBytes
+	for rows.Next() {
+		if err := rows.Scan(&predicate, &args); err != nil {
+			return 0, 0, fmt.Errorf("failed to scan row: %w", err)
+		}
+		pred, err := keyToPredicate(predicate)
+		if err != nil {
+			return 0, 0, err
+		}
+		fact, err := s.codec.decodeArgs(s.dialect, pred, args)
+		if err == nil && s.dict != nil {
+			err = s.dict.resolveArgs(fact.Args)
+		}
+		if err == nil {
+			err = emit(fact)
+		}
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to write fact of %s: %w", predicate, err)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return 0, 0, err
+	}
+	rows.Close()
+
+	for _, t := range tables {
+		if err := s.writeTypedSnapshot(ctx, tx, t, emit); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	if err := enc.WriteToken(jsontext.EndArray); err != nil {
+		return 0, 0, err
+	}
+	cfg.report(BackupCopying, count, total)
+	return count, sum, nil
+}
+
+// writeTypedSnapshot passes the facts of a typed table visible in tx to emit.
+func (s *FactStoreDB) writeTypedSnapshot(ctx context.Context, tx *sql.Tx, t *typedTable, emit func(ast.Atom) error) error {
+	query, _, _, _ := t.buildQuery(s.dialect, ast.NewQuery(t.pred), nil)
+	rows, err := tx.QueryContext(ctx, query)
+	if err != nil {
+		return fmt.Errorf("failed to query facts of typed predicate %v: %w", t.pred, err)
+	}
+	defer rows.Close()
+	sc := t.newScanner()
+	for rows.Next() {
+		if err := rows.Scan(sc.dest...); err != nil {
+			return fmt.Errorf("failed to scan row: %w", err)
+		}
+		fact, err := sc.atom()
+		if err == nil {
+			err = emit(fact)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to write fact of %v: %w", t.pred, err)
+		}
+	}
+	return rows.Err()
+}
+
+func typedTableNames(tables []*typedTable) []string {
+	names := make([]string, len(tables))
+	for i, t := range tables {
+		names[i] = t.name
+	}
+	return names
+}
+
+// verifySnapshot reads the snapshot at path back and compares the number of
+// facts and the sum of their hashes with those written.
+func verifySnapshot(path string, count int, sum uint64, cfg *backupConfig) error {
+	cfg.report(BackupVerifying, 0, count)
+	n := 0
+	var got uint64
+	if err := readSnapshot(path, func(fact ast.Atom) error {
+		hash, err := atomHash(fact)
+		if err != nil {
+			return err
+		}
+		n++
+		got += uint64(hash)
+		if n%progressInterval == 0 {
+			cfg.report(BackupVerifying, n, count)
+		}
+		return nil
+	}); err != nil {
+		return fmt.Errorf("%w: %w", ErrBackupVerification, err)
+	}
+	if n != count || got != sum {
+		return fmt.Errorf("%w: read back %d facts with hash sum %d, wrote %d with %d", ErrBackupVerification, n, got, count, sum)
+	}
+	cfg.report(BackupVerifying, n, count)
+	return nil
+}
+
+// readSnapshot decodes the facts of a file in the format of WriteTo.
+func readSnapshot(path string, fn func(ast.Atom) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	dec := jsontext.NewDecoder(bufio.NewReader(f))
+	tok, err := dec.ReadToken()
+	if err != nil {
+		return fmt.Errorf("failed to read opening token: %w", err)
+	}
+	if tok.Kind() != '[' {
+		return fmt.Errorf("expected JSON array start '[', got %c", tok.Kind())
+	}
+	for dec.PeekKind() != ']' {
+		var aj atomJSON
+		if err := aj.UnmarshalJSONFrom(dec); err != nil {
+			return fmt.Errorf("failed to unmarshal atom: %w", err)
+		}
+		if err := fn(aj.Atom); err != nil {
+			return err
+		}
+	}
+	if _, err := dec.ReadToken(); err != nil {
+		return fmt.Errorf("failed to read closing token: %w", err)
+	}
+	if _, err := dec.ReadToken(); err != io.EOF {
+		return fmt.Errorf("unexpected data after the JSON array")
+	}
+	return nil
+}
+
+// RestoreFrom replaces all facts of the store with those of a backup written
+// by Backup: a SQLite database or a logical snapshot in the format of WriteTo,
+// from any kind of store. The backup is verified first, as by Backup, and the
+// facts are replaced in a single transaction, so that readers see either the
+// old or the restored facts. The facts are streamed from the backup into the
+// transaction, so restoring does not hold them all in memory. Settings of the
+// store such as its codec and typed predicates are kept.
+func (s *FactStoreDB) RestoreFrom(path string, opts ...BackupOption) error {
+	cfg := &backupConfig{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	isDatabase, err := isSQLiteFile(path)
+	if err != nil {
+		return err
+	}
+	var src *restoreSource
+	if isDatabase {
+		src, err = readDatabaseBackup(path, cfg)
+	} else {
+		src, err = readSnapshotBackup(path, cfg)
+	}
+	if err != nil {
+		return err
+	}
+	defer src.close()
+
+	if s.dict != nil {
+		// Interning writes outside of the transaction, which must not wait
+		// for it, so the constants of the backup are interned first.
+		if err := src.each(func(fact ast.Atom, _ *int64) error {
+			if s.typed[fact.Predicate] == nil {
+				s.encodeRow(fact)
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin restore: %w", err)
+	}
+	defer tx.Rollback() // Rollback is a no-op if Commit succeeds
+
+	tables := []string{"facts"}
+	for _, t := range s.typed {
+		tables = append(tables, t.name)
+	}
+	for _, table := range tables {
+		if _, err := tx.Exec("DELETE FROM " + table); err != nil {
+			return fmt.Errorf("failed to clear %s: %w", table, err)
+		}
+	}
+
+	cfg.report(BackupCopying, 0, src.total)
+	done := 0
+	rows := &factRows{}
+	flush := func() error {
+		if err := s.insertRows(tx, rows, func(n int) { cfg.report(BackupCopying, done+n, src.total) }); err != nil {
+			return err
+		}
+		n := len(rows.rows) + len(rows.typedRows)
+		done += n
+		s.writes.Add(int64(n))
+		rows = &factRows{}
+		return nil
+	}
+	if err := src.each(func(fact ast.Atom, storedHash *int64) error {
+		s.appendRow(rows, fact, storedHash)
+		if len(rows.rows)+len(rows.typedRows) < progressInterval {
+			return nil
+		}
+		return flush()
+	}); err != nil {
+		return err
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit restore: %w", err)
+	}
+	return nil
+}
+
+// restoreSource streams the facts of a verified backup to RestoreFrom.
+type restoreSource struct {
+	// total is the number of facts of the backup.
+	total int
+	// each calls fn with every fact of the backup and, if the backup holds
+	// it, the atom_hash it is stored under. The hash is kept on restore: the
+	// JSON codec stores floats with integral values as numbers, so the hash
+	// of the decoded fact may differ.
+	each func(fn func(fact ast.Atom, storedHash *int64) error) error
+	// close releases the backup.
+	close func()
+}
+
+// isSQLiteFile reports whether the file at path is a SQLite database.
+func isSQLiteFile(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, fmt.Errorf("failed to open backup: %w", err)
+	}
+	defer f.Close()
+	header := make([]byte, len(sqliteHeader))
+	if _, err := io.ReadFull(f, header); err != nil {
+		// Too short for a database; leave it to the snapshot reader.
+		return false, nil
+	}
+	return bytes.Equal(header, []byte(sqliteHeader)), nil
+}
+
+// readDatabaseBackup verifies a database backup and returns a source
+// streaming its facts.
+func readDatabaseBackup(path string, cfg *backupConfig) (*restoreSource, error) {
+	backup, err := openDatabaseBackup(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := backup.verifyBackup(context.Background(), cfg); err != nil {
+		backup.Close()
+		return nil, err
+	}
+	return &restoreSource{
+		total: backup.EstimateFactCount(),
+		each:  backup.storedFacts,
+		close: func() { backup.Close() },
+	}, nil
+}
+
+// storedFacts calls fn with every fact of the store and the atom_hash it is
+// stored under. Facts of typed predicates, whose columns keep their types,
+// are passed without a hash.
+func (s *FactStoreDB) storedFacts(fn func(fact ast.Atom, storedHash *int64) error) error {
+	rows, err := s.db.Query("SELECT predicate, atom_hash, " + s.codec.selectSQL(s.dialect) + " FROM facts")
+	if err != nil {
+		return fmt.Errorf("failed to query facts: %w", err)
+	}
+	defer rows.Close()
+	var predicate string
+	var hash int64
+	var args []byte
+	for rows.Next() {
+		if err := rows.Scan(&predicate, &hash, &args); err != nil {
+			return fmt.Errorf("failed to scan row: %w", err)
+		}
+		pred, err := keyToPredicate(predicate)
+		if err != nil {
+			return err
+		}
+		fact, err := s.codec.decodeArgs(s.dialect, pred, args)
+		if err == nil && s.dict != nil {
+			err = s.dict.resolveArgs(fact.Args)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to decode fact of %v: %w", pred, err)
+		}
+		if err := fn(fact, &hash); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, t := range s.typed {
+		if err := s.GetFacts(ast.NewQuery(t.pred), func(fact ast.Atom) error {
+			return fn(fact, nil)
+		}); err != nil {
+			return fmt.Errorf("failed to read facts of %v: %w", t.pred, err)
+		}
+	}
+	return nil
+}
+
+// readSnapshotBackup verifies a logical snapshot, which it does if the whole
+// file decodes, and returns a source reading it again.
+func readSnapshotBackup(path string, cfg *backupConfig) (*restoreSource, error) {
+	cfg.report(BackupVerifying, 0, 0)
+	n := 0
+	if err := readSnapshot(path, func(fact ast.Atom) error {
+		if _, err := atomHash(fact); err != nil {
+			return err
+		}
+		n++
+		if n%progressInterval == 0 {
+			cfg.report(BackupVerifying, n, 0)
+		}
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrBackupVerification, err)
+	}
+	cfg.report(BackupVerifying, n, n)
+	return &restoreSource{
+		total: n,
+		each: func(fn func(ast.Atom, *int64) error) error {
+			return readSnapshot(path, func(fact ast.Atom) error { return fn(fact, nil) })
+		},
+		close: func() {},
+	}, nil
+}
@@ -0,0 +1,214 @@
+package factstoredb
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/mangle/ast"
+)
+
+func TestSQLiteBackup(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	typedPred := ast.PredicateSym{Symbol: "edge", Arity: 2}
+	store, err := NewFactStoreSQLite(":memory:", WithTypedPredicate(typedPred, ColumnName, ColumnNumber))
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer store.Close()
+	facts := []ast.Atom{
+		evalAtom(`p(/a, "x", [1, 2])`),
+		evalAtom("p(/b, 2.5, {/k: /v})"),
+		// Integral floats are stored as numbers and must pass verification.
+		evalAtom("p(/d, 2.0, [3.0])"),
+		evalAtom("edge(/a, 3)"),
+	}
+	for _, fact := range facts {
+		store.Add(fact)
+	}
+
+	path := filepath.Join(dir, "backup.db")
+	var phases []BackupProgress
+	if err := store.Backup(ctx, path, WithBackupProgress(func(p BackupProgress) {
+		phases = append(phases, p)
+	})); err != nil {
+		t.Fatalf("Backup() error = %v", err)
+	}
+	if last := phases[len(phases)-1]; last.Phase != BackupVerifying || last.Done != len(facts) || last.Total != len(facts) {
+		t.Errorf("last progress = %+v, want %d of %d facts verified", last, len(facts), len(facts))
+	}
+	if err := store.Backup(ctx, path); err == nil {
+		t.Error("Backup() to an existing file succeeded, want error")
+	}
+
+	before, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read backup: %v", err)
+	}
+	backup, err := NewFactStoreSQLiteReadOnly(path)
+	if err != nil {
+		t.Fatalf("Failed to open backup: %v", err)
+	}
+	for _, fact := range facts {
+		if !backup.Contains(fact) {
+			t.Errorf("backup does not contain %v", fact)
+		}
+	}
+	backup.Close()
+
+	// Restoring replaces the facts written since the backup.
+	extra := evalAtom("p(/c, 1, 2)")
+	store.Add(extra)
+	store.Remove(facts[0])
+	var restored int
+	if err := store.RestoreFrom(path, WithBackupProgress(func(p BackupProgress) {
+		if p.Phase == BackupCopying {
+			restored = p.Done
+		}
+	})); err != nil {
+		t.Fatalf("RestoreFrom() error = %v", err)
+	}
+	if restored != len(facts) {
+		t.Errorf("RestoreFrom() reported %d facts copied, want %d", restored, len(facts))
+	}
+	for _, fact := range facts {
+		if !store.Contains(fact) {
+			t.Errorf("restored store does not contain %v", fact)
+		}
+	}
+	if store.Contains(extra) || store.EstimateFactCount() != len(facts) {
+		t.Errorf("restored store has %d facts, want %d", store.EstimateFactCount(), len(facts))
+	}
+	// Checking and restoring the backup leave its file as it is.
+	if after, err := os.ReadFile(path); err != nil || !bytes.Equal(before, after) {
+		t.Errorf("RestoreFrom() changed the backup file (error = %v)", err)
+	}
+
+	// Stores written by the first release restore too.
+	target, err := NewFactStoreSQLite(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer target.Close()
+	baseline, _ := copyFixture(t, "baseline_bundle.db")
+	if err := target.RestoreFrom(baseline); err != nil {
+		t.Fatalf("RestoreFrom() of a version 1 store error = %v", err)
+	}
+	if fact := evalAtom("config(/s1, {/k: 1, /v: [/x]})"); !target.Contains(fact) || target.EstimateFactCount() != 5 {
+		t.Errorf("store restored from a version 1 store has %d facts, want 5 with %v", target.EstimateFactCount(), fact)
+	}
+}
+
+func TestSnapshotBackup(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	store, err := NewFactStoreSQLite(":memory:", WithCodec(CodecBinary))
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer store.Close()
+	facts := []ast.Atom{
+		evalAtom(`p(/a, "x", [1, 2])`),
+		evalAtom(`q(/b, b"\x00\x01")`),
+	}
+	for _, fact := range facts {
+		store.Add(fact)
+	}
+
+	// Logical snapshots are what Backup writes for Postgres stores.
+	path := filepath.Join(dir, "backup.json")
+	if err := store.backupSnapshot(ctx, path, &backupConfig{}); err != nil {
+		t.Fatalf("backupSnapshot() error = %v", err)
+	}
+
+	target, err := NewFactStoreSQLite(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer target.Close()
+	target.Add(evalAtom("r(/gone)"))
+	if err := target.RestoreFrom(path); err != nil {
+		t.Fatalf("RestoreFrom() error = %v", err)
+	}
+	for _, fact := range facts {
+		if !target.Contains(fact) {
+			t.Errorf("restored store does not contain %v", fact)
+		}
+	}
+	if got := target.EstimateFactCount(); got != len(facts) {
+		t.Errorf("restored store has %d facts, want %d", got, len(facts))
+	}
+
+	// A truncated snapshot fails verification and leaves the store alone.
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read snapshot: %v", err)
+	}
+	truncated := filepath.Join(dir, "truncated.json")
+	if err := os.WriteFile(truncated, data[:len(data)/2], 0o644); err != nil {
+		t.Fatalf("Failed to write snapshot: %v", err)
+	}
+	if err := target.RestoreFrom(truncated); !errors.Is(err, ErrBackupVerification) {
+		t.Errorf("RestoreFrom() of a truncated snapshot: error = %v, want ErrBackupVerification", err)
+	}
+	if got := target.EstimateFactCount(); got != len(facts) {
+		t.Errorf("store has %d facts after a failed restore, want %d", got, len(facts))
+	}
+}
+
+func TestRestoreInBatches(t *testing.T) {
+	store, err := NewFactStoreSQLite(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer store.Close()
+	facts := prepareTestFacts(2*progressInterval + 500)
+	for _, fact := range facts {
+		store.Add(fact)
+	}
+	path := filepath.Join(t.TempDir(), "backup.db")
+	if err := store.Backup(context.Background(), path); err != nil {
+		t.Fatalf("Backup() error = %v", err)
+	}
+
+	// Stores with interning intern the constants of the backup before the
+	// transaction that inserts the facts.
+	for _, tc := range []struct {
+		connStr string
+		opts    []StoreOption
+	}{
+		{":memory:", nil},
+		{":memory:", []StoreOption{WithInterning(0)}},
+		{filepath.Join(t.TempDir(), "target.db"), []StoreOption{WithInterning(0)}},
+	} {
+		target, err := NewFactStoreSQLite(tc.connStr, tc.opts...)
+		if err != nil {
+			t.Fatalf("Failed to create store: %v", err)
+		}
+		defer target.Close()
+		var last BackupProgress
+		if err := target.RestoreFrom(path, WithBackupProgress(func(p BackupProgress) {
+			if p.Phase == BackupCopying {
+				if p.Done < last.Done {
+					t.Errorf("progress went back from %d to %d", last.Done, p.Done)
+				}
+				last = p
+			}
+		})); err != nil {
+			t.Fatalf("RestoreFrom() into %s error = %v", tc.connStr, err)
+		}
+		if last.Done != len(facts) || last.Total != len(facts) {
+			t.Errorf("last progress = %+v, want %d of %d facts copied", last, len(facts), len(facts))
+		}
+		if got := target.EstimateFactCount(); got != len(facts) {
+			t.Errorf("restored store %s has %d facts, want %d", tc.connStr, got, len(facts))
+		}
+		if !target.Contains(facts[len(facts)-1]) {
+			t.Errorf("restored store %s does not contain %v", tc.connStr, facts[len(facts)-1])
+		}
+	}
+}
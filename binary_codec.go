@@ -0,0 +1,298 @@
+package factstoredb
+
+import (
+	"database/sql/driver"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/google/mangle/ast"
+	"modernc.org/sqlite"
+)
+
+// Type tags of the binary codec. The args_bin column holds the uvarint number
+// of arguments followed by the arguments. Every constant starts with its tag:
+//
+//	name, string, bytes  uvarint length, then the raw bytes
+//	number               zigzag varint
+//	float                8 bytes, IEEE 754 little endian
+//	list                 uvarint length, then the elements
+//	pair                 the first and second element
+//	map, struct          uvarint entry count, then key and value of each entry
+//	ref                  uvarint id in the constants dictionary
+//
+// Unlike JSON, floats with integral values keep their type.
+const (
+	binName   byte = 1
+	binString byte = 2
+	binBytes  byte = 3
+	binNumber byte = 4
+	binFloat  byte = 5
+	binList   byte = 6
+	binPair   byte = 7
+	binMap    byte = 8
+	binStruct byte = 9
+	binRef    byte = 10
+)
+
+var errTruncatedBinary = errors.New("truncated binary args")
+
+// binaryArgsFunction names the SQLite function that returns the JSON keys of
+// binary args, from which the args column of binary codec stores is computed.
+const binaryArgsFunction = "factstore_binary_args"
+
+func init() {
+	sqlite.MustRegisterDeterministicScalarFunction(binaryArgsFunction, 1, binaryArgsJSON)
+}
+
+// binaryArgsJSON returns the JSON text of the binary args in args[0], or NULL
+// if they do not decode, so that such rows stay readable by Verify. Constants
+// interned in a dictionary keep their references, which the keys hold too.
+func binaryArgsJSON(_ *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+	data, ok := args[0].([]byte)
+	if !ok {
+		return nil, nil
+	}
+	atom, err := decodeBinaryArgs(ast.PredicateSym{}, data)
+	if err != nil {
+		return nil, nil
+	}
+	_, keys, err := atomToRowForInsertBinary(atom)
+	if err != nil {
+		return nil, nil
+	}
+	return string(keys), nil
+}
+
+// encodeBinaryRow returns the atom_hash and the binary codec encoding of the args of atom.
+func encodeBinaryRow(atom ast.Atom) (int64, []byte, error) {
+	hashResult := predicateHash(atom.Predicate)
+	buf := binary.AppendUvarint(make([]byte, 0, 16*len(atom.Args)+1), uint64(len(atom.Args)))
+	for _, arg := range atom.Args {
+		c, ok := arg.(ast.Constant)
+		if !ok {
+			return 0, nil, fmt.Errorf("evaluation produced something that is not a value: %v %T", arg, arg)
+		}
+		var err error
+		if buf, err = appendBinaryConstant(buf, c); err != nil {
+			return 0, nil, fmt.Errorf("failed to encode arg: %w", err)
+		}
+		if hashResult, err = hashArg(hashResult, c); err != nil {
+			return 0, nil, err
+		}
+	}
+	return int64(hashResult), buf, nil
+}
+
+// appendBinaryConstant appends the binary codec encoding of c to buf.
+func appendBinaryConstant(buf []byte, c ast.Constant) ([]byte, error) {
+	switch c.Type {
+	case ast.NameType, ast.StringType, ast.BytesType:
+		tag := binString
+		if c.Type == ast.NameType {
+			tag = binName
+		} else if c.Type == ast.BytesType {
+			tag = binBytes
+		}
+		buf = append(buf, tag)
+		buf = binary.AppendUvarint(buf, uint64(len(c.Symbol)))
+		return append(buf, c.Symbol...), nil
+
+	case ast.NumberType:
+		num, err := c.NumberValue()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get number value: %w", err)
+		}
+		return binary.AppendVarint(append(buf, binNumber), num), nil
+
+	case ast.Float64Type:
+		flt, err := c.Float64Value()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get float64 value: %w", err)
+		}
+		return binary.LittleEndian.AppendUint64(append(buf, binFloat), math.Float64bits(flt)), nil
+
+	case ast.ListShape:
+		var elems []ast.Constant
+		if _, err := c.ListValues(func(elem ast.Constant) error {
+			elems = append(elems, elem)
+			return nil
+		}, func() error { return nil }); err != nil {
+			return nil, fmt.Errorf("failed to serialize list: %w", err)
+		}
+		buf = binary.AppendUvarint(append(buf, binList), uint64(len(elems)))
+		return appendBinaryConstants(buf, elems)
+
+	case ast.PairShape:
+		fst, snd, err := c.PairValue()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get pair value: %w", err)
+		}
+		return appendBinaryConstants(append(buf, binPair), []ast.Constant{fst, snd})
+
+	case ast.MapShape, ast.StructShape:
+		var entries []ast.Constant
+		collect := func(key, val ast.Constant) error {
+			entries = append(entries, key, val)
+			return nil
+		}
+		tag := binMap
+		var err error
+		if c.Type == ast.MapShape {
+			_, err = c.MapValues(collect, func() error { return nil })
+		} else {
+			tag = binStruct
+			_, err = c.StructValues(collect, func() error { return nil })
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to iterate map/struct: %w", err)
+		}
+		buf = binary.AppendUvarint(append(buf, tag), uint64(len(entries)/2))
+		return appendBinaryConstants(buf, entries)
+
+	case refType:
+		return binary.AppendUvarint(append(buf, binRef), uint64(c.NumValue)), nil
+
+	default:
+		return nil, fmt.Errorf("unknown constant type: %d", c.Type)
+	}
+}
+
+// appendBinaryConstants appends the encodings of cs to buf, one after another.
+func appendBinaryConstants(buf []byte, cs []ast.Constant) ([]byte, error) {
+	for _, c := range cs {
+		var err error
+		if buf, err = appendBinaryConstant(buf, c); err != nil {
+			return nil, err
+		}
+	}
+	return buf, nil
+}
+
+// decodeBinaryArgs decodes the args column written by encodeBinaryRow into an ast.Atom of pred.
+// It does not retain data, so data may be a reused scan buffer.
+func decodeBinaryArgs(pred ast.PredicateSym, data []byte) (ast.Atom, error) {
+	n, size := binary.Uvarint(data)
+	if size <= 0 || n > uint64(len(data)) {
+		return ast.Atom{}, errTruncatedBinary
+	}
+	data = data[size:]
+	args := make([]ast.BaseTerm, n)
+	for i := range args {
+		c, m, err := decodeBinaryConstant(data)
+		if err != nil {
+			return ast.Atom{}, fmt.Errorf("failed to decode arg %d: %w", i, err)
+		}
+		args[i] = c
+		data = data[m:]
+	}
+	if len(data) != 0 {
+		return ast.Atom{}, fmt.Errorf("%d trailing bytes after binary args", len(data))
+	}
+	return ast.Atom{Predicate: pred, Args: args}, nil
+}
+
+// decodeBinaryConstant decodes the constant at the start of data and returns
+// the number of bytes it occupies.
+func decodeBinaryConstant(data []byte) (ast.Constant, int, error) {
+	if len(data) == 0 {
+		return ast.Constant{}, 0, errTruncatedBinary
+	}
+	tag, rest := data[0], data[1:]
+	switch tag {
+	case binName, binString, binBytes:
+		n, size := binary.Uvarint(rest)
+		if size <= 0 || n > uint64(len(rest)-size) {
+			return ast.Constant{}, 0, errTruncatedBinary
+		}
+		// Converting to a string copies the bytes out of the scan buffer.
+		str := string(rest[size : size+int(n)])
+		end := 1 + size + int(n)
+		switch tag {
+		case binName:
+			c, err := ast.Name(str)
+			if err != nil {
+				return ast.Constant{}, 0, fmt.Errorf("failed to create name from %q: %w", str, err)
+			}
+			return c, end, nil
+		case binString:
+			return ast.String(str), end, nil
+		default:
+			return ast.Bytes([]byte(str)), end, nil
+		}
+
+	case binNumber:
+		num, size := binary.Varint(rest)
+		if size <= 0 {
+			return ast.Constant{}, 0, errTruncatedBinary
+		}
+		return ast.Number(num), 1 + size, nil
+
+	case binFloat:
+		if len(rest) < 8 {
+			return ast.Constant{}, 0, errTruncatedBinary
+		}
+		return ast.Float64(math.Float64frombits(binary.LittleEndian.Uint64(rest))), 9, nil
+
+	case binList:
+		n, size := binary.Uvarint(rest)
+		if size <= 0 || n > uint64(len(rest)) {
+			return ast.Constant{}, 0, errTruncatedBinary
+		}
+		elems, m, err := decodeBinaryConstants(rest[size:], int(n))
+		if err != nil {
+			return ast.Constant{}, 0, fmt.Errorf("failed to decode list element: %w", err)
+		}
+		return ast.List(elems), 1 + size + m, nil
+
+	case binPair:
+		elems, m, err := decodeBinaryConstants(rest, 2)
+		if err != nil {
+			return ast.Constant{}, 0, fmt.Errorf("failed to decode pair: %w", err)
+		}
+		c, err := applyFnConstant("fn:pair", elems)
+		return c, 1 + m, err
+
+	case binMap, binStruct:
+		n, size := binary.Uvarint(rest)
+		if size <= 0 || n > uint64(len(rest)) {
+			return ast.Constant{}, 0, errTruncatedBinary
+		}
+		entries, m, err := decodeBinaryConstants(rest[size:], 2*int(n))
+		if err != nil {
+			return ast.Constant{}, 0, fmt.Errorf("failed to decode map/struct entry: %w", err)
+		}
+		fn := "fn:map"
+		if tag == binStruct {
+			fn = "fn:struct"
+		}
+		c, err := applyFnConstant(fn, entries)
+		return c, 1 + size + m, err
+
+	case binRef:
+		id, size := binary.Uvarint(rest)
+		if size <= 0 {
+			return ast.Constant{}, 0, errTruncatedBinary
+		}
+		return refConstant(int64(id)), 1 + size, nil
+
+	default:
+		return ast.Constant{}, 0, fmt.Errorf("unknown binary type tag %d", tag)
+	}
+}
+
+// decodeBinaryConstants decodes n consecutive constants from the start of data.
+func decodeBinaryConstants(data []byte, n int) ([]ast.Constant, int, error) {
+	cs := make([]ast.Constant, n)
+	offset := 0
+	for i := range cs {
+		c, m, err := decodeBinaryConstant(data[offset:])
+		if err != nil {
+			return nil, 0, err
+		}
+		cs[i] = c
+		offset += m
+	}
+	return cs, offset, nil
+}
@@ -0,0 +1,179 @@
+package factstoredb
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"modernc.org/sqlite"
+)
+
+// Clone returns a new store holding a copy of the facts of s, which is
+// independent of s: writes to either store do not show in the other. The
+// copy is made by the database rather than fact by fact, so it is much
+// faster than merging s into an empty store:
+//
+//   - in-memory SQLite stores are copied into a new in-memory database with
+//     the SQLite online backup API;
+//   - SQLite stores on disk are copied the same way into a temporary file,
+//     which is removed when the clone is closed;
+//   - Postgres stores are copied table by table into a new schema of the
+//     same database, which is dropped when the clone is closed. Only stores
+//     opened with NewFactStorePostgreSQL can be cloned.
+//
+// The clone has the durability profile and PRAGMAs of s.
+func (s *FactStoreDB) Clone(ctx context.Context) (*FactStoreDB, error) {
+	var clone *FactStoreDB
+	var err error
+	if s.dialect.backupSQL() == "" {
+		clone, err = s.cloneSchema(ctx)
+	} else {
+		clone, err = s.cloneDatabase(ctx)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to clone store: %w", err)
+	}
+	return clone, nil
+}
+
+// cloneOptions returns the options opening a clone of s. The clone of a
+// read-only store is writable and has the default durability.
+func (s *FactStoreDB) cloneOptions() []StoreOption {
+	var opts []StoreOption
+	if s.durability != "" {
+		opts = append(opts, WithDurability(s.durability))
+	}
+	for key, value := range s.pragmas {
+		if key != "query_only" {
+			opts = append(opts, WithPragma(key, value))
+		}
+	}
+	return opts
+}
+
+// cloneDatabase clones a SQLite store with the online backup API.
+func (s *FactStoreDB) cloneDatabase(ctx context.Context) (*FactStoreDB, error) {
+	var file string
+	if err := s.db.QueryRowContext(ctx, "SELECT file FROM pragma_database_list WHERE name = 'main'").Scan(&file); err != nil {
+		return nil, fmt.Errorf("failed to locate database: %w", err)
+	}
+
+	if file == "" {
+		connStr := newInMemoryConnStr()
+		return openSQLite(connStr, func(db *sql.DB) error {
+			// The connection keeps the in-memory database alive while it is
+			// copied; the pool keeps it open afterwards.
+			conn, err := db.Conn(ctx)
+			if err != nil {
+				return fmt.Errorf("failed to connect to SQLite: %w", err)
+			}
+			defer conn.Close()
+			return s.copyTo(ctx, connStr)
+		}, s.cloneOptions())
+	}
+
+	dir, err := os.MkdirTemp("", "factstoredb-clone")
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, "facts.db")
+	if err := s.copyTo(ctx, path); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	clone, err := openSQLite(path, nil, s.cloneOptions())
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	clone.tempDir = dir
+	return clone, nil
+}
+
+// copyTo copies the database of s to the SQLite database at dstURI.
+func (s *FactStoreDB) copyTo(ctx context.Context, dstURI string) error {
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer conn.Close()
+	return conn.Raw(func(driverConn any) error {
+		return copyDatabase(driverConn, dstURI)
+	})
+}
+
+// copyDatabase copies the main database of the SQLite driver connection
+// driverConn to the database at dstURI with the online backup API.
+func copyDatabase(driverConn any, dstURI string) error {
+	c, ok := driverConn.(interface {
+		NewBackup(dstURI string) (*sqlite.Backup, error)
+	})
+	if !ok {
+		return errors.New("the SQLite driver cannot copy databases")
+	}
+	backup, err := c.NewBackup(dstURI)
+	if err != nil {
+		return fmt.Errorf("failed to start copy: %w", err)
+	}
+	if _, err := backup.Step(-1); err != nil {
+		backup.Finish()
+		return fmt.Errorf("failed to copy database: %w", err)
+	}
+	if err := backup.Finish(); err != nil {
+		return fmt.Errorf("failed to finish copy: %w", err)
+	}
+	return nil
+}
+
+// cloneSchema clones a Postgres store into a new schema, copying all tables
+// in one snapshot.
+func (s *FactStoreDB) cloneSchema(ctx context.Context) (*FactStoreDB, error) {
+	if s.connStr == "" {
+		return nil, errors.New("only stores opened with NewFactStorePostgreSQL can be cloned")
+	}
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return nil, err
+	}
+	schema := "factstore_clone_" + hex.EncodeToString(suffix)
+
+	tables := []string{"factstore_meta", "facts"}
+	if s.dict != nil {
+		tables = append(tables, "constants")
+	}
+	for _, t := range s.typed {
+		tables = append(tables, t.name)
+	}
+
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead})
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, "CREATE SCHEMA "+quoteIdent(schema)); err != nil {
+		return nil, fmt.Errorf("failed to create schema: %w", err)
+	}
+	for _, table := range tables {
+		for _, stmt := range s.dialect.cloneTableSQL(schema, table) {
+			if _, err := tx.ExecContext(ctx, stmt); err != nil {
+				return nil, fmt.Errorf("failed to copy table %s: %w", table, err)
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	clone, err := NewFactStorePostgreSQL(withPostgresParam(s.connStr, "search_path", schema), s.cloneOptions()...)
+	if err != nil {
+		s.db.Exec("DROP SCHEMA " + quoteIdent(schema) + " CASCADE")
+		return nil, err
+	}
+	clone.dropSchema = schema
+	return clone, nil
+}
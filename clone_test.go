@@ -0,0 +1,64 @@
+package factstoredb
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/mangle/ast"
+)
+
+func TestSQLiteClone(t *testing.T) {
+	typedPred := ast.PredicateSym{Symbol: "edge", Arity: 2}
+	facts := []ast.Atom{
+		evalAtom(`p(/a, "a long string", [1, 2])`),
+		evalAtom("p(/b, 2.5, /c)"),
+		evalAtom("edge(/a, /b)"),
+	}
+	for _, connStr := range []string{":memory:", filepath.Join(t.TempDir(), "facts.db")} {
+		store, err := NewFactStoreSQLite(connStr, WithInterning(8), WithTypedPredicate(typedPred, ColumnName, ColumnName), WithDurability(DurabilityStrict))
+		if err != nil {
+			t.Fatalf("Failed to create store: %v", err)
+		}
+		for _, fact := range facts {
+			store.Add(fact)
+		}
+
+		clone, err := store.Clone(context.Background())
+		if err != nil {
+			t.Fatalf("Clone() of %s: error = %v", connStr, err)
+		}
+		for _, fact := range facts {
+			if !clone.Contains(fact) {
+				t.Errorf("clone of %s does not contain %v", connStr, fact)
+			}
+		}
+		if got := clone.Config(); got.Durability != DurabilityStrict || !got.Interning {
+			t.Errorf("Config() of clone of %s = %+v, want strict durability and interning", connStr, got)
+		}
+
+		// Writes to either store do not show in the other.
+		clone.Add(evalAtom("edge(/b, /c)"))
+		clone.Remove(facts[0])
+		store.Add(evalAtom("q(/new)"))
+		if store.Contains(evalAtom("edge(/b, /c)")) || !store.Contains(facts[0]) {
+			t.Errorf("write to clone of %s shows in the original", connStr)
+		}
+		if clone.Contains(evalAtom("q(/new)")) {
+			t.Errorf("write to %s shows in the clone", connStr)
+		}
+		if got := clone.EstimateFactCount(); got != len(facts) {
+			t.Errorf("EstimateFactCount() of clone of %s = %d, want %d", connStr, got, len(facts))
+		}
+
+		dir := clone.tempDir
+		clone.Close()
+		if dir != "" {
+			if _, err := os.Stat(dir); !os.IsNotExist(err) {
+				t.Errorf("temporary directory %s of clone exists after Close", dir)
+			}
+		}
+		store.Close()
+	}
+}
@@ -0,0 +1,155 @@
+package factstoredb
+
+import (
+	"fmt"
+
+	"github.com/google/mangle/ast"
+)
+
+// Codec names an encoding of the args column. The codec of a store is
+// recorded in its metadata when the store is created, and a store can only
+// be opened with the codec it was written with.
+type Codec string
+
+const (
+	// CodecJSON stores args in the database's native JSON type (JSONB on
+	// both SQLite and PostgreSQL). All query options are evaluated in SQL.
+	// It is the default for new stores.
+	CodecJSON Codec = "json"
+	// CodecBinary stores args in a compact, type-tagged binary format with
+	// varint-encoded numbers, which keeps floats with integral values apart
+	// from numbers. Only the binary column, args_bin, is stored. On SQLite,
+	// args is a virtual column computing their JSON keys as for CodecJSON,
+	// so that pattern constants and all query options are evaluated in SQL
+	// and can be indexed alike. Pattern constants are also compared with the
+	// decoded facts, so that an integral float only matches floats; Where
+	// and NotExists filters, and the values that queries return from the
+	// keys, such as Aggregate groups and graph nodes, have the types of
+	// CodecJSON. PostgreSQL stores cannot use it.
+	CodecBinary Codec = "binary"
+)
+
+// WithCodec selects the codec of a new store. When opening an existing store,
+// the recorded codec is used if no codec is given, and a different codec is an error.
+func WithCodec(c Codec) StoreOption {
+	return func(cfg *config) {
+		cfg.codec = c
+	}
+}
+
+// argCodec encodes and decodes the args of facts. The args column always
+// holds the dialect's JSON, which SQL filters and indexes read; codecs may
+// store the args in their own format in another column, from which the args
+// column is then computed and facts are decoded.
+type argCodec interface {
+	// name returns the codec as recorded in the store metadata.
+	name() Codec
+	// encodeRow returns the atom_hash of atom and the value of its stored
+	// args column. The hash does not depend on the codec.
+	encodeRow(atom ast.Atom) (int64, []byte, error)
+	// columnType returns the SQL type the args column was created with,
+	// before migrations.
+	columnType(d dialect) string
+	// column returns the name of the column the codec stores args in.
+	column() string
+	// valueSQL wraps the placeholder an args value is bound to on insert.
+	valueSQL(d dialect, placeholder string) string
+	// selectSQL returns the expression selecting args in the form decodeArgs reads.
+	selectSQL(d dialect) string
+	// decodeArgs decodes the args column selected through selectSQL.
+	decodeArgs(d dialect, pred ast.PredicateSym, data []byte) (ast.Atom, error)
+	// keepsFloats reports whether decodeArgs tells floats with integral
+	// values apart from numbers. Otherwise they decode as numbers.
+	keepsFloats() bool
+}
+
+// jsonCodec stores args as JSON only.
+type jsonCodec struct{}
+
+func (jsonCodec) name() Codec { return CodecJSON }
+
+func (jsonCodec) encodeRow(atom ast.Atom) (int64, []byte, error) {
+	return atomToRowForInsertBinary(atom)
+}
+
+func (jsonCodec) columnType(d dialect) string { return d.jsonArgsType() }
+
+func (jsonCodec) column() string { return "args" }
+
+func (jsonCodec) valueSQL(d dialect, placeholder string) string {
+	return d.jsonArgsValueSQL(placeholder)
+}
+
+func (jsonCodec) selectSQL(d dialect) string { return d.argsSQL() }
+
+func (jsonCodec) decodeArgs(d dialect, pred ast.PredicateSym, data []byte) (ast.Atom, error) {
+	return d.decodeArgs(pred, data)
+}
+
+func (jsonCodec) keepsFloats() bool { return false }
+
+// binaryCodec stores args in the format of encodeBinaryRow in args_bin, from
+// which the virtual args column computes their JSON keys.
+type binaryCodec struct{}
+
+func (binaryCodec) name() Codec { return CodecBinary }
+
+func (binaryCodec) encodeRow(atom ast.Atom) (int64, []byte, error) {
+	return encodeBinaryRow(atom)
+}
+
+// columnType is the type of the binary args column of stores created before
+// the keys were computed; migration 3 renames it to args_bin.
+func (binaryCodec) columnType(d dialect) string { return d.blobType() }
+
+func (binaryCodec) column() string { return "args_bin" }
+
+func (binaryCodec) valueSQL(d dialect, placeholder string) string {
+	return placeholder
+}
+
+func (binaryCodec) selectSQL(d dialect) string { return "args_bin" }
+
+func (binaryCodec) decodeArgs(d dialect, pred ast.PredicateSym, data []byte) (ast.Atom, error) {
+	return decodeBinaryArgs(pred, data)
+}
+
+func (binaryCodec) keepsFloats() bool { return true }
+
+// codecs lists the available codecs by name.
+var codecs = map[Codec]argCodec{
+	CodecJSON:   jsonCodec{},
+	CodecBinary: binaryCodec{},
+}
+
+// metaCodecKey is the factstore_meta key recording the codec.
+const metaCodecKey = "codec"
+
+// resolveCodec returns the codec to open the store with, recording it for new
+// stores. Stores created before the codec was recorded hold JSON.
+func (s *FactStoreDB) resolveCodec(configured Codec, existed bool) (argCodec, error) {
+	if configured != "" {
+		// Refuse the codec before it is recorded.
+		if _, err := s.lookupCodec(configured); err != nil {
+			return nil, err
+		}
+	}
+	name, err := s.resolveSetting(metaCodecKey, string(configured), string(CodecJSON), string(CodecJSON), existed)
+	if err != nil {
+		return nil, err
+	}
+	return s.lookupCodec(Codec(name))
+}
+
+// lookupCodec returns the codec named name if the dialect supports it.
+func (s *FactStoreDB) lookupCodec(name Codec) (argCodec, error) {
+	codec, ok := codecs[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown codec %q", name)
+	}
+	if codec.column() != "args" && s.dialect.binaryArgsColumnSQL() == "" {
+		// The args column cannot be computed from the binary column.
+		return nil, fmt.Errorf("codec %q is not supported by this database", name)
+	}
+	return codec, nil
+}
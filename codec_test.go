@@ -0,0 +1,306 @@
+package factstoredb
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/mangle/ast"
+	"github.com/google/mangle/factstore"
+)
+
+// newBinarySQLiteStore is a factory for in-memory SQLite stores using the binary codec.
+func newBinarySQLiteStore() (*FactStoreDB, error) {
+	return NewFactStoreSQLite(":memory:", WithCodec(CodecBinary))
+}
+
+// TestSQLiteBinaryCodec runs the shared suite against stores using the binary codec.
+func TestSQLiteBinaryCodec(t *testing.T) {
+	runSuite(t, func() (factstore.FactStoreWithRemove, error) {
+		return newBinarySQLiteStore()
+	})
+
+	t.Run("ReadWrite", func(t *testing.T) {
+		runReadWriteTest(t, newBinarySQLiteStore)
+	})
+
+	t.Run("Queries", func(t *testing.T) {
+		store, err := newBinarySQLiteStore()
+		if err != nil {
+			t.Fatalf("Failed to create store: %v", err)
+		}
+		t.Cleanup(func() { store.Close() })
+
+		store.Add(evalAtom("edge(/a, /b)"))
+		store.Add(evalAtom("edge(/b, /b)"))
+		store.Add(evalAtom("edge(/b, /c)"))
+
+		ok, err := store.Exists(atom("edge(/b, X)"))
+		if err != nil || !ok {
+			t.Errorf("Exists(edge(/b, X)) = %v, %v, want true", ok, err)
+		}
+		ok, err = store.Exists(atom("edge(/c, X)"))
+		if err != nil || ok {
+			t.Errorf("Exists(edge(/c, X)) = %v, %v, want false", ok, err)
+		}
+
+		var got []string
+		err = store.GetBindings(atom("edge(X, X)"), func(subst ast.ConstSubstMap) error {
+			got = append(got, subst[ast.Variable{Symbol: "X"}].String())
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("GetBindings() error = %v", err)
+		}
+		if len(got) != 1 || got[0] != "/b" {
+			t.Errorf("GetBindings(edge(X, X)) = %v, want [/b]", got)
+		}
+
+		// Query options are evaluated in SQL over the JSON keys.
+		got = nil
+		if err := store.Query(atom("edge(X, Y)"), func(a ast.Atom) error {
+			got = append(got, a.String())
+			return nil
+		}, Where(HasNamePrefix(0, "/a"))); err != nil || len(got) != 1 || got[0] != "edge(/a,/b)" {
+			t.Errorf("Query() with Where = %v, %v, want [edge(/a,/b)]", got, err)
+		}
+		groups, err := store.Aggregate(atom("edge(X, Y)"), []ast.Variable{{Symbol: "X"}}, []Aggregate{Count()})
+		if err != nil || len(groups) != 2 {
+			t.Errorf("Aggregate() = %v, %v, want 2 groups", groups, err)
+		}
+		reached, err := store.Reachable(ast.PredicateSym{Symbol: "edge", Arity: 2}, name("/a"), 0)
+		if err != nil || len(reached) != 2 {
+			t.Errorf("Reachable(/a) = %v, %v, want /b and /c", reached, err)
+		}
+		if err := store.CreateArgIndex(0); err != nil {
+			t.Errorf("CreateArgIndex() error = %v", err)
+		}
+
+		// Floats with integral values keep their type and match as constants.
+		store.Add(ast.NewAtom("reading", name("/a"), ast.Float64(2)))
+		store.Add(ast.NewAtom("reading", name("/b"), ast.Number(2)))
+		var readings []ast.Atom
+		if err := store.GetFacts(ast.NewAtom("reading", ast.Variable{Symbol: "X"}, ast.Float64(2)), func(a ast.Atom) error {
+			readings = append(readings, a)
+			return nil
+		}); err != nil {
+			t.Fatalf("GetFacts() error = %v", err)
+		}
+		if len(readings) != 1 || readings[0].Args[1].(ast.Constant).Type != ast.Float64Type {
+			t.Errorf("GetFacts(reading(X, 2.0)) = %v, want [reading(/a, 2.0)] holding a float", readings)
+		}
+		ok, err = store.Exists(ast.NewAtom("reading", name("/b"), ast.Float64(2)))
+		if err != nil || ok {
+			t.Errorf("Exists(reading(/b, 2.0)) = %v, %v, want false", ok, err)
+		}
+	})
+}
+
+func TestBinaryCodecRoundTrip(t *testing.T) {
+	tests := []string{
+		"p(/name, 42, -7, 0, 9223372036854775807, -9223372036854775808)",
+		`p("", "plain", "/looks/like/a/name", "b\"not bytes\"", "unicode é世", b"\x00\x01")`,
+		"p(3.5, -0.25, 1.0)",
+		"p([], [1, [2, /x]], fn:pair(/a, 1))",
+		`p(fn:map(/k, "v", 2, [3]), {/field: /value, /n: 1.25})`,
+	}
+	for _, src := range tests {
+		want := evalAtom(src)
+		hash, data, err := encodeBinaryRow(want)
+		if err != nil {
+			t.Fatalf("encodeBinaryRow(%s) error = %v", src, err)
+		}
+		jsonHash, _, err := atomToRowForInsertBinary(want)
+		if err != nil {
+			t.Fatalf("atomToRowForInsertBinary(%s) error = %v", src, err)
+		}
+		if hash != jsonHash {
+			t.Errorf("encodeBinaryRow(%s) hash = %d, want the JSON codec hash %d", src, hash, jsonHash)
+		}
+		got, err := decodeBinaryArgs(want.Predicate, data)
+		if err != nil {
+			t.Fatalf("decodeBinaryArgs(%s) error = %v", src, err)
+		}
+		if !got.Equals(want) {
+			t.Errorf("decodeBinaryArgs(%s) = %v, want %v", src, got, want)
+		}
+		for n := range len(data) - 1 {
+			if _, err := decodeBinaryArgs(want.Predicate, data[:n]); err == nil {
+				t.Errorf("decodeBinaryArgs(%s) of %d truncated bytes succeeded, want error", src, n)
+			}
+		}
+	}
+
+	// Floats with integral values keep their type, which JSON cannot express.
+	got, err := decodeBinaryArgs(ast.PredicateSym{Symbol: "p", Arity: 1}, mustEncodeBinary(t, ast.NewAtom("p", ast.Float64(2))))
+	if err != nil {
+		t.Fatalf("decodeBinaryArgs() error = %v", err)
+	}
+	if c := got.Args[0].(ast.Constant); c.Type != ast.Float64Type {
+		t.Errorf("decodeBinaryArgs(p(2.0)) = %v of type %v, want a float", c, c.Type)
+	}
+
+	// Numeric facts are smaller than their JSON text.
+	numeric := evalAtom("reading(1700000000, 12345, -3, 98.25)")
+	_, jsonData, _ := atomToRowForInsertBinary(numeric)
+	if binData := mustEncodeBinary(t, numeric); len(binData) >= len(jsonData) {
+		t.Errorf("binary encoding of %v has %d bytes, want fewer than the %d bytes of JSON", numeric, len(binData), len(jsonData))
+	}
+}
+
+func mustEncodeBinary(t *testing.T, a ast.Atom) []byte {
+	t.Helper()
+	_, data, err := encodeBinaryRow(a)
+	if err != nil {
+		t.Fatalf("encodeBinaryRow(%v) error = %v", a, err)
+	}
+	return data
+}
+
+func TestCodecMetadata(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "facts.db")
+	fact := evalAtom("p(/a, 1.5)")
+
+	store, err := NewFactStoreSQLite(path, WithCodec(CodecBinary))
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	store.Add(fact)
+	store.Close()
+
+	// The recorded codec is used when none is given.
+	store, err = NewFactStoreSQLite(path)
+	if err != nil {
+		t.Fatalf("Failed to reopen store: %v", err)
+	}
+	if store.codec.name() != CodecBinary {
+		t.Errorf("reopened store codec = %s, want %s", store.codec.name(), CodecBinary)
+	}
+	if !store.Contains(fact) || store.EstimateFactCount() != 1 {
+		t.Errorf("reopened store lost %v", fact)
+	}
+	store.Close()
+
+	_, err = NewFactStoreSQLite(path, WithCodec(CodecJSON))
+	if err == nil || !strings.Contains(err.Error(), `codec "binary"`) {
+		t.Errorf("opening a binary store with the JSON codec: error = %v, want codec mismatch", err)
+	}
+	if _, err := NewFactStoreSQLite(":memory:", WithCodec("cbor")); err == nil {
+		t.Error("NewFactStoreSQLite() with an unknown codec succeeded, want error")
+	}
+	// PostgreSQL cannot compute the args column from args_bin.
+	pg := &FactStoreDB{dialect: postgresDialect{}}
+	if _, err := pg.resolveCodec(CodecBinary, false); err == nil {
+		t.Error("resolveCodec() of the binary codec on PostgreSQL succeeded, want error")
+	}
+}
+
+func TestBinaryCodecSize(t *testing.T) {
+	facts := factstore.NewSimpleInMemoryStore()
+	for i := range 20000 {
+		facts.Add(ast.NewAtom("reading", ast.Number(int64(1700000000+i)), ast.Number(int64(i%977)), ast.Float64(float64(i)/4)))
+	}
+	size := func(codec Codec) int {
+		store, err := NewFactStoreSQLite(":memory:", WithCodec(codec))
+		if err != nil {
+			t.Fatalf("Failed to create store: %v", err)
+		}
+		defer store.Close()
+		store.Merge(&facts)
+		if _, err := store.db.Exec("VACUUM"); err != nil {
+			t.Fatalf("VACUUM error = %v", err)
+		}
+		var pages int
+		if err := store.db.QueryRow("PRAGMA page_count").Scan(&pages); err != nil {
+			t.Fatalf("Failed to read page count: %v", err)
+		}
+		return pages
+	}
+	// Only args_bin is stored; the JSON keys are computed when read.
+	if binary, json := size(CodecBinary), size(CodecJSON); binary >= json {
+		t.Errorf("binary codec store has %d pages, want fewer than the %d pages of the JSON codec", binary, json)
+	}
+}
+
+func TestBinaryCodecMigration(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "facts.db")
+	fact := ast.NewAtom("p", name("/a"), ast.Float64(2))
+	store, err := NewFactStoreSQLite(path, WithCodec(CodecBinary))
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	store.Add(fact)
+	store.Add(evalAtom("p(/b, 3)"))
+	downgradeBinaryStore(t, store)
+	// A damaged row must not keep the store from opening.
+	if _, err := store.db.Exec("INSERT INTO facts (predicate, atom_hash, args) VALUES ('p_2', 1, X'0205')"); err != nil {
+		t.Fatalf("Failed to insert damaged row: %v", err)
+	}
+	store.Close()
+
+	store, err = NewFactStoreSQLite(path)
+	if err != nil {
+		t.Fatalf("Failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	if !store.Contains(fact) || store.EstimateFactCount() != 3 {
+		t.Errorf("migrated store lost %v", fact)
+	}
+	report, err := store.Verify(context.Background(), Repair())
+	if err != nil || report.Quarantined != 1 || store.EstimateFactCount() != 2 {
+		t.Errorf("Verify(Repair()) of migrated store = %+v, %v, want the damaged row quarantined", report, err)
+	}
+	ok, err := store.Exists(ast.NewAtom("p", ast.Variable{Symbol: "X"}, ast.Float64(2)))
+	if err != nil || !ok {
+		t.Errorf("Exists(p(X, 2.0)) on migrated store = %v, %v, want true", ok, err)
+	}
+	var got []ast.Atom
+	if err := store.GetFacts(atom("p(/a, X)"), func(a ast.Atom) error {
+		got = append(got, a)
+		return nil
+	}); err != nil || len(got) != 1 || !got[0].Equals(fact) {
+		t.Errorf("GetFacts(p(/a, X)) on migrated store = %v, %v, want [%v]", got, err, fact)
+	}
+}
+
+// downgradeBinaryStore turns store, which uses the binary codec, into a store
+// at schema version 2, which held only the binary args, in the args column.
+func downgradeBinaryStore(t *testing.T, store *FactStoreDB) {
+	t.Helper()
+	for _, stmt := range []string{
+		"ALTER TABLE facts DROP COLUMN args",
+		"ALTER TABLE facts RENAME COLUMN args_bin TO args",
+		"UPDATE factstore_meta SET value = '2' WHERE key = 'schema_version'",
+	} {
+		if _, err := store.db.Exec(stmt); err != nil {
+			t.Fatalf("Failed to downgrade store: %v", err)
+		}
+	}
+}
+
+func TestCodecMetadataLegacyStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "facts.db")
+	store, err := NewFactStoreSQLite(path)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	store.Add(evalAtom("p(/a)"))
+	// Stores written before the codec was recorded have no metadata.
+	if _, err := store.db.Exec("DROP TABLE factstore_meta"); err != nil {
+		t.Fatalf("Failed to drop metadata: %v", err)
+	}
+	store.Close()
+
+	if _, err := NewFactStoreSQLite(path, WithCodec(CodecBinary)); err == nil {
+		t.Error("opening a legacy JSON store with the binary codec succeeded, want error")
+	}
+	store, err = NewFactStoreSQLite(path)
+	if err != nil {
+		t.Fatalf("Failed to reopen legacy store: %v", err)
+	}
+	defer store.Close()
+	if codec, err := store.metadata(metaCodecKey); err != nil || codec != string(CodecJSON) {
+		t.Errorf("recorded codec = %q, %v, want %q", codec, err, CodecJSON)
+	}
+}
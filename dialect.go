@@ -1,55 +1,234 @@
 package factstoredb
 
 import (
+	"database/sql"
 	"strconv"
 	"strings"
+
+	"github.com/google/mangle/ast"
 )
 
 // dialect defines an interface for generating database-specific SQL.
 type dialect interface {
-	// createTableSQL returns the SQL for creating the 'facts' table.
-	createTableSQL() string
+	// createTableSQL returns the SQL for creating the 'facts' table with an args column of argsType.
+	createTableSQL(argsType string) string
 	// createIndexSQL returns the SQL for creating the index on the 'predicate' column.
 	createIndexSQL() string
 	// addSQL returns the SQL for inserting a fact with conflict handling.
-	addSQL() string
+	// The args value is inserted into argsColumn, its placeholder wrapped by
+	// argsValue.
+	addSQL(argsColumn string, argsValue func(placeholder string) string) string
 	// removeSQL returns the SQL for deleting a fact by its hash.
 	removeSQL() string
 	// containsSQL returns the SQL for checking if a fact exists by its hash.
 	containsSQL() string
-	// getFactsBaseSQL returns the initial SELECT statement for GetFacts.
-	getFactsBaseSQL() string
+	// getFactsBaseSQL returns the initial SELECT statement for GetFacts,
+	// selecting the args column through argsSelect.
+	getFactsBaseSQL(argsSelect string) string
+	// jsonArgsType returns the SQL type of the args column for the JSON codec.
+	jsonArgsType() string
+	// jsonArgsValueSQL wraps the placeholder of JSON args text on insert.
+	jsonArgsValueSQL(placeholder string) string
+	// argsSQL returns the expression selecting JSON args in the form decodeArgs reads.
+	argsSQL() string
+	// decodeArgs decodes JSON args selected through argsSQL.
+	decodeArgs(pred ast.PredicateSym, data []byte) (ast.Atom, error)
+	// blobType returns the SQL type of opaque binary values.
+	blobType() string
+	// binaryArgsColumnSQL returns the definition of the args column computed
+	// from the args_bin column of the binary codec, or "" if the dialect
+	// cannot compute it.
+	binaryArgsColumnSQL() string
+	// tableExistsSQL returns a query counting the tables named by its single parameter.
+	tableExistsSQL() string
+	// createMetaTableSQL returns the SQL for creating the 'factstore_meta' table.
+	createMetaTableSQL() string
+	// createConstantsTableSQL returns the SQL for creating the 'constants' dictionary table.
+	createConstantsTableSQL() string
+	// convertTextArgsSQL returns the SQL converting JSON args stored as JSON
+	// text by earlier versions to the current format, or "" if there are none.
+	convertTextArgsSQL() string
+	// argsTextSQL returns the expression selecting the JSON args of the
+	// JSON codec as JSON text, also for rows still stored as JSON text.
+	argsTextSQL() string
+	// backupSQL returns the statement writing a consistent copy of the database
+	// to the file named by its single parameter, or "" if the dialect has none
+	// and Backup writes a logical snapshot instead.
+	backupSQL() string
+	// cloneTableSQL returns the statements creating a copy of table, with its
+	// indexes and rows, in schema. Dialects that clone by copying the whole
+	// database through backupSQL return nil.
+	cloneTableSQL(schema, table string) []string
+	// maintenanceSQL returns the statements running the maintenance tasks over
+	// the given tables. vacuumPages limits the pages an incremental vacuum
+	// releases; 0 releases all free pages.
+	maintenanceSQL(tasks MaintenanceTask, tables []string, vacuumPages int) []string
+	// freePagesSQL returns a query for the number of unused pages of the
+	// database file, or "" if the database does not report it.
+	freePagesSQL() string
+	// columnTypeSQL returns the SQL type of typed predicate columns of type t, or "" if t is unknown.
+	columnTypeSQL(t ColumnType) string
+	// createTypedTableSQL returns the SQL for creating the table of a typed predicate
+	// with atom_hash and one argument column of each of the given SQL types.
+	createTypedTableSQL(table string, columnTypes []string) string
 	// batchInsertSQL builds a multi-row INSERT statement for a given number of rows.
-	batchInsertSQL(numRows int) string
+	// The args values are inserted into argsColumn, each placeholder wrapped
+	// by argsValue.
+	batchInsertSQL(numRows int, argsColumn string, argsValue func(placeholder string) string) string
 	// getFactsFragment appends the SQL fragment for filtering by a constant argument in GetFacts.
 	getFactsFragment(index int, params *[]any) string
 	// jsonParam prepares a parameter for a JSON comparison.
 	jsonParam(jsonStr string) any
+	// placeholder returns the bind parameter marker for the n-th (1-based) parameter.
+	placeholder(n int) string
+	// argJSONSQL returns an expression selecting a single argument as JSON text.
+	argJSONSQL(index int) string
+	// tableArgJSONSQL is argJSONSQL qualified by a table name, for correlated subqueries.
+	tableArgJSONSQL(table string, index int) string
+	// argValueSQL returns the expression an argument is compared through for the given kind.
+	argValueSQL(index int, kind argKind) string
+	// argGuardSQL returns a condition that holds when an argument is of the given kind.
+	argGuardSQL(index int, kind argKind) string
+	// jsonParamSQL wraps a jsonParam placeholder so it compares equal to argValueSQL(index, argAny).
+	jsonParamSQL(placeholder string) string
+	// createArgIndexSQL returns the statements creating indexes over one argument position.
+	createArgIndexSQL(index int) []string
+	// entryEqualsSQL returns a condition that holds when the fn:map or fn:struct
+	// argument at index has an entry whose key and value equal the jsonParam placeholders.
+	entryEqualsSQL(index int, fn string, keyPlaceholder, valuePlaceholder string) string
+	// listContainsSQL returns a condition that holds when the list argument at
+	// index contains an element equal to the jsonParam placeholder.
+	listContainsSQL(index int, elemPlaceholder string, scalar bool) string
+	// jsonTextParamSQL converts a JSON text placeholder into the form argJSONSQL produces.
+	jsonTextParamSQL(placeholder string) string
+	// pathStartSQL returns a path holding the single node, for recursive graph queries.
+	pathStartSQL(node string) string
+	// pathAppendSQL returns path extended by node.
+	pathAppendSQL(path, node string) string
+	// pathContainsSQL returns a condition that holds when path contains node.
+	pathContainsSQL(path, node string) string
+	// pathJSONSQL returns path as a JSON array of strings, one per node.
+	pathJSONSQL(path string) string
+	// explainSQL returns the statement that reports the plan of query.
+	explainSQL(query string) string
+	// formatPlan renders the result of an explainSQL statement as text.
+	formatPlan(rows *sql.Rows) (string, error)
 }
 
+// argKind classifies how a stored argument is interpreted by a constraint.
+type argKind int
+
+const (
+	// argAny compares arguments by their JSON value, like pattern constants.
+	argAny argKind = iota
+	// argNumber matches JSON numbers, compared numerically.
+	argNumber
+	// argString matches StringType values, compared by byte order.
+	argString
+	// argName matches NameType values, compared by byte order.
+	argName
+)
+
 // --- SQLite Dialect ---
 
 type sqliteDialect struct{}
 
-func (d sqliteDialect) createTableSQL() string {
+func (d sqliteDialect) createTableSQL(argsType string) string {
 	return `
 		CREATE TABLE IF NOT EXISTS facts (
 			predicate TEXT NOT NULL,
 			atom_hash BIGINT NOT NULL,
-			args BLOB NOT NULL,
+			args ` + argsType + ` NOT NULL,
 			PRIMARY KEY(atom_hash)
 		) WITHOUT ROWID;
 	`
 }
 
+func (d sqliteDialect) createMetaTableSQL() string {
+	return `CREATE TABLE IF NOT EXISTS factstore_meta (key TEXT PRIMARY KEY, value TEXT NOT NULL) WITHOUT ROWID;`
+}
+
+func (d sqliteDialect) createConstantsTableSQL() string {
+	// An INTEGER PRIMARY KEY is the rowid, assigned from 1 upwards.
+	return `CREATE TABLE IF NOT EXISTS constants (id INTEGER PRIMARY KEY, value TEXT NOT NULL UNIQUE);`
+}
+
+func (d sqliteDialect) convertTextArgsSQL() string {
+	// Rows of JSON text fail the strict JSONB check, as in decodeArgs.
+	return `UPDATE facts SET args = jsonb(CAST(args AS TEXT)) WHERE NOT json_valid(args, 8)`
+}
+
+func (d sqliteDialect) argsTextSQL() string {
+	// json() fails on invalid JSONB, which would abort the whole query.
+	return `CASE WHEN json_valid(args, 8) THEN json(args) ELSE CAST(args AS TEXT) END`
+}
+
+func (d sqliteDialect) backupSQL() string {
+	// VACUUM INTO copies the database in a single read transaction.
+	return `VACUUM INTO ?`
+}
+
+func (d sqliteDialect) cloneTableSQL(schema, table string) []string {
+	return nil
+}
+
+func (d sqliteDialect) maintenanceSQL(tasks MaintenanceTask, tables []string, vacuumPages int) []string {
+	// Vacuum and checkpoint work on the whole database file, so tables is unused.
+	var stmts []string
+	if tasks&MaintainVacuum != 0 {
+		if vacuumPages > 0 {
+			stmts = append(stmts, "PRAGMA incremental_vacuum("+strconv.Itoa(vacuumPages)+")")
+		} else {
+			stmts = append(stmts, "PRAGMA incremental_vacuum")
+		}
+	}
+	if tasks&MaintainCheckpoint != 0 {
+		stmts = append(stmts, "PRAGMA wal_checkpoint(TRUNCATE)")
+	}
+	if tasks&MaintainAnalyze != 0 {
+		// 0x10002 analyzes all tables that need it, not only those this
+		// connection queried, with a limit on the rows examined.
+		stmts = append(stmts, "PRAGMA optimize=0x10002")
+	}
+	return stmts
+}
+
+func (d sqliteDialect) freePagesSQL() string {
+	return "PRAGMA freelist_count"
+}
+
+func (d sqliteDialect) columnTypeSQL(t ColumnType) string {
+	switch t {
+	case ColumnNumber:
+		return "BIGINT"
+	case ColumnFloat64:
+		// DOUBLE has REAL affinity, so integral values are not stored as integers.
+		return "DOUBLE"
+	case ColumnString, ColumnName:
+		return "TEXT"
+	case ColumnBytes:
+		return "BLOB"
+	default:
+		return ""
+	}
+}
+
+func (d sqliteDialect) createTypedTableSQL(table string, columnTypes []string) string {
+	return `CREATE TABLE IF NOT EXISTS ` + table + ` (atom_hash BIGINT NOT NULL PRIMARY KEY` + typedColumnsSQL(columnTypes) + `) WITHOUT ROWID;`
+}
+
+func (d sqliteDialect) tableExistsSQL() string {
+	return `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?`
+}
+
 func (d sqliteDialect) createIndexSQL() string {
 	return `CREATE INDEX IF NOT EXISTS idx_predicate ON facts(predicate);`
 }
 
-func (d sqliteDialect) addSQL() string {
+func (d sqliteDialect) addSQL(argsColumn string, argsValue func(placeholder string) string) string {
 	return `
-		INSERT INTO facts (predicate, atom_hash, args)
-		VALUES (?, ?, ?)
+		INSERT INTO facts (predicate, atom_hash, ` + argsColumn + `)
+		VALUES (?, ?, ` + argsValue("?") + `)
 		ON CONFLICT DO NOTHING
 	`
 }
@@ -62,19 +241,56 @@ func (d sqliteDialect) containsSQL() string {
 	return `SELECT COUNT(*) FROM facts WHERE atom_hash = ?`
 }
 
-func (d sqliteDialect) getFactsBaseSQL() string {
-	return `SELECT predicate, json(args) FROM facts WHERE predicate = ?`
+func (d sqliteDialect) getFactsBaseSQL(argsSelect string) string {
+	return `SELECT predicate, ` + argsSelect + ` FROM facts WHERE predicate = ?`
 }
 
-func (d sqliteDialect) batchInsertSQL(numRows int) string {
+func (d sqliteDialect) jsonArgsType() string {
+	return "BLOB"
+}
+
+func (d sqliteDialect) jsonArgsValueSQL(placeholder string) string {
+	// Args are bound as JSON text and stored in SQLite's binary JSONB format.
+	return "jsonb(CAST(" + placeholder + " AS TEXT))"
+}
+
+func (d sqliteDialect) blobType() string {
+	return "BLOB"
+}
+
+func (d sqliteDialect) binaryArgsColumnSQL() string {
+	// A virtual column is computed when read and takes no space; indexes on
+	// its expressions store the keys they index.
+	return "args BLOB GENERATED ALWAYS AS (jsonb(" + binaryArgsFunction + "(args_bin))) VIRTUAL"
+}
+
+func (d sqliteDialect) argsSQL() string {
+	// The JSONB blob is decoded in Go, without converting it to JSON text.
+	return "args"
+}
+
+func (d sqliteDialect) decodeArgs(pred ast.PredicateSym, data []byte) (ast.Atom, error) {
+	args, err := decodeJSONBArgs(data)
+	if err != nil {
+		// Stores written by earlier versions hold JSON text in the blob.
+		if len(data) > 0 && data[0] == '[' {
+			return unmarshalArgs(pred, data)
+		}
+		return ast.Atom{}, err
+	}
+	return ast.Atom{Predicate: pred, Args: args}, nil
+}
+
+func (d sqliteDialect) batchInsertSQL(numRows int, argsColumn string, argsValue func(placeholder string) string) string {
+	row := "(?,?," + argsValue("?") + ")"
 	var sb strings.Builder
-	sb.WriteString("INSERT INTO facts (predicate, atom_hash, args) VALUES ")
+	sb.WriteString("INSERT INTO facts (predicate, atom_hash, " + argsColumn + ") VALUES ")
 	for i := range numRows {
 		if i > 0 {
 			sb.WriteString(",")
 		}
 		// Each row has 3 placeholders: predicate, atom_hash, args.
-		sb.WriteString("(?,?,?)")
+		sb.WriteString(row)
 	}
 	sb.WriteString(" ON CONFLICT DO NOTHING")
 	return sb.String()
@@ -100,33 +316,232 @@ func (d sqliteDialect) jsonParam(jsonStr string) any {
 	return "[" + jsonStr + "]"
 }
 
+func (d sqliteDialect) placeholder(n int) string {
+	return "?"
+}
+
+func (d sqliteDialect) argJSONSQL(index int) string {
+	// The '->' operator returns the element as JSON text, so strings stay
+	// quoted and can be told apart from lists or numbers when decoding.
+	return "(args -> '$[" + strconv.Itoa(index) + "]')"
+}
+
+func (d sqliteDialect) tableArgJSONSQL(table string, index int) string {
+	return "(" + table + ".args -> '$[" + strconv.Itoa(index) + "]')"
+}
+
+func (d sqliteDialect) argValueSQL(index int, kind argKind) string {
+	// json_extract yields SQL integers and reals for JSON numbers, so numeric
+	// comparisons use numeric semantics; text uses the default BINARY collation.
+	path := "'$[" + strconv.Itoa(index) + "]'"
+	if kind == argString {
+		// Strings that look like names or bytes are wrapped as {"fn:string": [...]}.
+		return "COALESCE(json_extract(args, '$[" + strconv.Itoa(index) + `]."fn:string"[0]'), json_extract(args, ` + path + "))"
+	}
+	return "json_extract(args, " + path + ")"
+}
+
+func (d sqliteDialect) argGuardSQL(index int, kind argKind) string {
+	path := "'$[" + strconv.Itoa(index) + "]'"
+	value := "json_extract(args, " + path + ")"
+	switch kind {
+	case argNumber:
+		return "json_type(args, " + path + ") IN ('integer', 'real')"
+	case argString:
+		// Names and bytes are stored as JSON strings too; exclude them by shape.
+		return "((json_type(args, " + path + ") = 'text' AND substr(" + value + ", 1, 1) <> '/' AND " + value + ` NOT GLOB 'b"*"') OR json_type(args, '$[` + strconv.Itoa(index) + `]."fn:string"[0]') = 'text')`
+	case argName:
+		return "(json_type(args, " + path + ") = 'text' AND substr(" + value + ", 1, 1) = '/')"
+	default:
+		return "json_type(args, " + path + ") IS NOT NULL"
+	}
+}
+
+func (d sqliteDialect) jsonParamSQL(placeholder string) string {
+	return "json_extract(" + placeholder + ", '$[0]')"
+}
+
+func (d sqliteDialect) entryEqualsSQL(index int, fn string, keyPlaceholder, valuePlaceholder string) string {
+	// Entries are stored flat as [key1, val1, key2, val2, ...]. Keys sit at
+	// even positions and are paired with the element that follows them.
+	// Each jsonParam is a one-element array; json_each over it yields a row
+	// whose type and value are compared, so "[1]" never equals [1].
+	path := "'$[" + strconv.Itoa(index) + `]."` + fn + `"'`
+	return "(json_type(args, " + path + ") = 'array' AND EXISTS (" +
+		"SELECT 1 FROM json_each(args, " + path + ") AS k" +
+		" JOIN json_each(args, " + path + ") AS v ON v.key = k.key + 1" +
+		", json_each(" + keyPlaceholder + ") AS pk, json_each(" + valuePlaceholder + ") AS pv" +
+		" WHERE k.key % 2 = 0 AND k.type = pk.type AND k.value = pk.value" +
+		" AND v.type = pv.type AND v.value = pv.value))"
+}
+
+func (d sqliteDialect) jsonTextParamSQL(placeholder string) string {
+	// json() renders JSON text the same way as the '->' operator.
+	return "json(" + placeholder + ")"
+}
+
+func (d sqliteDialect) pathStartSQL(node string) string {
+	// Concatenation drops the JSON subtype, so the node is stored as a string.
+	return "json_array('' || " + node + ")"
+}
+
+func (d sqliteDialect) pathAppendSQL(path, node string) string {
+	return "json_insert(" + path + ", '$[#]', '' || " + node + ")"
+}
+
+func (d sqliteDialect) pathContainsSQL(path, node string) string {
+	return "EXISTS (SELECT 1 FROM json_each(" + path + ") WHERE value = " + node + ")"
+}
+
+func (d sqliteDialect) pathJSONSQL(path string) string {
+	return path
+}
+
+func (d sqliteDialect) explainSQL(query string) string {
+	return "EXPLAIN QUERY PLAN " + query
+}
+
+func (d sqliteDialect) formatPlan(rows *sql.Rows) (string, error) {
+	// Rows are (id, parent, notused, detail); children follow their parent,
+	// so the nesting depth is known when a row is read.
+	depth := map[int64]int{0: -1}
+	var sb strings.Builder
+	for rows.Next() {
+		var id, parent, notused int64
+		var detail string
+		if err := rows.Scan(&id, &parent, &notused, &detail); err != nil {
+			return "", err
+		}
+		depth[id] = depth[parent] + 1
+		sb.WriteString(strings.Repeat("  ", depth[id]))
+		sb.WriteString(detail)
+		sb.WriteString("\n")
+	}
+	return sb.String(), rows.Err()
+}
+
+func (d sqliteDialect) listContainsSQL(index int, elemPlaceholder string, scalar bool) string {
+	path := "'$[" + strconv.Itoa(index) + "]'"
+	return "(json_type(args, " + path + ") = 'array' AND EXISTS (" +
+		"SELECT 1 FROM json_each(args, " + path + ") AS e, json_each(" + elemPlaceholder + ") AS p" +
+		" WHERE e.type = p.type AND e.value = p.value))"
+}
+
+func (d sqliteDialect) createArgIndexSQL(index int) []string {
+	// The indexed expression must match argValueSQL for the planner to use it.
+	idx := strconv.Itoa(index)
+	return []string{
+		"CREATE INDEX IF NOT EXISTS idx_arg_" + idx + " ON facts(predicate, json_extract(args, '$[" + idx + "]'))",
+	}
+}
+
 // --- PostgreSQL Dialect ---
 
 type postgresDialect struct{}
 
-func (d postgresDialect) createTableSQL() string {
+func (d postgresDialect) createTableSQL(argsType string) string {
 	return `
 		CREATE TABLE IF NOT EXISTS facts (
 			predicate TEXT NOT NULL,
 			atom_hash BIGINT NOT NULL,
-			args JSONB NOT NULL,
+			args ` + argsType + ` NOT NULL,
 			PRIMARY KEY(atom_hash)
 		);
 	`
 }
 
+func (d postgresDialect) createMetaTableSQL() string {
+	return `CREATE TABLE IF NOT EXISTS factstore_meta (key TEXT PRIMARY KEY, value TEXT NOT NULL);`
+}
+
+func (d postgresDialect) createConstantsTableSQL() string {
+	return `CREATE TABLE IF NOT EXISTS constants (id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY, value TEXT NOT NULL UNIQUE);`
+}
+
+func (d postgresDialect) convertTextArgsSQL() string {
+	// JSONB columns always held JSONB.
+	return ""
+}
+
+func (d postgresDialect) argsTextSQL() string {
+	return "args::text"
+}
+
+func (d postgresDialect) backupSQL() string {
+	// A server-side copy would be written on the database host.
+	return ""
+}
+
+func (d postgresDialect) cloneTableSQL(schema, table string) []string {
+	dest := quoteIdent(schema) + "." + table
+	stmts := []string{
+		"CREATE TABLE " + dest + " (LIKE " + table + " INCLUDING ALL)",
+		"INSERT INTO " + dest + " SELECT * FROM " + table,
+	}
+	if table == "constants" {
+		// The identity of the copy starts over, so move it past the copied ids.
+		stmts = append(stmts, "SELECT setval(pg_get_serial_sequence('"+dest+"', 'id'), COALESCE(MAX(id), 0) + 1, false) FROM "+dest)
+	}
+	return stmts
+}
+
+func (d postgresDialect) maintenanceSQL(tasks MaintenanceTask, tables []string, vacuumPages int) []string {
+	// The server checkpoints on its own, and VACUUM has no page limit.
+	var command string
+	switch tasks & (MaintainVacuum | MaintainAnalyze) {
+	case MaintainVacuum | MaintainAnalyze:
+		command = "VACUUM (ANALYZE) "
+	case MaintainVacuum:
+		command = "VACUUM "
+	case MaintainAnalyze:
+		command = "ANALYZE "
+	default:
+		return nil
+	}
+	stmts := make([]string, len(tables))
+	for i, table := range tables {
+		stmts[i] = command + table
+	}
+	return stmts
+}
+
+func (d postgresDialect) freePagesSQL() string {
+	return ""
+}
+
+func (d postgresDialect) columnTypeSQL(t ColumnType) string {
+	switch t {
+	case ColumnNumber:
+		return "BIGINT"
+	case ColumnFloat64:
+		return "DOUBLE PRECISION"
+	case ColumnString, ColumnName:
+		return "TEXT"
+	case ColumnBytes:
+		return "BYTEA"
+	default:
+		return ""
+	}
+}
+
+func (d postgresDialect) createTypedTableSQL(table string, columnTypes []string) string {
+	return `CREATE TABLE IF NOT EXISTS ` + table + ` (atom_hash BIGINT NOT NULL PRIMARY KEY` + typedColumnsSQL(columnTypes) + `);`
+}
+
+func (d postgresDialect) tableExistsSQL() string {
+	return `SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = $1`
+}
+
 func (d postgresDialect) createIndexSQL() string {
 	// In PostgreSQL, ON CONFLICT needs an index to work, which the PRIMARY KEY provides.
 	// This index is for GetFacts performance.
 	return `CREATE INDEX IF NOT EXISTS idx_predicate ON facts(predicate);`
 }
 
-func (d postgresDialect) addSQL() string {
-	// By omitting the ::jsonb cast, we rely on the driver to use the binary
-	// protocol for jsonb, which is more efficient than sending text and casting.
+func (d postgresDialect) addSQL(argsColumn string, argsValue func(placeholder string) string) string {
 	return `
-		INSERT INTO facts (predicate, atom_hash, args)
-		VALUES ($1, $2, $3)
+		INSERT INTO facts (predicate, atom_hash, ` + argsColumn + `)
+		VALUES ($1, $2, ` + argsValue("$3") + `)
 		ON CONFLICT (atom_hash) DO NOTHING
 	`
 }
@@ -139,26 +554,53 @@ func (d postgresDialect) containsSQL() string {
 	return `SELECT COUNT(*) FROM facts WHERE atom_hash = $1`
 }
 
-func (d postgresDialect) getFactsBaseSQL() string {
-	return `SELECT predicate, args::text FROM facts WHERE predicate = $1`
+func (d postgresDialect) getFactsBaseSQL(argsSelect string) string {
+	return `SELECT predicate, ` + argsSelect + ` FROM facts WHERE predicate = $1`
 }
 
-func (d postgresDialect) batchInsertSQL(numRows int) string {
+func (d postgresDialect) jsonArgsType() string {
+	return "JSONB"
+}
+
+func (d postgresDialect) jsonArgsValueSQL(placeholder string) string {
+	// By omitting the ::jsonb cast, we rely on the driver to use the binary
+	// protocol for jsonb, which is more efficient than sending text and casting.
+	return placeholder
+}
+
+func (d postgresDialect) blobType() string {
+	return "BYTEA"
+}
+
+func (d postgresDialect) binaryArgsColumnSQL() string {
+	// Generated columns cannot call functions implemented in Go.
+	return ""
+}
+
+func (d postgresDialect) argsSQL() string {
+	return "args::text"
+}
+
+func (d postgresDialect) decodeArgs(pred ast.PredicateSym, data []byte) (ast.Atom, error) {
+	return unmarshalArgs(pred, data)
+}
+
+func (d postgresDialect) batchInsertSQL(numRows int, argsColumn string, argsValue func(placeholder string) string) string {
 	var sb strings.Builder
-	sb.WriteString("INSERT INTO facts (predicate, atom_hash, args) VALUES ")
+	sb.WriteString("INSERT INTO facts (predicate, atom_hash, " + argsColumn + ") VALUES ")
 	paramIndex := 1
 	for i := range numRows {
 		if i > 0 {
 			sb.WriteString(",")
 		}
-		// Each row has 3 placeholders. We omit the ::jsonb cast for binary transfer.
+		// Each row has 3 placeholders, the last one wrapped by argsValue.
 		// Appending directly to the builder is more efficient than fmt.Sprintf.
 		sb.WriteString("($")
 		sb.WriteString(strconv.Itoa(paramIndex))
 		sb.WriteString(", $")
 		sb.WriteString(strconv.Itoa(paramIndex + 1))
-		sb.WriteString(", $")
-		sb.WriteString(strconv.Itoa(paramIndex + 2))
+		sb.WriteString(", ")
+		sb.WriteString(argsValue("$" + strconv.Itoa(paramIndex+2)))
 		sb.WriteString(")")
 		paramIndex += 3
 	}
@@ -182,3 +624,122 @@ func (d postgresDialect) getFactsFragment(index int, params *[]any) string {
 func (d postgresDialect) jsonParam(jsonStr string) any {
 	return jsonStr
 }
+
+func (d postgresDialect) placeholder(n int) string {
+	return "$" + strconv.Itoa(n)
+}
+
+func (d postgresDialect) argJSONSQL(index int) string {
+	return "(args -> " + strconv.Itoa(index) + ")::text"
+}
+
+func (d postgresDialect) tableArgJSONSQL(table string, index int) string {
+	return "(" + table + ".args -> " + strconv.Itoa(index) + ")::text"
+}
+
+func (d postgresDialect) argValueSQL(index int, kind argKind) string {
+	idx := strconv.Itoa(index)
+	switch kind {
+	case argNumber:
+		// The CASE keeps the cast from being evaluated for non-numeric values.
+		return "(CASE WHEN jsonb_typeof(args -> " + idx + ") = 'number' THEN (args ->> " + idx + ")::numeric END)"
+	case argString:
+		// Strings that look like names or bytes are wrapped as {"fn:string": [...]}.
+		return "(COALESCE(args -> " + idx + " -> 'fn:string' ->> 0, args ->> " + idx + `) COLLATE "C")`
+	case argName:
+		// The "C" collation compares by byte order, matching SQLite's BINARY.
+		return "((args ->> " + idx + `) COLLATE "C")`
+	default:
+		return "(args -> " + idx + ")"
+	}
+}
+
+func (d postgresDialect) argGuardSQL(index int, kind argKind) string {
+	idx := strconv.Itoa(index)
+	switch kind {
+	case argNumber:
+		return "jsonb_typeof(args -> " + idx + ") = 'number'"
+	case argString:
+		// Names and bytes are stored as JSON strings too; exclude them by shape.
+		return "((jsonb_typeof(args -> " + idx + ") = 'string' AND left(args ->> " + idx + ", 1) <> '/' AND (args ->> " + idx + `) NOT LIKE 'b"%"') OR jsonb_typeof(args -> ` + idx + " -> 'fn:string' -> 0) = 'string')"
+	case argName:
+		return "(jsonb_typeof(args -> " + idx + ") = 'string' AND left(args ->> " + idx + ", 1) = '/')"
+	default:
+		return "(args -> " + idx + ") IS NOT NULL"
+	}
+}
+
+func (d postgresDialect) jsonParamSQL(placeholder string) string {
+	return placeholder
+}
+
+func (d postgresDialect) entryEqualsSQL(index int, fn string, keyPlaceholder, valuePlaceholder string) string {
+	// Entries are stored flat as [key1, val1, key2, val2, ...]. With 1-based
+	// ordinality, keys have odd n and their value is the element at index n.
+	// Containment (@>) ignores positions, so the pairing is checked explicitly.
+	container := "(args -> " + strconv.Itoa(index) + " -> '" + fn + "')"
+	return "EXISTS (SELECT 1 FROM jsonb_array_elements(" +
+		"CASE WHEN jsonb_typeof" + container + " = 'array' THEN " + container + " END" +
+		") WITH ORDINALITY AS e(v, n)" +
+		" WHERE n % 2 = 1 AND e.v = " + keyPlaceholder + "::jsonb" +
+		" AND (" + container + " -> n::int) = " + valuePlaceholder + "::jsonb)"
+}
+
+func (d postgresDialect) jsonTextParamSQL(placeholder string) string {
+	// Round-tripping through jsonb yields the canonical text of argJSONSQL.
+	return "(" + placeholder + "::jsonb)::text"
+}
+
+func (d postgresDialect) pathStartSQL(node string) string {
+	return "ARRAY[" + node + "]"
+}
+
+func (d postgresDialect) pathAppendSQL(path, node string) string {
+	return "(" + path + " || " + node + ")"
+}
+
+func (d postgresDialect) pathContainsSQL(path, node string) string {
+	return "(" + node + " = ANY(" + path + "))"
+}
+
+func (d postgresDialect) pathJSONSQL(path string) string {
+	return "array_to_json(" + path + ")::text"
+}
+
+func (d postgresDialect) explainSQL(query string) string {
+	return "EXPLAIN (FORMAT JSON) " + query
+}
+
+func (d postgresDialect) formatPlan(rows *sql.Rows) (string, error) {
+	// The JSON format returns the whole plan in a single row.
+	var sb strings.Builder
+	for rows.Next() {
+		var plan string
+		if err := rows.Scan(&plan); err != nil {
+			return "", err
+		}
+		sb.WriteString(plan)
+	}
+	return sb.String(), rows.Err()
+}
+
+func (d postgresDialect) listContainsSQL(index int, elemPlaceholder string, scalar bool) string {
+	arg := "(args -> " + strconv.Itoa(index) + ")"
+	if scalar {
+		// Containment is exact for scalar elements and can use a GIN index.
+		return "(jsonb_typeof" + arg + " = 'array' AND " + arg + " @> jsonb_build_array(" + elemPlaceholder + "::jsonb))"
+	}
+	// Containment is recursive for nested lists and objects, so compare elements.
+	return "EXISTS (SELECT 1 FROM jsonb_array_elements(" +
+		"CASE WHEN jsonb_typeof" + arg + " = 'array' THEN " + arg + " END" +
+		") AS e(v) WHERE e.v = " + elemPlaceholder + "::jsonb)"
+}
+
+func (d postgresDialect) createArgIndexSQL(index int) []string {
+	// The indexed expressions must match argValueSQL for the planner to use them.
+	idx := strconv.Itoa(index)
+	return []string{
+		"CREATE INDEX IF NOT EXISTS idx_arg_" + idx + " ON facts (predicate, " + d.argValueSQL(index, argName) + ")",
+		"CREATE INDEX IF NOT EXISTS idx_arg_" + idx + "_num ON facts (predicate, " + d.argValueSQL(index, argNumber) + ")",
+	}
+}
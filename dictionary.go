@@ -0,0 +1,293 @@
+package factstoredb
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/google/mangle/ast"
+)
+
+// WithInterning makes a new store keep name arguments, and string arguments
+// of at least minStringLen bytes if minStringLen is positive, in a constants
+// dictionary table. The args column refers to them by integer id, which
+// shrinks stores in which the same names recur and makes equality filters on
+// them compare ids. Only top-level arguments are interned; names inside
+// lists, maps and structs are stored as usual.
+//
+// Queries return the original constants, and WriteTo writes them in the plain
+// format. Ordering constraints on strings and names, name prefix constraints
+// and OrderBy are not supported on interned stores, since the database only
+// sees ids. Interning is recorded in the store metadata like the codec:
+// reopening a store without WithInterning keeps its setting, and asking for a
+// different one fails.
+func WithInterning(minStringLen int) StoreOption {
+	return func(c *config) {
+		c.interning = true
+		c.internMinStringLen = max(minStringLen, 0)
+	}
+}
+
+// metaInterningKey is the factstore_meta key recording the interning setting:
+// "off", or the minimum length of interned strings, where "0" interns names only.
+const metaInterningKey = "interning"
+
+// fnRef marks references to the constants dictionary in JSON args: {"fn:ref": [id]}.
+const fnRef = "fn:ref"
+
+// refType is the type of the placeholder constants that stand for interned
+// constants between encoding and decoding. They never leave the store.
+const refType ast.ConstantType = -1
+
+// refConstant returns the placeholder for the interned constant with the given id.
+func refConstant(id int64) ast.Constant {
+	return ast.Constant{Type: refType, NumValue: id}
+}
+
+// refJSON returns the JSON of a reference to id, as constantJSON writes it.
+func refJSON(id int64) string {
+	return `{"` + fnRef + `":[` + strconv.FormatInt(id, 10) + `]}`
+}
+
+// dictionary interns constants in the constants table and caches the
+// mapping in both directions. Ids are never reused, so cached entries
+// stay valid for the lifetime of the store.
+type dictionary struct {
+	minStringLen int
+
+	insertStmt *sql.Stmt
+	idStmt     *sql.Stmt
+	valueStmt  *sql.Stmt
+
+	mu     sync.RWMutex
+	ids    map[string]int64 // by constant JSON
+	values map[int64]ast.Constant
+}
+
+// initDictionary resolves the interning setting of the store and, if it is
+// on, creates the constants table and the dictionary.
+func (s *FactStoreDB) initDictionary(cfg *config, existed bool) error {
+	configured := ""
+	if cfg.interning {
+		configured = strconv.Itoa(cfg.internMinStringLen)
+	}
+	setting, err := s.resolveSetting(metaInterningKey, configured, "off", "off", existed)
+	if err != nil {
+		return err
+	}
+	if setting == "off" {
+		return nil
+	}
+	minStringLen, err := strconv.Atoi(setting)
+	if err != nil {
+		return fmt.Errorf("invalid interning setting %q: %w", setting, err)
+	}
+
+	d := &dictionary{
+		minStringLen: minStringLen,
+		ids:          make(map[string]int64),
+		values:       make(map[int64]ast.Constant),
+	}
+	s.dict = d
+	ph := s.dialect.placeholder(1)
+	if !s.readOnly {
+		if _, err := s.db.Exec(s.dialect.createConstantsTableSQL()); err != nil {
+			return fmt.Errorf("failed to create constants table: %w", err)
+		}
+		if d.insertStmt, err = s.db.Prepare("INSERT INTO constants (value) VALUES (" + ph + ") ON CONFLICT (value) DO NOTHING"); err != nil {
+			return fmt.Errorf("failed to prepare constant insert statement: %w", err)
+		}
+	}
+	if d.idStmt, err = s.db.Prepare("SELECT id FROM constants WHERE value = " + ph); err != nil {
+		return fmt.Errorf("failed to prepare constant id statement: %w", err)
+	}
+	if d.valueStmt, err = s.db.Prepare("SELECT value FROM constants WHERE id = " + ph); err != nil {
+		return fmt.Errorf("failed to prepare constant value statement: %w", err)
+	}
+	return nil
+}
+
+// interns reports whether c is kept in the dictionary.
+func (d *dictionary) interns(c ast.Constant) bool {
+	switch c.Type {
+	case ast.NameType:
+		return true
+	case ast.StringType:
+		return d.minStringLen > 0 && len(c.Symbol) >= d.minStringLen
+	default:
+		return false
+	}
+}
+
+// id returns the id of c, adding c to the dictionary if create is set.
+// Without create, it returns false if c is not in the dictionary.
+func (d *dictionary) id(c ast.Constant, create bool) (int64, bool, error) {
+	key, err := marshalConstant(c)
+	if err != nil {
+		return 0, false, err
+	}
+	d.mu.RLock()
+	id, ok := d.ids[key]
+	d.mu.RUnlock()
+	if ok {
+		return id, true, nil
+	}
+
+	if create {
+		// Concurrent inserts of the same constant are resolved by the
+		// unique index; every writer then reads the same id.
+		if _, err := d.insertStmt.Exec(key); err != nil {
+			return 0, false, fmt.Errorf("failed to intern %v: %w", c, err)
+		}
+	}
+	err = d.idStmt.QueryRow(key).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) && !create {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to look up interned %v: %w", c, err)
+	}
+	d.cache(key, id, c)
+	return id, true, nil
+}
+
+// cache records the mapping between c, with JSON key, and id.
+func (d *dictionary) cache(key string, id int64, c ast.Constant) {
+	d.mu.Lock()
+	d.ids[key] = id
+	d.values[id] = c
+	d.mu.Unlock()
+}
+
+// internArgs returns a copy of atom whose interned arguments are replaced by
+// references, adding them to the dictionary as needed.
+func (d *dictionary) internArgs(atom ast.Atom) (ast.Atom, error) {
+	args := make([]ast.BaseTerm, len(atom.Args))
+	for i, arg := range atom.Args {
+		args[i] = arg
+		c, ok := arg.(ast.Constant)
+		if !ok || !d.interns(c) {
+			continue
+		}
+		id, _, err := d.id(c, true)
+		if err != nil {
+			return ast.Atom{}, err
+		}
+		args[i] = refConstant(id)
+	}
+	return ast.Atom{Predicate: atom.Predicate, Args: args}, nil
+}
+
+// argJSON returns the JSON an argument equal to c is stored as. Constants
+// that would be interned but are not in the dictionary yet get a reference
+// to id 0, which matches nothing.
+func (d *dictionary) argJSON(c ast.Constant) (string, error) {
+	if !d.interns(c) {
+		return marshalConstant(c)
+	}
+	id, _, err := d.id(c, false)
+	if err != nil {
+		return "", err
+	}
+	return refJSON(id), nil
+}
+
+// resolve returns the constant c stands for if it is a reference, and c otherwise.
+func (d *dictionary) resolve(c ast.Constant) (ast.Constant, error) {
+	if c.Type != refType {
+		return c, nil
+	}
+	id := c.NumValue
+	d.mu.RLock()
+	value, ok := d.values[id]
+	d.mu.RUnlock()
+	if ok {
+		return value, nil
+	}
+
+	var key string
+	if err := d.valueStmt.QueryRow(id).Scan(&key); err != nil {
+		return ast.Constant{}, fmt.Errorf("failed to resolve interned constant %d: %w", id, err)
+	}
+	value, err := unmarshalConstant(key)
+	if err != nil {
+		return ast.Constant{}, fmt.Errorf("failed to decode interned constant %d: %w", id, err)
+	}
+	d.cache(key, id, value)
+	return value, nil
+}
+
+// resolveArgs replaces references among args by the constants they stand for.
+func (d *dictionary) resolveArgs(args []ast.BaseTerm) error {
+	for i, arg := range args {
+		c, ok := arg.(ast.Constant)
+		if !ok || c.Type != refType {
+			continue
+		}
+		value, err := d.resolve(c)
+		if err != nil {
+			return err
+		}
+		args[i] = value
+	}
+	return nil
+}
+
+// close closes the prepared statements of the dictionary.
+func (d *dictionary) close() {
+	if d == nil {
+		return
+	}
+	for _, stmt := range []*sql.Stmt{d.insertStmt, d.idStmt, d.valueStmt} {
+		if stmt != nil {
+			stmt.Close()
+		}
+	}
+}
+
+// encodeRow returns the atom_hash and stored args column value of atom,
+// interning its arguments if the store has a dictionary. The hash is always
+// computed over the original constants.
+func (s *FactStoreDB) encodeRow(atom ast.Atom) (int64, []byte, error) {
+	if s.dict == nil {
+		return s.codec.encodeRow(atom)
+	}
+	hash, err := atomHash(atom)
+	if err != nil {
+		return 0, nil, err
+	}
+	interned, err := s.dict.internArgs(atom)
+	if err != nil {
+		return 0, nil, err
+	}
+	_, args, err := s.codec.encodeRow(interned)
+	return hash, args, err
+}
+
+// decodeArgJSON decodes the JSON text of a single top-level argument,
+// resolving references to the dictionary.
+func (s *FactStoreDB) decodeArgJSON(argJSON string) (ast.Constant, error) {
+	c, err := unmarshalConstant(argJSON)
+	if err != nil || s.dict == nil {
+		return c, err
+	}
+	return s.dict.resolve(c)
+}
+
+// argJSON returns the JSON a top-level argument equal to c is stored as.
+func (s *FactStoreDB) argJSON(c ast.Constant) (string, error) {
+	if s.dict == nil {
+		return marshalConstant(c)
+	}
+	return s.dict.argJSON(c)
+}
+
+// newQuery returns an empty query over the store's facts.
+func (s *FactStoreDB) newQuery() *factsQuery {
+	q := newFactsQuery(s.dialect)
+	q.dict = s.dict
+	q.typed = s.typed
+	return q
+}
@@ -0,0 +1,252 @@
+package factstoredb
+
+import (
+	"bytes"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/google/mangle/ast"
+	"github.com/google/mangle/factstore"
+)
+
+// newInterningSQLiteStore is a factory for in-memory SQLite stores that intern names.
+func newInterningSQLiteStore() (*FactStoreDB, error) {
+	return NewFactStoreSQLite(":memory:", WithInterning(0))
+}
+
+func TestSQLiteInterning(t *testing.T) {
+	runSuite(t, func() (factstore.FactStoreWithRemove, error) {
+		return newInterningSQLiteStore()
+	})
+
+	t.Run("ReadWrite", func(t *testing.T) {
+		runReadWriteTest(t, newInterningSQLiteStore)
+	})
+
+	t.Run("Storage", func(t *testing.T) {
+		store, err := newInterningSQLiteStore()
+		if err != nil {
+			t.Fatalf("Failed to create store: %v", err)
+		}
+		t.Cleanup(func() { store.Close() })
+
+		store.Add(evalAtom(`owns(/alice, /book, "a long title")`))
+		store.Add(evalAtom(`owns(/alice, /pen, "short")`))
+		store.Add(evalAtom(`owns(/bob, /book, "a long title")`))
+
+		var n int
+		if err := store.db.QueryRow("SELECT COUNT(*) FROM facts WHERE instr(json(args), 'alice') > 0").Scan(&n); err != nil {
+			t.Fatalf("Failed to inspect args: %v", err)
+		}
+		if n != 0 {
+			t.Errorf("%d facts hold the name /alice in args, want 0", n)
+		}
+		if err := store.db.QueryRow("SELECT COUNT(*) FROM constants").Scan(&n); err != nil {
+			t.Fatalf("Failed to count constants: %v", err)
+		}
+		if n != 4 {
+			t.Errorf("constants table has %d rows, want 4 names", n)
+		}
+
+		var got []string
+		if err := store.GetFacts(atom("owns(/alice, X, Y)"), func(a ast.Atom) error {
+			got = append(got, a.String())
+			return nil
+		}); err != nil {
+			t.Fatalf("GetFacts() error = %v", err)
+		}
+		slices.Sort(got)
+		want := []string{`owns(/alice,/book,"a long title")`, `owns(/alice,/pen,"short")`}
+		if !slices.Equal(got, want) {
+			t.Errorf("GetFacts(owns(/alice, X, Y)) = %v, want %v", got, want)
+		}
+		if ok, err := store.Exists(atom("owns(/carol, X, Y)")); err != nil || ok {
+			t.Errorf("Exists(owns(/carol, X, Y)) = %v, %v, want false", ok, err)
+		}
+
+		var buf bytes.Buffer
+		if _, err := store.WriteTo(&buf); err != nil {
+			t.Fatalf("WriteTo() error = %v", err)
+		}
+		if !strings.Contains(buf.String(), "/alice") || strings.Contains(buf.String(), fnRef) {
+			t.Errorf("WriteTo() = %s, want plain names", buf.String())
+		}
+	})
+
+	t.Run("Queries", func(t *testing.T) {
+		store, err := newInterningSQLiteStore()
+		if err != nil {
+			t.Fatalf("Failed to create store: %v", err)
+		}
+		t.Cleanup(func() { store.Close() })
+
+		store.Add(evalAtom("edge(/a, /b)"))
+		store.Add(evalAtom("edge(/b, /b)"))
+		store.Add(evalAtom("edge(/b, /c)"))
+		store.Add(evalAtom("blocked(/c)"))
+		store.Add(evalAtom("weight(/a, 3)"))
+		store.Add(evalAtom("weight(/b, 5)"))
+
+		var got []string
+		err = store.GetBindings(atom("edge(X, X)"), func(subst ast.ConstSubstMap) error {
+			got = append(got, subst[ast.Variable{Symbol: "X"}].String())
+			return nil
+		})
+		if err != nil || !slices.Equal(got, []string{"/b"}) {
+			t.Errorf("GetBindings(edge(X, X)) = %v, %v, want [/b]", got, err)
+		}
+
+		count := func(opts ...QueryOption) int {
+			t.Helper()
+			n := 0
+			if err := store.Query(atom("edge(X, Y)"), func(ast.Atom) error {
+				n++
+				return nil
+			}, opts...); err != nil {
+				t.Fatalf("Query() error = %v", err)
+			}
+			return n
+		}
+		if n := count(Where(In(0, name("/a"), name("/z")))); n != 1 {
+			t.Errorf("Query() with In(0, /a, /z) = %d facts, want 1", n)
+		}
+		if n := count(Where(Ne(1, name("/b")))); n != 1 {
+			t.Errorf("Query() with Ne(1, /b) = %d facts, want 1", n)
+		}
+		if n := count(NotExists(atom("blocked(Y)"))); n != 2 {
+			t.Errorf("Query() with NotExists(blocked(Y)) = %d facts, want 2", n)
+		}
+
+		var heavy []string
+		if err := store.Query(atom("weight(X, W)"), func(a ast.Atom) error {
+			heavy = append(heavy, a.String())
+			return nil
+		}, Where(Gt(1, ast.Number(4)))); err != nil || !slices.Equal(heavy, []string{"weight(/b,5)"}) {
+			t.Errorf("Query() with Gt(1, 4) = %v, %v, want [weight(/b,5)]", heavy, err)
+		}
+
+		rows, err := store.Aggregate(atom("edge(X, Y)"), []ast.Variable{{Symbol: "X"}}, []Aggregate{Count()})
+		if err != nil {
+			t.Fatalf("Aggregate() error = %v", err)
+		}
+		var groups []string
+		for _, row := range rows {
+			groups = append(groups, row[0].String()+"="+row[1].String())
+		}
+		slices.Sort(groups)
+		if want := []string{"/a=1", "/b=2"}; !slices.Equal(groups, want) {
+			t.Errorf("Aggregate() = %v, want %v", groups, want)
+		}
+
+		reached, err := store.Reachable(ast.PredicateSym{Symbol: "edge", Arity: 2}, name("/a"), 0)
+		if err != nil {
+			t.Fatalf("Reachable() error = %v", err)
+		}
+		var names []string
+		for _, c := range reached {
+			names = append(names, c.String())
+		}
+		slices.Sort(names)
+		if want := []string{"/b", "/c"}; !slices.Equal(names, want) {
+			t.Errorf("Reachable(/a) = %v, want %v", names, want)
+		}
+
+		// The database only sees ids, so comparing name contents is refused.
+		for _, opt := range []QueryOption{
+			Where(HasNamePrefix(0, "/a")),
+			Where(Lt(0, name("/b"))),
+			OrderBy(Asc(0)),
+		} {
+			if err := store.Query(atom("edge(X, Y)"), func(ast.Atom) error { return nil }, opt); err == nil {
+				t.Error("Query() comparing interned names succeeded, want error")
+			}
+		}
+	})
+}
+
+func TestInterningLongStrings(t *testing.T) {
+	for _, codec := range []Codec{CodecJSON, CodecBinary} {
+		t.Run(string(codec), func(t *testing.T) {
+			store, err := NewFactStoreSQLite(":memory:", WithCodec(codec), WithInterning(8))
+			if err != nil {
+				t.Fatalf("Failed to create store: %v", err)
+			}
+			defer store.Close()
+
+			facts := []ast.Atom{
+				evalAtom(`doc(/d1, "short", "a rather long string")`),
+				evalAtom(`doc(/d2, "tiny", "a rather long string")`),
+				evalAtom(`doc(/d3, [/nested], {/k: "another long string"})`),
+			}
+			for _, f := range facts {
+				store.Add(f)
+			}
+			var n int
+			if err := store.db.QueryRow("SELECT COUNT(*) FROM constants").Scan(&n); err != nil {
+				t.Fatalf("Failed to count constants: %v", err)
+			}
+			// Three names and one long string; nested constants are not interned.
+			if n != 4 {
+				t.Errorf("constants table has %d rows, want 4", n)
+			}
+			for _, f := range facts {
+				if !store.Contains(f) {
+					t.Errorf("Contains(%v) = false, want true", f)
+				}
+			}
+			var got int
+			if err := store.GetFacts(atom(`doc(X, Y, "a rather long string")`), func(ast.Atom) error {
+				got++
+				return nil
+			}); err != nil || got != 2 {
+				t.Errorf("GetFacts() by a long string = %d facts, %v, want 2", got, err)
+			}
+			if !store.Remove(facts[0]) || store.Contains(facts[0]) || !store.Contains(facts[1]) {
+				t.Errorf("Remove(%v) did not remove exactly that fact", facts[0])
+			}
+		})
+	}
+}
+
+func TestInterningMetadata(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "facts.db")
+	fact := evalAtom("p(/a, /b)")
+
+	store, err := NewFactStoreSQLite(path, WithInterning(16))
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	store.Add(fact)
+	store.Close()
+
+	// The recorded setting is used when none is given.
+	store, err = NewFactStoreSQLite(path)
+	if err != nil {
+		t.Fatalf("Failed to reopen store: %v", err)
+	}
+	if store.dict == nil || store.dict.minStringLen != 16 {
+		t.Errorf("reopened store dictionary = %+v, want minStringLen 16", store.dict)
+	}
+	if !store.Contains(fact) {
+		t.Errorf("reopened store lost %v", fact)
+	}
+	store.Close()
+
+	if _, err := NewFactStoreSQLite(path, WithInterning(0)); err == nil {
+		t.Error("opening a store with a different interning setting succeeded, want error")
+	}
+
+	// Stores written without interning cannot be opened with it.
+	plainPath := filepath.Join(t.TempDir(), "plain.db")
+	store, err = NewFactStoreSQLite(plainPath)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	store.Add(fact)
+	store.Close()
+	if _, err := NewFactStoreSQLite(plainPath, WithInterning(0)); err == nil {
+		t.Error("opening a plain store with interning succeeded, want error")
+	}
+}
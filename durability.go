@@ -0,0 +1,166 @@
+package factstoredb
+
+import (
+	"fmt"
+	"maps"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// Durability names a trade-off between the speed of writes and the safety of
+// committed facts when the machine crashes or loses power. A crash of the
+// process alone never loses committed facts.
+type Durability string
+
+const (
+	// DurabilityFast does not wait for the disk on commit. On power loss,
+	// recently committed facts may be lost and SQLite databases may be
+	// corrupted. Use it for stores that can be rebuilt.
+	DurabilityFast Durability = "fast"
+	// DurabilityBalanced is the default. On SQLite, it waits for the disk at
+	// WAL checkpoints only: on power loss, the most recent commits may be
+	// rolled back, but the database stays consistent. On Postgres, commits
+	// wait for the local WAL flush and survive power loss of the server;
+	// synchronous standbys may not have them yet, so a failover can lose them.
+	DurabilityBalanced Durability = "balanced"
+	// DurabilityStrict waits for the disk on every commit, with full syncs on
+	// platforms where a plain sync does not reach the disk, and for
+	// synchronous standbys on Postgres. Committed facts survive power loss.
+	DurabilityStrict Durability = "strict"
+)
+
+// durabilityProfile holds the database settings of a Durability.
+type durabilityProfile struct {
+	// pragmas are the per-connection SQLite PRAGMAs of the profile.
+	pragmas map[string]string
+	// synchronousCommit is the Postgres synchronous_commit setting.
+	synchronousCommit string
+}
+
+var durabilityProfiles = map[Durability]durabilityProfile{
+	DurabilityFast: {
+		pragmas:           map[string]string{"synchronous": "OFF"},
+		synchronousCommit: "off",
+	},
+	DurabilityBalanced: {
+		pragmas:           map[string]string{"synchronous": "NORMAL"},
+		synchronousCommit: "local",
+	},
+	DurabilityStrict: {
+		pragmas:           map[string]string{"synchronous": "FULL", "fullfsync": "1", "checkpoint_fullfsync": "1"},
+		synchronousCommit: "on",
+	},
+}
+
+// metaDurabilityKey is the factstore_meta key recording the durability
+// profile last set WithDurability. Opening a store without it leaves the
+// recorded profile as it is.
+const metaDurabilityKey = "durability"
+
+// WithDurability sets the durability profile of the store, DurabilityBalanced
+// by default. On SQLite, the profile sets the synchronous PRAGMA and related
+// ones on every connection of stores opened with NewFactStoreSQLite; PRAGMAs
+// set WithPragma take precedence. On Postgres, NewFactStorePostgreSQL sets
+// synchronous_commit for its connections unless the connection string does.
+// The profile is recorded in the store metadata, also by stores created from
+// an existing *sql.DB, but the connections of a Postgres pool must be
+// configured by the caller.
+func WithDurability(d Durability) StoreOption {
+	return func(c *config) {
+		c.durability = d
+		c.durabilitySet = true
+	}
+}
+
+// profile returns the settings of the configured durability.
+func (c *config) profile() (durabilityProfile, error) {
+	p, ok := durabilityProfiles[c.durability]
+	if !ok {
+		return durabilityProfile{}, fmt.Errorf("unknown durability profile %q", c.durability)
+	}
+	return p, nil
+}
+
+// sqlitePragmas returns the PRAGMAs to apply: those of the durability
+// profile, overridden by the configured ones.
+func (c *config) sqlitePragmas() (map[string]string, error) {
+	p, err := c.profile()
+	if err != nil {
+		return nil, err
+	}
+	pragmas := maps.Clone(p.pragmas)
+	maps.Copy(pragmas, c.pragmas)
+	return pragmas, nil
+}
+
+// withConnectionPragmas adds the PRAGMAs of the durability profile, with
+// their values in pragmas, to a SQLite connection string. The driver runs
+// them on every new connection, whereas a PRAGMA executed on the *sql.DB
+// only applies to the pooled connection that happens to run it.
+func (c *config) withConnectionPragmas(connStr string, pragmas map[string]string) string {
+	p, _ := c.profile()
+	keys := make([]string, 0, len(p.pragmas))
+	for key := range p.pragmas {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	params := url.Values{}
+	for _, key := range keys {
+		params.Add("_pragma", key+"("+pragmas[key]+")")
+	}
+	sep := "?"
+	if strings.Contains(connStr, "?") {
+		sep = "&"
+	}
+	return connStr + sep + params.Encode()
+}
+
+// withSynchronousCommit adds the synchronous_commit setting of the durability
+// profile to a Postgres connection string, unless the string sets it already.
+func (c *config) withSynchronousCommit(connStr string) string {
+	p, _ := c.profile()
+	if strings.Contains(connStr, "synchronous_commit") {
+		return connStr
+	}
+	return withPostgresParam(connStr, "synchronous_commit", p.synchronousCommit)
+}
+
+// withPostgresParam adds a run-time parameter to a Postgres connection
+// string in URL or keyword/value form.
+func withPostgresParam(connStr, key, value string) string {
+	if strings.HasPrefix(connStr, "postgres://") || strings.HasPrefix(connStr, "postgresql://") {
+		sep := "?"
+		if strings.Contains(connStr, "?") {
+			sep = "&"
+		}
+		return connStr + sep + key + "=" + url.QueryEscape(value)
+	}
+	if connStr == "" {
+		return key + "=" + value
+	}
+	return connStr + " " + key + "=" + value
+}
+
+// StoreConfig describes the settings of an open store.
+type StoreConfig struct {
+	// Durability is the durability profile the store was opened with.
+	Durability Durability
+	// Pragmas holds the PRAGMAs applied to a SQLite store; nil for Postgres.
+	Pragmas map[string]string
+	// Codec is the codec of the args column.
+	Codec Codec
+	// Interning reports whether constants are interned in a dictionary.
+	Interning bool
+}
+
+// Config returns the settings of the store.
+func (s *FactStoreDB) Config() StoreConfig {
+	return StoreConfig{
+		Durability: s.durability,
+		Pragmas:    maps.Clone(s.pragmas),
+		Codec:      s.codec.name(),
+		Interning:  s.dict != nil,
+	}
+}
@@ -0,0 +1,84 @@
+package factstoredb
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestDurability(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name        string
+		opts        []StoreOption
+		want        Durability
+		recorded    Durability
+		synchronous int
+	}{
+		{"default", nil, DurabilityBalanced, "", 1},
+		{"fast", []StoreOption{WithDurability(DurabilityFast)}, DurabilityFast, DurabilityFast, 0},
+		{"strict", []StoreOption{WithDurability(DurabilityStrict)}, DurabilityStrict, DurabilityStrict, 2},
+		{"pragma override", []StoreOption{WithDurability(DurabilityStrict), WithPragma("synchronous", "OFF")}, DurabilityStrict, DurabilityStrict, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, err := NewFactStoreSQLite(filepath.Join(dir, tt.name+".db"), tt.opts...)
+			if err != nil {
+				t.Fatalf("Failed to create store: %v", err)
+			}
+			defer store.Close()
+
+			if got := store.Config().Durability; got != tt.want {
+				t.Errorf("Config().Durability = %q, want %q", got, tt.want)
+			}
+			if got, err := store.metadata(metaDurabilityKey); err != nil || got != string(tt.recorded) {
+				t.Errorf("metadata(%q) = %q, %v, want %q", metaDurabilityKey, got, err, tt.recorded)
+			}
+			// Every pooled connection runs with the setting.
+			ctx := context.Background()
+			for range 3 {
+				conn, err := store.db.Conn(ctx)
+				if err != nil {
+					t.Fatalf("Failed to get connection: %v", err)
+				}
+				defer conn.Close()
+				var synchronous int
+				if err := conn.QueryRowContext(ctx, "PRAGMA synchronous").Scan(&synchronous); err != nil || synchronous != tt.synchronous {
+					t.Errorf("PRAGMA synchronous = %d, %v, want %d", synchronous, err, tt.synchronous)
+				}
+			}
+		})
+	}
+
+	if _, err := NewFactStoreSQLite(":memory:", WithDurability("reckless")); err == nil {
+		t.Error("NewFactStoreSQLite() with an unknown durability succeeded, want error")
+	}
+
+	// Reopening with the default profile keeps the recorded one.
+	store, err := NewFactStoreSQLite(filepath.Join(dir, "strict.db"))
+	if err != nil {
+		t.Fatalf("Failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	if got := store.Config().Durability; got != DurabilityBalanced {
+		t.Errorf("Config().Durability of reopened store = %q, want %q", got, DurabilityBalanced)
+	}
+	if got, err := store.metadata(metaDurabilityKey); err != nil || got != string(DurabilityStrict) {
+		t.Errorf("metadata(%q) of reopened store = %q, %v, want %q", metaDurabilityKey, got, err, DurabilityStrict)
+	}
+}
+
+func TestWithSynchronousCommit(t *testing.T) {
+	cfg := defaultConfig()
+	WithDurability(DurabilityFast)(cfg)
+	for connStr, want := range map[string]string{
+		"postgres://u@localhost/db":                      "postgres://u@localhost/db?synchronous_commit=off",
+		"postgres://u@localhost/db?sslmode=disable":      "postgres://u@localhost/db?sslmode=disable&synchronous_commit=off",
+		"host=localhost dbname=db":                       "host=localhost dbname=db synchronous_commit=off",
+		"host=localhost synchronous_commit=remote_apply": "host=localhost synchronous_commit=remote_apply",
+	} {
+		if got := cfg.withSynchronousCommit(connStr); got != want {
+			t.Errorf("withSynchronousCommit(%q) = %q, want %q", connStr, got, want)
+		}
+	}
+}
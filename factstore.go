@@ -7,9 +7,13 @@ import (
 	"hash/fnv"
 	"io"
 	"log"
+	"os"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/go-json-experiment/json/jsontext"
 
@@ -24,12 +28,36 @@ type FactStoreDB struct {
 	db *sql.DB
 	// ownsDB indicates whether this store owns the db connection and should close it
 	ownsDB bool
+	// readOnly is set for stores opened with NewFactStoreSQLiteReadOnly, which
+	// neither change the schema nor prepare write statements.
+	readOnly bool
 	// dialect handles SQL syntax differences between databases.
 	dialect dialect
+	// codec encodes the args column.
+	codec argCodec
+	// dict interns constants if the store was created WithInterning; nil otherwise.
+	dict *dictionary
+	// typed holds the tables of typed predicates; nil if there are none.
+	typed map[ast.PredicateSym]*typedTable
 	// Prepared statements for performance
 	addStmt      *sql.Stmt
 	removeStmt   *sql.Stmt
 	containsStmt *sql.Stmt
+	// stmts caches prepared statements of queries by shape; nil if disabled.
+	stmts *stmtCache
+	// durability is the profile the store was opened with.
+	durability Durability
+	// pragmas holds the PRAGMAs applied to a SQLite store; nil otherwise.
+	pragmas map[string]string
+	// connStr is the connection string of a store opened with
+	// NewFactStorePostgreSQL, which Clone connects to the clone with.
+	connStr string
+	// tempDir is removed by Close; it holds the database of a clone on disk.
+	tempDir string
+	// dropSchema is dropped by Close; it holds the tables of a Postgres clone.
+	dropSchema string
+	// writes counts the facts written, for ScheduleMaintenance to measure write load.
+	writes atomic.Int64
 }
 
 // Verify that DBFactStore implements the FactStoreWithRemove interface
@@ -43,10 +71,15 @@ func (s *FactStoreDB) Add(atom ast.Atom) bool {
 	// if !atom.IsGround() {
 	// 	return false
 	// }
+	s.writes.Add(1)
+	if t := s.typed[atom.Predicate]; t != nil {
+		return t.add(atom)
+	}
+
 	// Convert atom to row format
 	// also verifies all args are constants
 	predicate := predicateToKey(atom.Predicate)
-	atomHash, args, err := atomToRowForInsertBinary(atom)
+	atomHash, args, err := s.encodeRow(atom)
 	if err != nil {
 		// Cannot store atoms with non-constant args
 		return false
@@ -72,15 +105,19 @@ func (s *FactStoreDB) Add(atom ast.Atom) bool {
 // Contains returns true if given atom is already present in store.
 func (s *FactStoreDB) Contains(atom ast.Atom) bool {
 	// Convert atom to canonical form for lookup
-	atomHash, _, err := atomToRowForInsertBinary(atom)
+	atomHash, err := atomHash(atom)
 	if err != nil {
 		log.Printf("DBFactStore failed to process atom for Contains: %v", err)
 		return false
 	}
 
 	// Use prepared statement for fast lookup by atom_hash
+	containsStmt := s.containsStmt
+	if t := s.typed[atom.Predicate]; t != nil {
+		containsStmt = t.containsStmt
+	}
 	var count int
-	err = s.containsStmt.QueryRow(atomHash).Scan(&count)
+	err = containsStmt.QueryRow(atomHash).Scan(&count)
 	if err != nil {
 		log.Printf("DBFactStore failed to execute contains statement: %v", err)
 		return false
@@ -91,15 +128,20 @@ func (s *FactStoreDB) Contains(atom ast.Atom) bool {
 
 // Remove removes a fact from the store and returns true if that fact was present.
 func (s *FactStoreDB) Remove(atom ast.Atom) bool {
+	s.writes.Add(1)
 	// Convert atom to canonical form for removal
-	atomHash, _, err := atomToRowForInsertBinary(atom)
+	atomHash, err := atomHash(atom)
 	if err != nil {
 		log.Printf("DBFactStore failed to process atom for Remove: %v", err)
 		return false
 	}
 
 	// Execute the prepared statement - simple atom_hash match
-	result, err := s.removeStmt.Exec(atomHash)
+	removeStmt := s.removeStmt
+	if t := s.typed[atom.Predicate]; t != nil {
+		removeStmt = t.removeStmt
+	}
+	result, err := removeStmt.Exec(atomHash)
 	if err != nil {
 		log.Printf("DBFactStore failed to execute remove statement: %v", err)
 		return false
@@ -118,99 +160,183 @@ func (s *FactStoreDB) Remove(atom ast.Atom) bool {
 // GetFacts returns a stream of facts that match a given atom.
 // The atom may contain variables (wildcards) for pattern matching.
 func (s *FactStoreDB) GetFacts(pattern ast.Atom, callback func(ast.Atom) error) error {
-	// Build SQL query based on pattern using strings.Builder
-	var queryBuf strings.Builder
-	var params []any
-
-	// Get the dialect-specific base query.
-	queryBuf.WriteString(s.dialect.getFactsBaseSQL())
-
-	// Filter by predicate key in "symbol_arity" format (e.g., "person_1")
-	// This is much faster than LIKE pattern matching
-	predicateKey := predicateToKey(pattern.Predicate)
-	params = append(params, predicateKey)
+	_, err := s.getFacts(pattern, &queryConfig{}, callback)
+	return err
+}
 
-	// For each argument, if it's a constant, add a filter using json_extract
-	// json_extract works efficiently on JSONB binary format without parsing overhead
-	for i, arg := range pattern.Args {
-		// Check if arg is a constant (grounded) vs variable
-		if constant, ok := arg.(ast.Constant); ok {
-			// Serialize the constant to JSON in the same format we use for storage
-			var buf strings.Builder
-			enc := jsontext.NewEncoder(&buf)
-			if err := (constantJSON{constant}).MarshalJSONTo(enc); err != nil {
-				return fmt.Errorf("failed to marshal pattern arg: %w", err)
+// getFacts streams the facts matching pattern and the query configuration.
+// If cfg.wantCursor is set and the limit was reached, it returns the cursor
+// of the next page.
+func (s *FactStoreDB) getFacts(pattern ast.Atom, cfg *queryConfig, callback func(ast.Atom) error) (string, error) {
+	if t := s.typed[pattern.Predicate]; t != nil {
+		return s.getTypedFacts(t, pattern, cfg, callback)
+	}
+
+	profile := cfg.profile
+	if profile != nil {
+		*profile = QueryProfile{}
+		start := time.Now()
+		// Set the query time however the query ends, also when the callback
+		// stops it early.
+		defer func() {
+			profile.QueryTime = time.Since(start) - profile.DecodeTime - profile.CallbackTime
+		}()
+	}
+
+	var rows *sql.Rows
+	var keys []sortKey
+	if s.stmts != nil && cfg.plain() {
+		// Plain pattern queries skip building the SQL when their shape is cached.
+		key, params, err := plainQueryShape(s.dialect, s.dict, pattern)
+		if err != nil {
+			return "", err
+		}
+		rows, err = s.stmts.query(key, func() (string, error) {
+			q, _, err := s.buildGetFactsQuery(pattern, cfg)
+			if err != nil {
+				return "", err
 			}
-			queryBuf.WriteString(s.dialect.getFactsFragment(i, &params))
-			params = append(params, s.dialect.jsonParam(buf.String()))
+			return q.sql.String(), nil
+		}, params...)
+		if err != nil {
+			return "", fmt.Errorf("failed to query facts: %w", err)
+		}
+	} else {
+		q, sortKeys, err := s.buildGetFactsQuery(pattern, cfg)
+		if err != nil {
+			return "", err
+		}
+		keys = sortKeys
+		rows, err = s.query(q.sql.String(), q.params...)
+		if err != nil {
+			return "", fmt.Errorf("failed to query facts: %w", err)
 		}
-		// If it's a variable, don't filter (wildcard)
 	}
+	defer rows.Close()
 
-	query := queryBuf.String()
-	rows, err := s.db.Query(query, params...)
-	if err != nil {
-		return fmt.Errorf("failed to query facts: %w", err)
+	// RawBytes point into the driver's buffers, saving a copy per row; they
+	// are only valid until the next call to Next.
+	var predicateStr, args sql.RawBytes
+	dest := []any{&predicateStr, &args}
+	keyValues := make([]any, len(keys))
+	for i := range keyValues {
+		dest = append(dest, &keyValues[i])
 	}
-	defer rows.Close()
+	count := 0
 
 	// Process each row and call callback
 	for rows.Next() {
-		var predicateStr string
-		var argsJSON string
+		if err := rows.Scan(dest...); err != nil {
+			return "", fmt.Errorf("failed to scan row: %w", err)
+		}
+		count++
 
-		if err := rows.Scan(&predicateStr, &argsJSON); err != nil {
-			return fmt.Errorf("failed to scan row: %w", err)
+		var t0 time.Time
+		if profile != nil {
+			t0 = time.Now()
 		}
 
 		// Unmarshal directly to ast.Atom in a single efficient operation
 		// This skips parse.BaseTerm and functional.EvalAtom for better performance
 		// and avoids intermediate allocations
-		reconstructedAtom, err := unmarshalAtom(pattern.Predicate, argsJSON)
+		reconstructedAtom, err := s.codec.decodeArgs(s.dialect, pattern.Predicate, args)
 		if err != nil {
-			return fmt.Errorf("failed to unmarshal atom: %w", err)
+			return "", fmt.Errorf("failed to unmarshal atom: %w", err)
+		}
+		if s.dict != nil {
+			if err := s.dict.resolveArgs(reconstructedAtom.Args); err != nil {
+				return "", err
+			}
+		}
+		if s.codec.keepsFloats() && !constantsMatch(pattern, reconstructedAtom) {
+			// The JSON keys filtered on do not tell integral floats from numbers.
+			continue
+		}
+
+		var t1 time.Time
+		if profile != nil {
+			t1 = time.Now()
+			profile.DecodeTime += t1.Sub(t0)
 		}
 
 		// Call callback with canonical atom (already in canonical form)
-		if err := callback(reconstructedAtom); err != nil {
-			return err
+		err = callback(reconstructedAtom)
+		if profile != nil {
+			profile.CallbackTime += time.Since(t1)
+			profile.Rows = count
+		}
+		if err != nil {
+			return "", err
 		}
 	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
 
-	return rows.Err()
-}
-
-// ListPredicates lists predicates available in this store.
-func (s *FactStoreDB) ListPredicates() []ast.PredicateSym {
-	rows, err := s.db.Query(`SELECT DISTINCT predicate FROM facts`)
-	if err != nil {
-		log.Printf("DBFactStore failed to query for predicates: %v", err)
-		return nil
+	// A full page may be followed by more results.
+	if !cfg.wantCursor || count == 0 || count < cfg.limit {
+		return "", nil
 	}
-	defer rows.Close()
+	return encodeCursor(orderFingerprint(pattern, cfg.orderBy), keyValues)
+}
 
-	var predicates []ast.PredicateSym
-	for rows.Next() {
-		var predicateKey string
-		if err := rows.Scan(&predicateKey); err != nil {
-			log.Printf("DBFactStore failed to scan predicate row: %v", err)
-			continue
+// constantsMatch reports whether the constant arguments of pattern equal
+// those of fact.
+func constantsMatch(pattern, fact ast.Atom) bool {
+	for i, arg := range pattern.Args {
+		if c, ok := arg.(ast.Constant); ok && !c.Equals(fact.Args[i]) {
+			return false
 		}
+	}
+	return true
+}
 
-		// Parse "symbol_arity" format (e.g., "person_2" or "my_predicate_3")
-		pred, err := keyToPredicate(predicateKey)
-		if err != nil {
-			log.Printf("DBFactStore %v", err)
-			continue
-		}
+// buildGetFactsQuery builds the query run by getFacts. If cfg.wantCursor is
+// set, the sort keys are selected after the predicate and args columns.
+func (s *FactStoreDB) buildGetFactsQuery(pattern ast.Atom, cfg *queryConfig) (*factsQuery, []sortKey, error) {
+	// Build SQL query based on pattern
+	q := s.newQuery()
+
+	var keys []sortKey
+	if cfg.wantCursor {
+		// Select the sort keys too, to build the cursor from the last row.
+		keys = sortKeys(s.dialect, cfg.orderBy)
+		q.sql.WriteString("SELECT predicate, ")
+		q.sql.WriteString(s.codec.selectSQL(s.dialect))
+		q.writeSortColumns(keys)
+		q.sql.WriteString(" FROM facts WHERE predicate = ")
+		q.sql.WriteString(q.bind(predicateToKey(pattern.Predicate)))
+	} else {
+		// Get the dialect-specific base query.
+		q.sql.WriteString(s.dialect.getFactsBaseSQL(s.codec.selectSQL(s.dialect)))
+
+		// Filter by predicate key in "symbol_arity" format (e.g., "person_1")
+		// This is much faster than LIKE pattern matching
+		q.params = append(q.params, predicateToKey(pattern.Predicate))
+	}
 
-		predicates = append(predicates, pred)
+	// For each argument, if it's a constant, add a filter using json_extract
+	// json_extract works efficiently on JSONB binary format without parsing overhead
+	if err := q.writePatternFilters(pattern); err != nil {
+		return nil, nil, err
 	}
-	if err := rows.Err(); err != nil {
-		log.Printf("DBFactStore error iterating predicate rows: %v", err)
+	if err := q.writeConstraints(cfg.constraints); err != nil {
+		return nil, nil, err
+	}
+	if err := q.writeNotExists(pattern, cfg.notExists); err != nil {
+		return nil, nil, err
 	}
+	if cfg.ordered() {
+		if err := q.writeOrdering(pattern, cfg); err != nil {
+			return nil, nil, err
+		}
+	}
+	return q, keys, nil
+}
 
-	return predicates
+// ListPredicates lists predicates available in this store.
+func (s *FactStoreDB) ListPredicates() []ast.PredicateSym {
+	return slices.Collect(s.Predicates())
 }
 
 // EstimateFactCount returns the estimated number of facts in the store.
@@ -223,6 +349,14 @@ func (s *FactStoreDB) EstimateFactCount() int {
 		log.Printf("DBFactStore failed to estimate fact count: %v", err)
 		return 0
 	}
+	for pred, t := range s.typed {
+		var typedCount int
+		if err := s.db.QueryRow("SELECT COUNT(*) FROM " + t.name).Scan(&typedCount); err != nil {
+			log.Printf("DBFactStore failed to count facts of typed predicate %v: %v", pred, err)
+			continue
+		}
+		count += typedCount
+	}
 
 	return count
 }
@@ -259,28 +393,12 @@ func (s *FactStoreDB) Merge(other factstore.ReadOnlyFactStore) {
 // batchInsertFacts inserts a slice of facts using optimized multi-row INSERT statements.
 // This is significantly faster than individual INSERTs, especially for large batches.
 func (s *FactStoreDB) batchInsertFacts(facts []ast.Atom) error {
-	const batchSize = 500 // Optimal batch size balancing SQL parsing vs transaction size
-
 	// Pre-compute all rows outside transaction to minimize lock time
-	type row struct {
-		predicate string
-		atomHash  int64
-		args      []byte
-	}
-	rows := make([]row, 0, len(facts))
-	for _, fact := range facts {
-		predicate := predicateToKey(fact.Predicate)
-		atomHash, args, err := atomToRowForInsertBinary(fact)
-		if err != nil {
-			// Skip non-grounded atoms (shouldn't happen in a proper FactStore)
-			continue
-		}
-		rows = append(rows, row{predicate, atomHash, args})
-	}
-
-	if len(rows) == 0 {
+	rows := s.encodeRows(facts)
+	if rows.empty() {
 		return nil
 	}
+	s.writes.Add(int64(len(facts)))
 
 	// Begin transaction
 	tx, err := s.db.Begin()
@@ -289,36 +407,117 @@ func (s *FactStoreDB) batchInsertFacts(facts []ast.Atom) error {
 	}
 	defer tx.Rollback() // Rollback is a no-op if Commit succeeds
 
+	if err := s.insertRows(tx, rows, nil); err != nil {
+		return err
+	}
+
+	// Commit transaction
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// factRows holds facts encoded for insertion by insertRows.
+type factRows struct {
+	rows []factRow
+	// Facts of typed predicates are inserted into their tables one by one.
+	typedRows   [][]any
+	typedTables []*typedTable
+}
+
+type factRow struct {
+	predicate string
+	atomHash  int64
+	args      []byte
+}
+
+func (r *factRows) empty() bool {
+	return len(r.rows) == 0 && len(r.typedRows) == 0
+}
+
+// encodeRows encodes facts for insertion, skipping those that cannot be
+// stored. Encoding may intern constants, so it must happen outside of the
+// transaction the rows are inserted in.
+func (s *FactStoreDB) encodeRows(facts []ast.Atom) *factRows {
+	r := &factRows{rows: make([]factRow, 0, len(facts))}
+	for _, fact := range facts {
+		s.appendRow(r, fact, nil)
+	}
+	return r
+}
+
+// appendRow encodes fact into r, unless it cannot be stored. If storedHash
+// is not nil, the row gets that atom_hash instead of the hash of fact.
+func (s *FactStoreDB) appendRow(r *factRows, fact ast.Atom, storedHash *int64) {
+	if t := s.typed[fact.Predicate]; t != nil {
+		values, err := t.row(fact)
+		if err != nil {
+			return
+		}
+		if storedHash != nil {
+			values[0] = *storedHash
+		}
+		r.typedRows = append(r.typedRows, values)
+		r.typedTables = append(r.typedTables, t)
+		return
+	}
+	predicate := predicateToKey(fact.Predicate)
+	atomHash, args, err := s.encodeRow(fact)
+	if err != nil {
+		// Skip non-grounded atoms (shouldn't happen in a proper FactStore)
+		return
+	}
+	if storedHash != nil {
+		atomHash = *storedHash
+	}
+	r.rows = append(r.rows, factRow{predicate, atomHash, args})
+}
+
+// insertRows inserts encoded rows in tx. If progress is not nil, it is
+// called with the number of rows inserted after each batch.
+func (s *FactStoreDB) insertRows(tx *sql.Tx, r *factRows, progress func(int)) error {
+	const batchSize = 500 // Optimal batch size balancing SQL parsing vs transaction size
+
 	// Process rows in batches using multi-row INSERT
-	for i := 0; i < len(rows); i += batchSize {
-		end := min(i+batchSize, len(rows))
-		batch := rows[i:end]
+	for i := 0; i < len(r.rows); i += batchSize {
+		end := min(i+batchSize, len(r.rows))
+		batch := r.rows[i:end]
 
 		// Generate the dialect-specific multi-row INSERT statement.
-		sql := s.dialect.batchInsertSQL(len(batch))
+		sql := s.dialect.batchInsertSQL(len(batch), s.codec.column(), s.argsValueSQL)
 
 		// Pre-allocate params slice
 		params := make([]any, 0, len(batch)*3)
-		for _, r := range batch {
-			params = append(params, r.predicate, r.atomHash, r.args)
+		for _, row := range batch {
+			params = append(params, row.predicate, row.atomHash, row.args)
 		}
 
 		// Execute batch insert
 		if _, err := tx.Exec(sql, params...); err != nil {
 			return fmt.Errorf("failed to execute batch insert: %w", err)
 		}
+		if progress != nil {
+			progress(end)
+		}
 	}
 
-	// Commit transaction
-	if err := tx.Commit(); err != nil {
-		return fmt.Errorf("failed to commit transaction: %w", err)
+	for i, values := range r.typedRows {
+		if _, err := tx.Stmt(r.typedTables[i].addStmt).Exec(values...); err != nil {
+			return fmt.Errorf("failed to insert into typed predicate %v: %w", r.typedTables[i].pred, err)
+		}
+		if progress != nil && ((i+1)%batchSize == 0 || i+1 == len(r.typedRows)) {
+			progress(len(r.rows) + i + 1)
+		}
 	}
-
 	return nil
 }
 
 // WriteTo writes all facts from the store to w in JSON format.
 // It implements the io.WriterTo interface.
+// Strings that look like names or bytes are written as {"fn:string": [...]}
+// objects, which versions of this package before fn:string reject.
 // Facts are streamed directly to the writer without intermediate buffering,
 // making this efficient for large fact stores.
 // Returns the number of bytes written and any error encountered.
@@ -451,6 +650,11 @@ func (cr *countingReader) Read(p []byte) (n int, err error) {
 // Close closes the prepared statements and database connection (if owned).
 // If the store was created with a FromDB constructor, the db connection is not closed.
 func (s *FactStoreDB) Close() error {
+	s.stmts.close()
+	s.dict.close()
+	for _, t := range s.typed {
+		t.close()
+	}
 	if s.addStmt != nil {
 		s.addStmt.Close()
 	}
@@ -460,11 +664,25 @@ func (s *FactStoreDB) Close() error {
 	if s.containsStmt != nil {
 		s.containsStmt.Close()
 	}
+	if s.dropSchema != "" {
+		if _, err := s.db.Exec("DROP SCHEMA " + quoteIdent(s.dropSchema) + " CASCADE"); err != nil {
+			log.Printf("DBFactStore failed to drop schema of clone: %v", err)
+		}
+	}
 	// Only close the db connection if this store owns it
-	if s.ownsDB {
-		return s.db.Close()
+	if !s.ownsDB {
+		return nil
 	}
-	return nil
+	err := s.db.Close()
+	if s.tempDir != "" {
+		os.RemoveAll(s.tempDir)
+	}
+	return err
+}
+
+// argsValueSQL wraps the placeholder of an args value in an INSERT for the store's codec.
+func (s *FactStoreDB) argsValueSQL(placeholder string) string {
+	return s.codec.valueSQL(s.dialect, placeholder)
 }
 
 // Helper Functions
@@ -541,12 +759,7 @@ func getSortedConstants(c ast.Constant) ([]ast.Constant, error) {
 // Returns an error if any arg is not a constant.
 // Callers can compute the predicate key using predicateToKey(atom.Predicate).
 func atomToRowForInsertBinary(atom ast.Atom) (int64, []byte, error) {
-
-	// Compute hash starting with predicate
-	h := fnv.New64a()
-	_, _ = h.Write([]byte(atom.Predicate.Symbol))
-	predicateHash := h.Sum64()
-	hashResult := szudzikElegantPair(predicateHash, uint64(atom.Predicate.Arity))
+	hashResult := predicateHash(atom.Predicate)
 
 	// Marshal constants to JSON while also computing the hash in a single pass.
 	var buf bytes.Buffer
@@ -562,21 +775,9 @@ func atomToRowForInsertBinary(atom ast.Atom) (int64, []byte, error) {
 		if err := (constantJSON{c}).MarshalJSONTo(enc); err != nil {
 			return 0, nil, fmt.Errorf("failed to marshal arg to JSON: %w", err)
 		}
-		// For maps and structs, we need an order-insensitive hash.
-		// We get the key-value pairs, sort them by key, and then hash them in order.
-		if c.Type == ast.MapShape || c.Type == ast.StructShape {
-			sorted, err := getSortedConstants(c)
-			if err != nil {
-				return 0, nil, fmt.Errorf("failed to sort map/struct for hashing: %w", err)
-			}
-			for _, part := range sorted {
-				partHash := szudzikElegantPair(part.Hash(), uint64(part.Type))
-				hashResult = szudzikElegantPair(hashResult, partHash)
-			}
-		} else {
-			// For other types, hash directly.
-			argHashWithType := szudzikElegantPair(c.Hash(), uint64(c.Type))
-			hashResult = szudzikElegantPair(hashResult, argHashWithType)
+		var err error
+		if hashResult, err = hashArg(hashResult, c); err != nil {
+			return 0, nil, err
 		}
 	}
 	_ = enc.WriteToken(jsontext.EndArray)
@@ -586,6 +787,50 @@ func atomToRowForInsertBinary(atom ast.Atom) (int64, []byte, error) {
 	return atomHash, buf.Bytes(), nil
 }
 
+// atomHash returns the atom_hash of atom, which must be ground.
+func atomHash(atom ast.Atom) (int64, error) {
+	hashResult := predicateHash(atom.Predicate)
+	for _, arg := range atom.Args {
+		c, ok := arg.(ast.Constant)
+		if !ok {
+			return 0, fmt.Errorf("evaluation produced something that is not a value: %v %T", arg, arg)
+		}
+		var err error
+		if hashResult, err = hashArg(hashResult, c); err != nil {
+			return 0, err
+		}
+	}
+	return int64(hashResult), nil
+}
+
+// predicateHash returns the initial atom_hash state for facts of pred.
+func predicateHash(pred ast.PredicateSym) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(pred.Symbol))
+	return szudzikElegantPair(h.Sum64(), uint64(pred.Arity))
+}
+
+// hashArg combines the atom_hash state with the next argument. The result
+// does not depend on how arguments are encoded for storage.
+func hashArg(hashResult uint64, c ast.Constant) (uint64, error) {
+	// For maps and structs, we need an order-insensitive hash.
+	// We get the key-value pairs, sort them by key, and then hash them in order.
+	if c.Type == ast.MapShape || c.Type == ast.StructShape {
+		sorted, err := getSortedConstants(c)
+		if err != nil {
+			return 0, fmt.Errorf("failed to sort map/struct for hashing: %w", err)
+		}
+		for _, part := range sorted {
+			partHash := szudzikElegantPair(part.Hash(), uint64(part.Type))
+			hashResult = szudzikElegantPair(hashResult, partHash)
+		}
+		return hashResult, nil
+	}
+	// For other types, hash directly.
+	argHashWithType := szudzikElegantPair(c.Hash(), uint64(c.Type))
+	return szudzikElegantPair(hashResult, argHashWithType), nil
+}
+
 // flattenPairs converts a slice of sorted pairs into a flat slice of constants.
 func flattenPairs(pairs []pair) []ast.Constant {
 	flat := make([]ast.Constant, 0, len(pairs)*2)
@@ -0,0 +1,250 @@
+package factstoredb
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/google/mangle/ast"
+)
+
+// AggregateFunc identifies an aggregation function.
+type AggregateFunc int
+
+const (
+	// AggCount counts the facts in a group.
+	AggCount AggregateFunc = iota
+	// AggSum sums the numeric values of a variable.
+	AggSum
+	// AggMin returns the smallest numeric value of a variable.
+	AggMin
+	// AggMax returns the largest numeric value of a variable.
+	AggMax
+)
+
+// String returns the SQL name of the function.
+func (f AggregateFunc) String() string {
+	switch f {
+	case AggCount:
+		return "COUNT"
+	case AggSum:
+		return "SUM"
+	case AggMin:
+		return "MIN"
+	case AggMax:
+		return "MAX"
+	default:
+		return "AggregateFunc(" + strconv.Itoa(int(f)) + ")"
+	}
+}
+
+// Aggregate describes one aggregated value computed over each group.
+// Sum, Min and Max only consider NumberType and Float64Type values of Var;
+// values of other types are ignored.
+type Aggregate struct {
+	Func AggregateFunc
+	// Var is the aggregated variable. It is ignored by AggCount.
+	Var ast.Variable
+}
+
+// Count returns an aggregate counting the facts in each group.
+func Count() Aggregate {
+	return Aggregate{Func: AggCount}
+}
+
+// Sum returns an aggregate summing the numeric values bound to v.
+// The result is a NumberType if all summed values are integers, and a
+// Float64Type otherwise. A group without numeric values sums to 0.
+func Sum(v ast.Variable) Aggregate {
+	return Aggregate{Func: AggSum, Var: v}
+}
+
+// Min returns an aggregate selecting the smallest numeric value bound to v.
+func Min(v ast.Variable) Aggregate {
+	return Aggregate{Func: AggMin, Var: v}
+}
+
+// Max returns an aggregate selecting the largest numeric value bound to v.
+func Max(v ast.Variable) Aggregate {
+	return Aggregate{Func: AggMax, Var: v}
+}
+
+// String returns a readable form of the aggregate, such as "SUM(A)".
+func (a Aggregate) String() string {
+	if a.Func == AggCount {
+		return a.Func.String() + "()"
+	}
+	return a.Func.String() + "(" + a.Var.Symbol + ")"
+}
+
+// Aggregate groups the facts matching pattern by the values of groupBy and
+// computes aggs over each group, using SQL GROUP BY on the database.
+// Each result row holds the group values, in groupBy order, followed by the
+// aggregate values, in aggs order, so it can be turned back into a fact.
+// Groups for which a Min or Max aggregate has no numeric value are omitted.
+// The order of the result rows is unspecified.
+func (s *FactStoreDB) Aggregate(pattern ast.Atom, groupBy []ast.Variable, aggs []Aggregate, opts ...QueryOption) ([][]ast.Constant, error) {
+	cfg, err := newQueryConfig(pattern, opts)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.ordered() {
+		return nil, errors.New("ordering and paging options are not supported by Aggregate")
+	}
+	if err := s.requireUntyped("Aggregate", pattern.Predicate); err != nil {
+		return nil, err
+	}
+	if len(groupBy) == 0 && len(aggs) == 0 {
+		return nil, fmt.Errorf("aggregate over %v: no group-by variables or aggregates", pattern.Predicate)
+	}
+
+	vars, positions := patternVariables(pattern)
+	position := make(map[ast.Variable]int, len(vars))
+	for i, v := range vars {
+		position[v] = positions[i]
+	}
+
+	q := s.newQuery()
+	q.sql.WriteString("SELECT ")
+	for i, v := range groupBy {
+		pos, ok := position[v]
+		if !ok {
+			return nil, fmt.Errorf("group-by variable %v does not occur in %v", v, pattern)
+		}
+		if i > 0 {
+			q.sql.WriteString(", ")
+		}
+		q.sql.WriteString(s.dialect.argJSONSQL(pos))
+	}
+	for i, a := range aggs {
+		if len(groupBy) > 0 || i > 0 {
+			q.sql.WriteString(", ")
+		}
+		if a.Func == AggCount {
+			q.sql.WriteString("COUNT(*)")
+			continue
+		}
+		pos, ok := position[a.Var]
+		if !ok {
+			return nil, fmt.Errorf("aggregate %v: variable does not occur in %v", a, pattern)
+		}
+		switch a.Func {
+		case AggSum, AggMin, AggMax:
+		default:
+			return nil, fmt.Errorf("aggregate %v: unknown function", a)
+		}
+		// Non-numeric values become NULL, which aggregate functions skip.
+		q.sql.WriteString(a.Func.String())
+		q.sql.WriteString("(CASE WHEN ")
+		q.sql.WriteString(s.dialect.argGuardSQL(pos, argNumber))
+		q.sql.WriteString(" THEN ")
+		q.sql.WriteString(s.dialect.argValueSQL(pos, argNumber))
+		q.sql.WriteString(" END)")
+	}
+	q.sql.WriteString(" FROM facts WHERE predicate = ")
+	q.sql.WriteString(q.bind(predicateToKey(pattern.Predicate)))
+	if err := q.writePatternFilters(pattern); err != nil {
+		return nil, err
+	}
+	q.writeRepeatedVariableFilters(pattern)
+	if err := q.writeConstraints(cfg.constraints); err != nil {
+		return nil, err
+	}
+	if err := q.writeNotExists(pattern, cfg.notExists); err != nil {
+		return nil, err
+	}
+	if len(groupBy) > 0 {
+		// Group by select-list ordinals, which both dialects support.
+		q.sql.WriteString(" GROUP BY ")
+		for i := range groupBy {
+			if i > 0 {
+				q.sql.WriteString(", ")
+			}
+			q.sql.WriteString(strconv.Itoa(i + 1))
+		}
+	}
+
+	rows, err := s.query(q.sql.String(), q.params...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query aggregate: %w", err)
+	}
+	defer rows.Close()
+
+	keys := make([]string, len(groupBy))
+	values := make([]any, len(aggs))
+	dest := make([]any, 0, len(keys)+len(values))
+	for i := range keys {
+		dest = append(dest, &keys[i])
+	}
+	for i := range values {
+		dest = append(dest, &values[i])
+	}
+
+	var results [][]ast.Constant
+rowLoop:
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		row := make([]ast.Constant, 0, len(dest))
+		for i, key := range keys {
+			c, err := s.decodeArgJSON(key)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decode group value for %v: %w", groupBy[i], err)
+			}
+			row = append(row, c)
+		}
+		for i, a := range aggs {
+			if values[i] == nil {
+				if a.Func == AggSum {
+					row = append(row, ast.Number(0))
+					continue
+				}
+				continue rowLoop
+			}
+			c, err := numericConstant(values[i])
+			if err != nil {
+				return nil, fmt.Errorf("aggregate %v: %w", a, err)
+			}
+			row = append(row, c)
+		}
+		results = append(results, row)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// numericConstant converts a numeric SQL result into a NumberType or Float64Type constant.
+// Drivers return integers and reals natively, while PostgreSQL numeric values
+// arrive as text and are integers unless they contain a fraction or exponent.
+func numericConstant(v any) (ast.Constant, error) {
+	switch n := v.(type) {
+	case int64:
+		return ast.Number(n), nil
+	case float64:
+		return ast.Float64(n), nil
+	case []byte:
+		return parseNumericText(string(n))
+	case string:
+		return parseNumericText(n)
+	default:
+		return ast.Constant{}, fmt.Errorf("unexpected numeric result type %T", v)
+	}
+}
+
+// parseNumericText parses the text form of a SQL numeric value.
+func parseNumericText(s string) (ast.Constant, error) {
+	if !strings.ContainsAny(s, ".eE") {
+		if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return ast.Number(i), nil
+		}
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return ast.Constant{}, fmt.Errorf("invalid numeric result %q: %w", s, err)
+	}
+	return ast.Float64(f), nil
+}
@@ -0,0 +1,125 @@
+package factstoredb
+
+import (
+	"testing"
+
+	"bitbucket.org/creachadair/stringset"
+	"github.com/google/mangle/ast"
+)
+
+// runAggregateTest tests count, sum, min and max over groups.
+func runAggregateTest(t *testing.T, newStore func() (*FactStoreDB, error)) {
+	store, err := newStore()
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	facts := []ast.Atom{
+		evalAtom("sale(/east, /a, 10)"),
+		evalAtom("sale(/east, /b, 5)"),
+		evalAtom("sale(/east, /c, 7)"),
+		evalAtom("sale(/west, /d, 2.5)"),
+		evalAtom("sale(/west, /e, 4)"),
+		evalAtom(`sale(/north, /f, "n/a")`),
+	}
+	for _, f := range facts {
+		store.Add(f)
+	}
+
+	region := ast.Variable{Symbol: "R"}
+	amount := ast.Variable{Symbol: "A"}
+	pattern := atom("sale(R, _, A)")
+
+	tests := []struct {
+		name    string
+		groupBy []ast.Variable
+		aggs    []Aggregate
+		opts    []QueryOption
+		want    stringset.Set
+	}{
+		{
+			name:    "count per group",
+			groupBy: []ast.Variable{region},
+			aggs:    []Aggregate{Count()},
+			want:    stringset.New("/east 3", "/west 2", "/north 1"),
+		},
+		{
+			// Integer sums stay integers; a float makes the sum a float.
+			// A group without numbers sums to 0.
+			name:    "sum per group",
+			groupBy: []ast.Variable{region},
+			aggs:    []Aggregate{Sum(amount)},
+			want:    stringset.New("/east 22", "/west 6.5", "/north 0"),
+		},
+		{
+			// Groups without numeric values have no min or max.
+			name:    "min max per group",
+			groupBy: []ast.Variable{region},
+			aggs:    []Aggregate{Min(amount), Max(amount)},
+			want:    stringset.New("/east 5 10", "/west 2.5 4"),
+		},
+		{
+			name: "global count",
+			aggs: []Aggregate{Count(), Max(amount)},
+			want: stringset.New("6 10"),
+		},
+		{
+			name:    "group only",
+			groupBy: []ast.Variable{region},
+			want:    stringset.New("/east", "/west", "/north"),
+		},
+		{
+			name:    "with constraint",
+			groupBy: []ast.Variable{region},
+			aggs:    []Aggregate{Count()},
+			opts:    []QueryOption{Where(Ge(2, ast.Number(5)))},
+			want:    stringset.New("/east 3"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rows, err := store.Aggregate(pattern, tt.groupBy, tt.aggs, tt.opts...)
+			if err != nil {
+				t.Fatalf("Aggregate error: %v", err)
+			}
+			got := stringset.New()
+			for _, row := range rows {
+				if len(row) != len(tt.groupBy)+len(tt.aggs) {
+					t.Errorf("row %v has %d values, want %d", row, len(row), len(tt.groupBy)+len(tt.aggs))
+				}
+				var s string
+				for i, c := range row {
+					if i > 0 {
+						s += " "
+					}
+					s += c.String()
+				}
+				got.Add(s)
+			}
+			if !got.Equals(tt.want) {
+				t.Errorf("Aggregate = %v want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("result types", func(t *testing.T) {
+		rows, err := store.Aggregate(pattern, nil, []Aggregate{Sum(amount)}, Where(In(0, name("/east"))))
+		if err != nil {
+			t.Fatalf("Aggregate error: %v", err)
+		}
+		if len(rows) != 1 || rows[0][0].Type != ast.NumberType {
+			t.Errorf("Aggregate sum = %v, want a single NumberType value", rows)
+		}
+	})
+
+	t.Run("unknown variable", func(t *testing.T) {
+		if _, err := store.Aggregate(pattern, []ast.Variable{{Symbol: "Z"}}, []Aggregate{Count()}); err == nil {
+			t.Error("Aggregate with unknown group-by variable succeeded, want error")
+		}
+		if _, err := store.Aggregate(pattern, nil, []Aggregate{Sum(ast.Variable{Symbol: "Z"})}); err == nil {
+			t.Error("Aggregate with unknown aggregate variable succeeded, want error")
+		}
+	})
+}
@@ -1,7 +1,9 @@
 package factstoredb
 
 import (
+	"context"
 	"fmt"
+	"path/filepath"
 	"testing"
 
 	embeddedpostgres "github.com/fergusstrange/embedded-postgres"
@@ -56,6 +58,26 @@ func BenchmarkAdd(b *testing.B) {
 		}
 	})
 
+	b.Run("SQLiteBinary", func(b *testing.B) {
+		base, _ := NewFactStoreSQLite(":memory:", WithCodec(CodecBinary))
+		defer base.Close()
+		facts := prepareTestFacts(100000)
+		b.ResetTimer()
+		for i := 0; b.Loop(); i++ {
+			base.Add(facts[i%len(facts)])
+		}
+	})
+
+	b.Run("SQLiteTyped", func(b *testing.B) {
+		base, _ := NewFactStoreSQLite(":memory:", WithTypedPredicate(ast.PredicateSym{Symbol: "fact", Arity: 2}, ColumnName, ColumnNumber))
+		defer base.Close()
+		facts := prepareTestFacts(100000)
+		b.ResetTimer()
+		for i := 0; b.Loop(); i++ {
+			base.Add(facts[i%len(facts)])
+		}
+	})
+
 	// Run Postgres benchmarks
 	postgres := embeddedpostgres.NewDatabase(embeddedpostgres.DefaultConfig().Port(5433).Logger(nil).Logger(nil))
 	if err := postgres.Start(); err != nil {
@@ -249,6 +271,40 @@ func BenchmarkGetFactsAll(b *testing.B) {
 		}
 	})
 
+	b.Run("SQLiteBinary", func(b *testing.B) {
+		base, _ := NewFactStoreSQLite(":memory:", WithCodec(CodecBinary))
+		defer base.Close()
+		for _, f := range facts {
+			base.Add(f)
+		}
+		patternAtom := evalAtom("person(X)")
+		b.ResetTimer()
+		for b.Loop() {
+			count := 0
+			base.GetFacts(patternAtom, func(ast.Atom) error {
+				count++
+				return nil
+			})
+		}
+	})
+
+	b.Run("SQLiteTyped", func(b *testing.B) {
+		base, _ := NewFactStoreSQLite(":memory:", WithTypedPredicate(ast.PredicateSym{Symbol: "person", Arity: 1}, ColumnName))
+		defer base.Close()
+		for _, f := range facts {
+			base.Add(f)
+		}
+		patternAtom := evalAtom("person(X)")
+		b.ResetTimer()
+		for b.Loop() {
+			count := 0
+			base.GetFacts(patternAtom, func(ast.Atom) error {
+				count++
+				return nil
+			})
+		}
+	})
+
 	postgres := embeddedpostgres.NewDatabase(embeddedpostgres.DefaultConfig().Port(5433).Logger(nil))
 	if err := postgres.Start(); err != nil {
 		b.Fatalf("Failed to start embedded-postgres: %v", err)
@@ -310,6 +366,36 @@ func BenchmarkGetFactsPartialMatch(b *testing.B) {
 		}
 	})
 
+	// With an index on the bound position the lookup itself is cheap, so
+	// per-call overheads such as building and preparing the SQL show up.
+	for _, bc := range []struct {
+		name string
+		opts []StoreOption
+	}{
+		{name: "SQLiteIndexed"},
+		{name: "SQLiteIndexedCached", opts: []StoreOption{WithStatementCacheSize(defaultStmtCacheSize)}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			base, _ := NewFactStoreSQLite(":memory:", bc.opts...)
+			defer base.Close()
+			for _, f := range facts {
+				base.Add(f)
+			}
+			if err := base.CreateArgIndex(0); err != nil {
+				b.Fatalf("CreateArgIndex() error = %v", err)
+			}
+			patternAtom := evalAtom("parent(/p500, X)")
+			b.ResetTimer()
+			for b.Loop() {
+				count := 0
+				base.GetFacts(patternAtom, func(ast.Atom) error {
+					count++
+					return nil
+				})
+			}
+		})
+	}
+
 	postgres := embeddedpostgres.NewDatabase(embeddedpostgres.DefaultConfig().Port(5433).Logger(nil))
 	if err := postgres.Start(); err != nil {
 		b.Fatalf("Failed to start embedded-postgres: %v", err)
@@ -414,6 +500,43 @@ func BenchmarkMerge(b *testing.B) {
 	})
 }
 
+// Benchmark: Clone, to compare with Merge into an empty store
+
+func BenchmarkClone(b *testing.B) {
+	sourceFacts := prepareTestFacts(1000)
+
+	for _, tc := range []struct {
+		name    string
+		connStr string
+	}{
+		{"SQLiteMemory", ":memory:"},
+		{"SQLiteFile", filepath.Join(b.TempDir(), "source.db")},
+	} {
+		b.Run(tc.name, func(b *testing.B) {
+			source, err := NewFactStoreSQLite(tc.connStr)
+			if err != nil {
+				b.Fatalf("Failed to create store: %v", err)
+			}
+			defer source.Close()
+			for _, f := range sourceFacts {
+				source.Add(f)
+			}
+
+			ctx := context.Background()
+			b.ResetTimer()
+			for b.Loop() {
+				clone, err := source.Clone(ctx)
+				if err != nil {
+					b.Fatalf("Clone() error = %v", err)
+				}
+				b.StopTimer()
+				clone.Close()
+				b.StartTimer()
+			}
+		})
+	}
+}
+
 // Benchmark: Mixed workload (Add + Contains + GetFacts)
 
 func BenchmarkMixedWorkload(b *testing.B) {
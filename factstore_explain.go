@@ -0,0 +1,83 @@
+package factstoredb
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/mangle/ast"
+)
+
+// Explanation describes how the database evaluates a query.
+type Explanation struct {
+	// SQL is the statement generated for the query.
+	SQL string
+	// Params are the values bound to the placeholders of SQL.
+	Params []any
+	// Plan is the plan chosen by the database: the EXPLAIN QUERY PLAN tree
+	// on SQLite, or the EXPLAIN (FORMAT JSON) document on PostgreSQL.
+	Plan string
+}
+
+// Explain returns the SQL that Query would run for pattern and the given
+// options, together with the plan the database picks for it. The query itself
+// is not executed.
+func (s *FactStoreDB) Explain(pattern ast.Atom, opts ...QueryOption) (Explanation, error) {
+	cfg, err := newQueryConfig(pattern, opts)
+	if err != nil {
+		return Explanation{}, err
+	}
+	var query string
+	var params []any
+	if t := s.typed[pattern.Predicate]; t != nil {
+		if err := t.requireUnfiltered(cfg); err != nil {
+			return Explanation{}, err
+		}
+		page, err := newTypedPage(pattern, cfg)
+		if err != nil {
+			return Explanation{}, err
+		}
+		var ok bool
+		if query, params, _, ok = t.buildQuery(s.dialect, pattern, page); !ok {
+			return Explanation{}, fmt.Errorf("%v does not match the column types of typed predicate %v", pattern, t.pred)
+		}
+	} else {
+		q, _, err := s.buildGetFactsQuery(pattern, cfg)
+		if err != nil {
+			return Explanation{}, err
+		}
+		query, params = q.sql.String(), q.params
+	}
+
+	rows, err := s.db.Query(s.dialect.explainSQL(query), params...)
+	if err != nil {
+		return Explanation{}, fmt.Errorf("failed to explain query: %w", err)
+	}
+	defer rows.Close()
+	plan, err := s.dialect.formatPlan(rows)
+	if err != nil {
+		return Explanation{}, fmt.Errorf("failed to read query plan: %w", err)
+	}
+	return Explanation{SQL: query, Params: params, Plan: plan}, nil
+}
+
+// QueryProfile records where the time of a query went.
+type QueryProfile struct {
+	// Rows is the number of facts passed to the callback.
+	Rows int
+	// QueryTime is the time spent in the database and driver, executing the
+	// query and reading rows.
+	QueryTime time.Duration
+	// DecodeTime is the time spent decoding rows into atoms.
+	DecodeTime time.Duration
+	// CallbackTime is the time spent in the callback.
+	CallbackTime time.Duration
+}
+
+// WithProfile fills p with the profile of the query it is passed to.
+// Profiling only applies to Query, QueryPage and Facts; it adds two clock
+// readings per row and should not be left on in hot paths.
+func WithProfile(p *QueryProfile) QueryOption {
+	return func(c *queryConfig) {
+		c.profile = p
+	}
+}
@@ -0,0 +1,82 @@
+package factstoredb
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/google/mangle/ast"
+)
+
+// runExplainTest tests Explain and query profiles.
+func runExplainTest(t *testing.T, newStore func() (*FactStoreDB, error)) {
+	store, err := newStore()
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	for i := range 10 {
+		store.Add(evalAtom(fmt.Sprintf("item(/i%d, %d)", i, i)))
+	}
+
+	t.Run("Explain", func(t *testing.T) {
+		got, err := store.Explain(atom("item(/i1, X)"), Where(Gt(1, ast.Number(0))), OrderBy(Asc(1)), Limit(5))
+		if err != nil {
+			t.Fatalf("Explain() error = %v", err)
+		}
+		if !strings.Contains(got.SQL, "FROM facts") || !strings.Contains(got.SQL, "LIMIT 5") {
+			t.Errorf("Explain().SQL = %q, want a query over facts with LIMIT 5", got.SQL)
+		}
+		if len(got.Params) != 3 {
+			t.Errorf("Explain().Params = %v, want predicate, pattern and constraint values", got.Params)
+		}
+		if !strings.Contains(got.Plan, "facts") {
+			t.Errorf("Explain().Plan = %q, want a plan over facts", got.Plan)
+		}
+		if _, err := store.Explain(atom("item(X, Y)"), Limit(-1)); err == nil {
+			t.Error("Explain() with invalid option succeeded, want error")
+		}
+	})
+
+	t.Run("profile", func(t *testing.T) {
+		var p QueryProfile
+		count := 0
+		err := store.Query(atom("item(X, Y)"), func(ast.Atom) error {
+			count++
+			return nil
+		}, WithProfile(&p))
+		if err != nil {
+			t.Fatalf("Query() error = %v", err)
+		}
+		if p.Rows != count || count != 10 {
+			t.Errorf("profile.Rows = %d, want %d", p.Rows, count)
+		}
+		if p.QueryTime <= 0 || p.DecodeTime < 0 || p.CallbackTime < 0 {
+			t.Errorf("profile = %+v, want non-negative times", p)
+		}
+
+		// A query stopped by its callback records its time too.
+		var stopped QueryProfile
+		err = store.Query(atom("item(X, Y)"), func(ast.Atom) error {
+			return errStopIteration
+		}, WithProfile(&stopped))
+		if !errors.Is(err, errStopIteration) {
+			t.Fatalf("Query() error = %v, want %v", err, errStopIteration)
+		}
+		if stopped.Rows != 1 || stopped.QueryTime <= 0 {
+			t.Errorf("profile of a stopped query = %+v, want 1 row and a query time", stopped)
+		}
+
+		var prefetched QueryProfile
+		for _, err := range store.Facts(atom("item(X, Y)"), Prefetch(3), WithProfile(&prefetched)) {
+			if err != nil {
+				t.Fatalf("Facts() error = %v", err)
+			}
+		}
+		if prefetched.Rows != 10 {
+			t.Errorf("profile.Rows with Prefetch = %d, want 10", prefetched.Rows)
+		}
+	})
+}
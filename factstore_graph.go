@@ -0,0 +1,233 @@
+package factstoredb
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/go-json-experiment/json"
+	"github.com/google/mangle/ast"
+)
+
+// The graph queries treat the facts of a binary predicate as directed edges
+// from the first argument to the second. They are evaluated by the database
+// with WITH RECURSIVE, comparing nodes by their JSON text.
+
+// checkEdgePredicate reports an error unless pred can be read as an edge
+// relation by the database.
+func (s *FactStoreDB) checkEdgePredicate(pred ast.PredicateSym) error {
+	if pred.Arity != 2 {
+		return fmt.Errorf("graph query over %v: predicate must have arity 2", pred)
+	}
+	return s.requireUntyped("graph query", pred)
+}
+
+// checkMaxDepth reports an error if maxDepth is negative.
+func checkMaxDepth(maxDepth int) error {
+	if maxDepth < 0 {
+		return fmt.Errorf("invalid maximum depth %d", maxDepth)
+	}
+	return nil
+}
+
+// Reachable returns the nodes reachable from from by following one or more
+// edges of pred, and at most maxDepth edges unless maxDepth is 0. from itself
+// is included only if it lies on a cycle. Cycles are handled by visiting each
+// node once, or once per depth with a maximum depth. The order of the result
+// is unspecified.
+func (s *FactStoreDB) Reachable(pred ast.PredicateSym, from ast.Constant, maxDepth int) ([]ast.Constant, error) {
+	if err := s.checkEdgePredicate(pred); err != nil {
+		return nil, err
+	}
+	if err := checkMaxDepth(maxDepth); err != nil {
+		return nil, err
+	}
+	fromJSON, err := s.argJSON(from)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal %v: %w", from, err)
+	}
+
+	d := s.dialect
+	src, dst := d.argJSONSQL(0), d.argJSONSQL(1)
+	q := s.newQuery()
+	// UNION discards nodes that were already visited, which ends the recursion.
+	// With a maximum depth, nodes are visited once per depth up to it.
+	if maxDepth > 0 {
+		q.sql.WriteString("WITH RECURSIVE reach(node, depth) AS (SELECT ")
+		q.sql.WriteString(dst + ", 1")
+	} else {
+		q.sql.WriteString("WITH RECURSIVE reach(node) AS (SELECT ")
+		q.sql.WriteString(dst)
+	}
+	q.sql.WriteString(" FROM facts WHERE predicate = ")
+	q.sql.WriteString(q.bind(predicateToKey(pred)))
+	q.sql.WriteString(" AND " + src + " = ")
+	q.sql.WriteString(d.jsonTextParamSQL(q.bind(fromJSON)))
+	if maxDepth > 0 {
+		q.sql.WriteString(" UNION SELECT " + dst + ", reach.depth + 1 FROM facts JOIN reach ON " + src + " = reach.node WHERE predicate = ")
+		q.sql.WriteString(q.bind(predicateToKey(pred)))
+		q.sql.WriteString(" AND reach.depth < " + strconv.Itoa(maxDepth))
+		q.sql.WriteString(") SELECT DISTINCT node FROM reach")
+	} else {
+		q.sql.WriteString(" UNION SELECT " + dst + " FROM facts JOIN reach ON " + src + " = reach.node WHERE predicate = ")
+		q.sql.WriteString(q.bind(predicateToKey(pred)))
+		q.sql.WriteString(") SELECT node FROM reach")
+	}
+
+	rows, err := s.query(q.sql.String(), q.params...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query reachable nodes: %w", err)
+	}
+	defer rows.Close()
+
+	var nodes []ast.Constant
+	for rows.Next() {
+		var nodeJSON string
+		if err := rows.Scan(&nodeJSON); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		node, err := s.decodeArgJSON(nodeJSON)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode node: %w", err)
+		}
+		nodes = append(nodes, node)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return nodes, nil
+}
+
+// Paths returns the paths from from to to along edges of pred that have at
+// most maxDepth edges. Each path lists its nodes, starting with from and
+// ending with to. Only simple paths are returned: no node occurs twice, so
+// cycles are never followed and there is no path from a node to itself.
+// The order of the result is unspecified.
+func (s *FactStoreDB) Paths(pred ast.PredicateSym, from, to ast.Constant, maxDepth int) ([][]ast.Constant, error) {
+	if err := s.checkEdgePredicate(pred); err != nil {
+		return nil, err
+	}
+	if maxDepth <= 0 {
+		return nil, fmt.Errorf("invalid maximum path depth %d", maxDepth)
+	}
+	fromJSON, err := s.argJSON(from)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal %v: %w", from, err)
+	}
+	toJSON, err := s.argJSON(to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal %v: %w", to, err)
+	}
+
+	d := s.dialect
+	src, dst := d.argJSONSQL(0), d.argJSONSQL(1)
+	q := s.newQuery()
+	// Placeholders are bound once per occurrence, as SQLite's are positional.
+	q.sql.WriteString("WITH RECURSIVE walk(node, path, depth) AS (SELECT ")
+	q.sql.WriteString(dst + ", " + d.pathAppendSQL(d.pathStartSQL(d.jsonTextParamSQL(q.bind(fromJSON))), dst) + ", 1")
+	q.sql.WriteString(" FROM facts WHERE predicate = ")
+	q.sql.WriteString(q.bind(predicateToKey(pred)))
+	q.sql.WriteString(" AND " + src + " = " + d.jsonTextParamSQL(q.bind(fromJSON)))
+	q.sql.WriteString(" AND " + dst + " <> " + d.jsonTextParamSQL(q.bind(fromJSON)))
+	q.sql.WriteString(" UNION ALL SELECT ")
+	q.sql.WriteString(dst + ", " + d.pathAppendSQL("walk.path", dst) + ", walk.depth + 1")
+	q.sql.WriteString(" FROM facts JOIN walk ON " + src + " = walk.node WHERE predicate = ")
+	q.sql.WriteString(q.bind(predicateToKey(pred)))
+	q.sql.WriteString(" AND walk.depth < " + strconv.Itoa(maxDepth))
+	q.sql.WriteString(" AND walk.node <> " + d.jsonTextParamSQL(q.bind(toJSON)))
+	q.sql.WriteString(" AND NOT " + d.pathContainsSQL("walk.path", dst))
+	q.sql.WriteString(") SELECT " + d.pathJSONSQL("path") + " FROM walk WHERE node = ")
+	q.sql.WriteString(d.jsonTextParamSQL(q.bind(toJSON)))
+
+	rows, err := s.query(q.sql.String(), q.params...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query paths: %w", err)
+	}
+	defer rows.Close()
+
+	var paths [][]ast.Constant
+	for rows.Next() {
+		var pathJSON string
+		if err := rows.Scan(&pathJSON); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		// The path is an array holding the JSON text of each node.
+		var nodeJSONs []string
+		if err := json.Unmarshal([]byte(pathJSON), &nodeJSONs); err != nil {
+			return nil, fmt.Errorf("failed to decode path: %w", err)
+		}
+		path := make([]ast.Constant, len(nodeJSONs))
+		for i, nodeJSON := range nodeJSONs {
+			if path[i], err = s.decodeArgJSON(nodeJSON); err != nil {
+				return nil, fmt.Errorf("failed to decode node: %w", err)
+			}
+		}
+		paths = append(paths, path)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return paths, nil
+}
+
+// TransitiveClosure returns the transitive closure of the edges of pred as
+// facts of pred: pred(A, B) is in the result if B is reachable from A, by at
+// most maxDepth edges unless maxDepth is 0. The facts are computed by the
+// database and not stored. The order of the result is unspecified.
+func (s *FactStoreDB) TransitiveClosure(pred ast.PredicateSym, maxDepth int) ([]ast.Atom, error) {
+	if err := s.checkEdgePredicate(pred); err != nil {
+		return nil, err
+	}
+	if err := checkMaxDepth(maxDepth); err != nil {
+		return nil, err
+	}
+
+	d := s.dialect
+	src, dst := d.argJSONSQL(0), d.argJSONSQL(1)
+	q := s.newQuery()
+	// UNION discards pairs that were already derived, which ends the recursion.
+	// With a maximum depth, pairs are derived once per depth up to it.
+	if maxDepth > 0 {
+		q.sql.WriteString("WITH RECURSIVE closure(src, dst, depth) AS (SELECT " + src + ", " + dst + ", 1")
+	} else {
+		q.sql.WriteString("WITH RECURSIVE closure(src, dst) AS (SELECT " + src + ", " + dst)
+	}
+	q.sql.WriteString(" FROM facts WHERE predicate = ")
+	q.sql.WriteString(q.bind(predicateToKey(pred)))
+	if maxDepth > 0 {
+		q.sql.WriteString(" UNION SELECT closure.src, " + dst + ", closure.depth + 1 FROM facts JOIN closure ON " + src + " = closure.dst WHERE predicate = ")
+		q.sql.WriteString(q.bind(predicateToKey(pred)))
+		q.sql.WriteString(" AND closure.depth < " + strconv.Itoa(maxDepth))
+		q.sql.WriteString(") SELECT DISTINCT src, dst FROM closure")
+	} else {
+		q.sql.WriteString(" UNION SELECT closure.src, " + dst + " FROM facts JOIN closure ON " + src + " = closure.dst WHERE predicate = ")
+		q.sql.WriteString(q.bind(predicateToKey(pred)))
+		q.sql.WriteString(") SELECT src, dst FROM closure")
+	}
+
+	rows, err := s.query(q.sql.String(), q.params...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query transitive closure: %w", err)
+	}
+	defer rows.Close()
+
+	var facts []ast.Atom
+	for rows.Next() {
+		var srcJSON, dstJSON string
+		if err := rows.Scan(&srcJSON, &dstJSON); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		a, err := s.decodeArgJSON(srcJSON)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode node: %w", err)
+		}
+		b, err := s.decodeArgJSON(dstJSON)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode node: %w", err)
+		}
+		facts = append(facts, ast.Atom{Predicate: pred, Args: []ast.BaseTerm{a, b}})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return facts, nil
+}
@@ -0,0 +1,155 @@
+package factstoredb
+
+import (
+	"fmt"
+	"testing"
+
+	"bitbucket.org/creachadair/stringset"
+	"github.com/google/mangle/ast"
+)
+
+// runGraphTest tests Reachable, Paths and TransitiveClosure on a graph with a cycle.
+func runGraphTest(t *testing.T, newStore func() (*FactStoreDB, error)) {
+	store, err := newStore()
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	// a -> b -> c -> d, a -> c, c -> a (cycle), e isolated with a self-loop.
+	edges := []string{
+		"edge(/a, /b)",
+		"edge(/b, /c)",
+		"edge(/c, /d)",
+		"edge(/a, /c)",
+		"edge(/c, /a)",
+		"edge(/e, /e)",
+		`edge("/a", 1)`,
+	}
+	for _, e := range edges {
+		store.Add(evalAtom(e))
+	}
+	store.Add(evalAtom("other(/d, /x)"))
+	edge := ast.PredicateSym{Symbol: "edge", Arity: 2}
+
+	constants := func(cs []ast.Constant) stringset.Set {
+		set := stringset.New()
+		for _, c := range cs {
+			set.Add(c.String())
+		}
+		return set
+	}
+
+	t.Run("Reachable", func(t *testing.T) {
+		tests := []struct {
+			from     ast.Constant
+			maxDepth int
+			want     stringset.Set
+		}{
+			{from: name("/a"), want: stringset.New("/a", "/b", "/c", "/d")},
+			{from: name("/d"), want: stringset.New()},
+			{from: name("/e"), want: stringset.New("/e")},
+			{from: ast.String("/a"), want: stringset.New("1")},
+			{from: name("/a"), maxDepth: 1, want: stringset.New("/b", "/c")},
+			{from: name("/b"), maxDepth: 1, want: stringset.New("/c")},
+			{from: name("/b"), maxDepth: 2, want: stringset.New("/c", "/d", "/a")},
+			{from: name("/e"), maxDepth: 3, want: stringset.New("/e")},
+		}
+		for _, tt := range tests {
+			got, err := store.Reachable(edge, tt.from, tt.maxDepth)
+			if err != nil {
+				t.Fatalf("Reachable(%v, %d) error = %v", tt.from, tt.maxDepth, err)
+			}
+			if !constants(got).Equals(tt.want) {
+				t.Errorf("Reachable(%v, %d) = %v, want %v", tt.from, tt.maxDepth, got, tt.want)
+			}
+		}
+		if _, err := store.Reachable(edge, name("/a"), -1); err == nil {
+			t.Error("Reachable() with maxDepth -1 succeeded, want error")
+		}
+	})
+
+	t.Run("Paths", func(t *testing.T) {
+		format := func(paths [][]ast.Constant) stringset.Set {
+			set := stringset.New()
+			for _, p := range paths {
+				set.Add(fmt.Sprint(p))
+			}
+			return set
+		}
+		tests := []struct {
+			from, to ast.Constant
+			maxDepth int
+			want     stringset.Set
+		}{
+			{from: name("/a"), to: name("/d"), maxDepth: 5, want: stringset.New("[/a /b /c /d]", "[/a /c /d]")},
+			{from: name("/a"), to: name("/d"), maxDepth: 2, want: stringset.New("[/a /c /d]")},
+			{from: name("/b"), to: name("/a"), maxDepth: 5, want: stringset.New("[/b /c /a]")},
+			{from: name("/a"), to: name("/a"), maxDepth: 5, want: stringset.New()},
+			{from: name("/d"), to: name("/a"), maxDepth: 5, want: stringset.New()},
+		}
+		for _, tt := range tests {
+			got, err := store.Paths(edge, tt.from, tt.to, tt.maxDepth)
+			if err != nil {
+				t.Fatalf("Paths(%v, %v, %d) error = %v", tt.from, tt.to, tt.maxDepth, err)
+			}
+			if !format(got).Equals(tt.want) {
+				t.Errorf("Paths(%v, %v, %d) = %v, want %v", tt.from, tt.to, tt.maxDepth, got, tt.want)
+			}
+		}
+		if _, err := store.Paths(edge, name("/a"), name("/d"), 0); err == nil {
+			t.Error("Paths() with maxDepth 0 succeeded, want error")
+		}
+	})
+
+	facts := func(atoms []ast.Atom) stringset.Set {
+		set := stringset.New()
+		for _, a := range atoms {
+			set.Add(a.String())
+		}
+		return set
+	}
+
+	t.Run("TransitiveClosure", func(t *testing.T) {
+		got, err := store.TransitiveClosure(edge, 0)
+		if err != nil {
+			t.Fatalf("TransitiveClosure() error = %v", err)
+		}
+		gotSet := facts(got)
+		want := stringset.New()
+		for _, from := range []string{"/a", "/b", "/c"} {
+			for _, to := range []string{"/a", "/b", "/c", "/d"} {
+				want.Add(evalAtom(fmt.Sprintf("edge(%s, %s)", from, to)).String())
+			}
+		}
+		want.Add(evalAtom("edge(/e, /e)").String())
+		want.Add(evalAtom(`edge("/a", 1)`).String())
+		if !gotSet.Equals(want) {
+			t.Errorf("TransitiveClosure() = %v, want %v", gotSet, want)
+		}
+
+		// One edge derives the edges themselves; two add the pairs two edges apart.
+		want = stringset.New()
+		for _, e := range edges {
+			want.Add(evalAtom(e).String())
+		}
+		if got, err := store.TransitiveClosure(edge, 1); err != nil || !facts(got).Equals(want) {
+			t.Errorf("TransitiveClosure(1) = %v, %v, want %v", got, err, want)
+		}
+		for _, e := range []string{"edge(/a, /d)", "edge(/a, /a)", "edge(/b, /d)", "edge(/b, /a)", "edge(/c, /b)", "edge(/c, /c)"} {
+			want.Add(evalAtom(e).String())
+		}
+		if got, err := store.TransitiveClosure(edge, 2); err != nil || !facts(got).Equals(want) {
+			t.Errorf("TransitiveClosure(2) = %v, %v, want %v", got, err, want)
+		}
+		if _, err := store.TransitiveClosure(edge, -1); err == nil {
+			t.Error("TransitiveClosure() with maxDepth -1 succeeded, want error")
+		}
+	})
+
+	t.Run("arity", func(t *testing.T) {
+		if _, err := store.Reachable(ast.PredicateSym{Symbol: "node", Arity: 1}, name("/a"), 0); err == nil {
+			t.Error("Reachable() over unary predicate succeeded, want error")
+		}
+	})
+}
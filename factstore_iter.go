@@ -0,0 +1,169 @@
+package factstoredb
+
+import (
+	"errors"
+	"fmt"
+	"iter"
+	"log"
+	"time"
+
+	"github.com/google/mangle/ast"
+)
+
+// errStopIteration is returned from a GetFacts callback when the consumer
+// of an iterator stops early. It never escapes to callers.
+var errStopIteration = errors.New("iteration stopped")
+
+// Prefetch makes iterators read facts in batches of n instead of streaming
+// them from an open SQL cursor. Each batch is fetched with a keyset query and
+// the connection is released before the loop body runs, so a slow loop does
+// not hold a connection. n must be positive.
+func Prefetch(n int) QueryOption {
+	return func(c *queryConfig) {
+		c.prefetch = n
+	}
+}
+
+// Facts returns an iterator over the facts that match pattern and satisfy the
+// given options. The iteration stops at the first error, which is yielded
+// together with a zero atom. Breaking out of the loop closes the underlying rows.
+//
+//	for fact, err := range store.Facts(pattern) {
+//		if err != nil {
+//			return err
+//		}
+//		fmt.Println(fact)
+//	}
+func (s *FactStoreDB) Facts(pattern ast.Atom, opts ...QueryOption) iter.Seq2[ast.Atom, error] {
+	return func(yield func(ast.Atom, error) bool) {
+		cfg, err := newQueryConfig(pattern, opts)
+		if err != nil {
+			yield(ast.Atom{}, err)
+			return
+		}
+		if cfg.prefetch > 0 {
+			s.prefetchFacts(pattern, cfg, yield)
+			return
+		}
+		_, err = s.getFacts(pattern, cfg, func(fact ast.Atom) error {
+			if !yield(fact, nil) {
+				return errStopIteration
+			}
+			return nil
+		})
+		if err != nil && !errors.Is(err, errStopIteration) {
+			yield(ast.Atom{}, err)
+		}
+	}
+}
+
+// prefetchFacts yields the facts matching pattern one batch at a time.
+// Batches are read as pages in the configured order, so a Limit still caps
+// the total number of facts.
+func (s *FactStoreDB) prefetchFacts(pattern ast.Atom, cfg *queryConfig, yield func(ast.Atom, error) bool) {
+	page := *cfg
+	page.wantCursor = true
+	remaining := cfg.limit
+
+	// Each batch is profiled separately and added to the caller's profile,
+	// with the loop body counted as callback time.
+	var batchProfile QueryProfile
+	if cfg.profile != nil {
+		*cfg.profile = QueryProfile{}
+		page.profile = &batchProfile
+	}
+	for {
+		page.limit = cfg.prefetch
+		if cfg.limit > 0 {
+			page.limit = min(page.limit, remaining)
+		}
+		var batch []ast.Atom
+		next, err := s.getFacts(pattern, &page, func(fact ast.Atom) error {
+			batch = append(batch, fact)
+			return nil
+		})
+		if err != nil {
+			yield(ast.Atom{}, err)
+			return
+		}
+		var start time.Time
+		if cfg.profile != nil {
+			cfg.profile.Rows += batchProfile.Rows
+			cfg.profile.QueryTime += batchProfile.QueryTime
+			cfg.profile.DecodeTime += batchProfile.DecodeTime
+			start = time.Now()
+		}
+		for _, fact := range batch {
+			if !yield(fact, nil) {
+				return
+			}
+		}
+		if cfg.profile != nil {
+			cfg.profile.CallbackTime += time.Since(start)
+		}
+		remaining -= len(batch)
+		if next == "" || (cfg.limit > 0 && remaining <= 0) {
+			return
+		}
+		page.after = next
+	}
+}
+
+// All returns an iterator over all facts in the store, grouped by predicate.
+// Options apply to each predicate separately, so only options that do not
+// depend on the arity, such as Prefetch, are meaningful.
+func (s *FactStoreDB) All(opts ...QueryOption) iter.Seq2[ast.Atom, error] {
+	return func(yield func(ast.Atom, error) bool) {
+		// Predicates are listed up front so that no cursor is held open
+		// while the facts of each predicate are read.
+		for _, pred := range s.ListPredicates() {
+			for fact, err := range s.Facts(ast.NewQuery(pred), opts...) {
+				if err != nil {
+					yield(ast.Atom{}, fmt.Errorf("failed to get facts for %v: %w", pred, err))
+					return
+				}
+				if !yield(fact, nil) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// Predicates returns an iterator over the predicates present in the store.
+// Rows that cannot be read are logged and skipped.
+func (s *FactStoreDB) Predicates() iter.Seq[ast.PredicateSym] {
+	return func(yield func(ast.PredicateSym) bool) {
+		rows, err := s.db.Query(`SELECT DISTINCT predicate FROM facts`)
+		if err != nil {
+			log.Printf("DBFactStore failed to query for predicates: %v", err)
+			return
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var predicateKey string
+			if err := rows.Scan(&predicateKey); err != nil {
+				log.Printf("DBFactStore failed to scan predicate row: %v", err)
+				continue
+			}
+			// Parse "symbol_arity" format (e.g., "person_2" or "my_predicate_3")
+			pred, err := keyToPredicate(predicateKey)
+			if err != nil {
+				log.Printf("DBFactStore %v", err)
+				continue
+			}
+			if !yield(pred) {
+				return
+			}
+		}
+		if err := rows.Err(); err != nil {
+			log.Printf("DBFactStore error iterating predicate rows: %v", err)
+		}
+		for _, pred := range s.typedPredicates() {
+			if !yield(pred) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package factstoredb
+
+import (
+	"fmt"
+	"testing"
+
+	"bitbucket.org/creachadair/stringset"
+	"github.com/google/mangle/ast"
+)
+
+// runIteratorTest tests Facts, All and Predicates, including early exit.
+func runIteratorTest(t *testing.T, newStore func() (*FactStoreDB, error)) {
+	store, err := newStore()
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	const n = 10
+	want := stringset.New()
+	for i := range n {
+		f := evalAtom(fmt.Sprintf("num(%d)", i))
+		store.Add(f)
+		want.Add(f.String())
+	}
+	store.Add(evalAtom("edge(/a, /b)"))
+
+	collect := func(t *testing.T, seq func(func(ast.Atom, error) bool)) stringset.Set {
+		t.Helper()
+		got := stringset.New()
+		for fact, err := range seq {
+			if err != nil {
+				t.Fatalf("iteration error = %v", err)
+			}
+			got.Add(fact.String())
+		}
+		return got
+	}
+
+	t.Run("Facts", func(t *testing.T) {
+		if got := collect(t, store.Facts(atom("num(X)"))); !got.Equals(want) {
+			t.Errorf("Facts() = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("Facts with options", func(t *testing.T) {
+		var got []string
+		for fact, err := range store.Facts(atom("num(X)"), Where(Ge(0, ast.Number(7))), OrderBy(Desc(0))) {
+			if err != nil {
+				t.Fatalf("Facts() error = %v", err)
+			}
+			got = append(got, fact.String())
+		}
+		if fmt.Sprint(got) != "[num(9) num(8) num(7)]" {
+			t.Errorf("Facts() = %v, want [num(9) num(8) num(7)]", got)
+		}
+	})
+
+	t.Run("break", func(t *testing.T) {
+		count := 0
+		for _, err := range store.Facts(atom("num(X)")) {
+			if err != nil {
+				t.Fatalf("Facts() error = %v", err)
+			}
+			count++
+			if count == 3 {
+				break
+			}
+		}
+		if count != 3 {
+			t.Errorf("iterated %d facts, want 3", count)
+		}
+		if inUse := store.db.Stats().InUse; inUse != 0 {
+			t.Errorf("%d connections in use after break, want 0", inUse)
+		}
+	})
+
+	t.Run("Prefetch", func(t *testing.T) {
+		got := stringset.New()
+		for fact, err := range store.Facts(atom("num(X)"), Prefetch(3)) {
+			if err != nil {
+				t.Fatalf("Facts() error = %v", err)
+			}
+			if inUse := store.db.Stats().InUse; inUse != 0 {
+				t.Errorf("%d connections in use in loop body, want 0", inUse)
+			}
+			got.Add(fact.String())
+		}
+		if !got.Equals(want) {
+			t.Errorf("Facts(Prefetch) = %v, want %v", got, want)
+		}
+
+		var limited []string
+		for fact, err := range store.Facts(atom("num(X)"), Prefetch(3), OrderBy(Asc(0)), Limit(5)) {
+			if err != nil {
+				t.Fatalf("Facts() error = %v", err)
+			}
+			limited = append(limited, fact.String())
+		}
+		if fmt.Sprint(limited) != "[num(0) num(1) num(2) num(3) num(4)]" {
+			t.Errorf("Facts(Prefetch, Limit) = %v", limited)
+		}
+	})
+
+	t.Run("error", func(t *testing.T) {
+		var errs int
+		for _, err := range store.Facts(atom("num(X)"), Limit(-1)) {
+			if err == nil {
+				t.Fatal("Facts() with invalid option yielded a fact, want error")
+			}
+			errs++
+		}
+		if errs != 1 {
+			t.Errorf("Facts() with invalid option yielded %d errors, want 1", errs)
+		}
+	})
+
+	t.Run("All", func(t *testing.T) {
+		wantAll := stringset.New(want.Elements()...)
+		wantAll.Add(evalAtom("edge(/a, /b)").String())
+		if got := collect(t, store.All()); !got.Equals(wantAll) {
+			t.Errorf("All() = %v, want %v", got, wantAll)
+		}
+		if got := collect(t, store.All(Prefetch(4))); !got.Equals(wantAll) {
+			t.Errorf("All(Prefetch) = %v, want %v", got, wantAll)
+		}
+	})
+
+	t.Run("Predicates", func(t *testing.T) {
+		got := stringset.New()
+		for pred := range store.Predicates() {
+			got.Add(pred.Symbol)
+		}
+		if wantPreds := stringset.New("num", "edge"); !got.Equals(wantPreds) {
+			t.Errorf("Predicates() = %v, want %v", got, wantPreds)
+		}
+		for range store.Predicates() {
+			break
+		}
+		if inUse := store.db.Stats().InUse; inUse != 0 {
+			t.Errorf("%d connections in use after break, want 0", inUse)
+		}
+	})
+}
+
+func TestIteratorTypedPredicate(t *testing.T) {
+	reading := ast.PredicateSym{Symbol: "reading", Arity: 2}
+	store, err := NewFactStoreSQLite(":memory:", WithTypedPredicate(reading, ColumnName, ColumnNumber))
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer store.Close()
+	want := stringset.New()
+	for i := range 10 {
+		f := evalAtom(fmt.Sprintf("reading(/s%d, %d)", i, i))
+		store.Add(f)
+		want.Add(f.String())
+	}
+	store.Add(evalAtom("edge(/a, /b)"))
+
+	// Batches of typed predicates are keyset pages in atom_hash order.
+	got := stringset.New()
+	for fact, err := range store.Facts(atom("reading(X, Y)"), Prefetch(3)) {
+		if err != nil {
+			t.Fatalf("Facts(Prefetch) error = %v", err)
+		}
+		if inUse := store.db.Stats().InUse; inUse != 0 {
+			t.Errorf("%d connections in use in loop body, want 0", inUse)
+		}
+		got.Add(fact.String())
+	}
+	if !got.Equals(want) {
+		t.Errorf("Facts(Prefetch) = %v, want %v", got, want)
+	}
+	count := 0
+	for _, err := range store.Facts(atom("reading(X, Y)"), Prefetch(3), Limit(5)) {
+		if err != nil {
+			t.Fatalf("Facts(Prefetch, Limit) error = %v", err)
+		}
+		count++
+	}
+	if count != 5 {
+		t.Errorf("Facts(Prefetch, Limit(5)) yielded %d facts, want 5", count)
+	}
+
+	got = stringset.New()
+	for fact, err := range store.All(Prefetch(4)) {
+		if err != nil {
+			t.Fatalf("All(Prefetch) error = %v", err)
+		}
+		got.Add(fact.String())
+	}
+	if got.Len() != want.Len()+1 || !got.Contains(want.Elements()...) {
+		t.Errorf("All(Prefetch) = %v, want %v and edge(/a,/b)", got, want)
+	}
+
+	for _, err := range store.Facts(atom("reading(X, Y)"), Prefetch(3), OrderBy(Asc(1))) {
+		if err == nil {
+			t.Fatal("Facts(Prefetch, OrderBy) of a typed predicate yielded a fact, want error")
+		}
+	}
+}
@@ -9,7 +9,18 @@ import (
 
 // NewFactStorePostgreSQL creates a new PostgreSQL-backed FactStore.
 // It accepts a standard PostgreSQL connection string.
-func NewFactStorePostgreSQL(connStr string) (*FactStoreDB, error) {
+// Optional StoreOption functions can be provided, such as WithStatementCacheSize.
+func NewFactStorePostgreSQL(connStr string, opts ...StoreOption) (*FactStoreDB, error) {
+	// PostgreSQL does not use PRAGMAs, so WithPragma options are ignored.
+	cfg := defaultConfig()
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	if _, err := cfg.profile(); err != nil {
+		return nil, err
+	}
+
+	connStr = cfg.withSynchronousCommit(connStr)
 	db, err := sql.Open("postgres", connStr)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open PostgreSQL: %w", err)
@@ -19,16 +30,15 @@ func NewFactStorePostgreSQL(connStr string) (*FactStoreDB, error) {
 	db.SetMaxOpenConns(10)
 	db.SetMaxIdleConns(4)
 
-	// PostgreSQL does not use PRAGMAs, so options are ignored for now,
-	// but the signature is kept for consistency.
-
 	store := &FactStoreDB{
 		db:      db,
 		ownsDB:  true,
 		dialect: postgresDialect{},
+		stmts:   newStmtCache(db, cfg.statementCacheSize(defaultStmtCacheSize)),
+		connStr: connStr,
 	}
 
-	if err := store.initSchemaAndStatements(); err != nil {
+	if err := store.initSchemaAndStatements(cfg); err != nil {
 		db.Close()
 		return nil, fmt.Errorf("failed to initialize schema for PostgreSQL: %w", err)
 	}
@@ -38,19 +48,23 @@ func NewFactStorePostgreSQL(connStr string) (*FactStoreDB, error) {
 
 // NewFactStorePostgreSQLFromDB creates a new PostgreSQL-backed FactStore from an existing database connection.
 // The caller retains ownership of the db connection and must close it separately.
-// The opts parameter is accepted for API consistency but currently ignored (PostgreSQL does not use PRAGMAs).
+// Optional StoreOption functions can be provided, such as WithStatementCacheSize; WithPragma options are ignored.
 // Note: This constructor does not configure connection pooling settings (use NewFactStorePostgreSQL for that).
-func NewFactStorePostgreSQLFromDB(db *sql.DB) (*FactStoreDB, error) {
-	// PostgreSQL does not use PRAGMAs, so options are ignored for now,
-	// but the signature is kept for consistency.
+func NewFactStorePostgreSQLFromDB(db *sql.DB, opts ...StoreOption) (*FactStoreDB, error) {
+	// PostgreSQL does not use PRAGMAs, so WithPragma options are ignored.
+	cfg := defaultConfig()
+	for _, opt := range opts {
+		opt(cfg)
+	}
 
 	store := &FactStoreDB{
 		db:      db,
 		ownsDB:  false,
 		dialect: postgresDialect{},
+		stmts:   newStmtCache(db, cfg.statementCacheSize(defaultStmtCacheSize)),
 	}
 
-	if err := store.initSchemaAndStatements(); err != nil {
+	if err := store.initSchemaAndStatements(cfg); err != nil {
 		return nil, fmt.Errorf("failed to initialize schema for PostgreSQL: %w", err)
 	}
 
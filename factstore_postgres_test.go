@@ -41,8 +41,57 @@ func TestPostgresFactStore(t *testing.T) {
 		return store, nil
 	}
 
+	// Factory for tests that need the concrete FactStoreDB type.
+	newPostgresDBStore := func() (*FactStoreDB, error) {
+		store, err := newPostgresStore()
+		if err != nil {
+			return nil, err
+		}
+		return store.(*FactStoreDB), nil
+	}
+
 	// Run the shared test suite.
 	runSuite(t, newPostgresStore)
+
+	t.Run("GetBindings", func(t *testing.T) {
+		runGetBindingsTest(t, newPostgresDBStore)
+	})
+
+	t.Run("Constraints", func(t *testing.T) {
+		runConstraintsTest(t, newPostgresDBStore)
+	})
+
+	t.Run("StructuredConstraints", func(t *testing.T) {
+		runStructuredConstraintsTest(t, newPostgresDBStore)
+	})
+
+	t.Run("Aggregate", func(t *testing.T) {
+		runAggregateTest(t, newPostgresDBStore)
+	})
+
+	t.Run("Ordering", func(t *testing.T) {
+		runOrderingTest(t, newPostgresDBStore)
+	})
+
+	t.Run("Pagination", func(t *testing.T) {
+		runPaginationTest(t, newPostgresDBStore)
+	})
+
+	t.Run("Iterators", func(t *testing.T) {
+		runIteratorTest(t, newPostgresDBStore)
+	})
+
+	t.Run("Graph", func(t *testing.T) {
+		runGraphTest(t, newPostgresDBStore)
+	})
+
+	t.Run("Negation", func(t *testing.T) {
+		runNegationTest(t, newPostgresDBStore)
+	})
+
+	t.Run("Explain", func(t *testing.T) {
+		runExplainTest(t, newPostgresDBStore)
+	})
 }
 
 // TestNewFactStorePostgreSQLFromDB tests the FromDB constructor
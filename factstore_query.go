@@ -0,0 +1,252 @@
+package factstoredb
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/google/mangle/ast"
+)
+
+// queryConfig holds the options of a single query.
+type queryConfig struct {
+	constraints []Constraint
+	notExists   []ast.Atom
+	orderBy     []OrderKey
+	limit       int
+	after       string
+	// wantCursor selects the sort keys so that a cursor for the next page can be built.
+	wantCursor bool
+	// prefetch is the batch size of iterators, or 0 to stream from the cursor.
+	prefetch int
+	profile  *QueryProfile
+}
+
+// QueryOption is a function that configures a query issued through Query, GetBindings or Facts.
+type QueryOption func(*queryConfig)
+
+// Where restricts a query to facts whose arguments satisfy all given constraints.
+// For example, Where(Ge(1, ast.Number(18))) keeps facts whose second argument is at least 18.
+func Where(constraints ...Constraint) QueryOption {
+	return func(c *queryConfig) {
+		c.constraints = append(c.constraints, constraints...)
+	}
+}
+
+// newQueryConfig applies opts and validates the result against pattern.
+func newQueryConfig(pattern ast.Atom, opts []QueryOption) (*queryConfig, error) {
+	cfg := &queryConfig{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	for _, c := range cfg.constraints {
+		if err := c.validate(len(pattern.Args)); err != nil {
+			return nil, err
+		}
+	}
+	if err := cfg.validateOrdering(len(pattern.Args)); err != nil {
+		return nil, err
+	}
+	if err := cfg.validateNegations(); err != nil {
+		return nil, err
+	}
+	if cfg.prefetch < 0 {
+		return nil, fmt.Errorf("invalid prefetch size %d", cfg.prefetch)
+	}
+	return cfg, nil
+}
+
+// plain reports whether the configuration adds nothing to the pattern itself.
+func (c *queryConfig) plain() bool {
+	return len(c.constraints) == 0 && len(c.notExists) == 0 && !c.ordered() && !c.wantCursor
+}
+
+// Query streams the facts that match pattern and satisfy the given options.
+// Without options it behaves exactly like GetFacts.
+func (s *FactStoreDB) Query(pattern ast.Atom, callback func(ast.Atom) error, opts ...QueryOption) error {
+	cfg, err := newQueryConfig(pattern, opts)
+	if err != nil {
+		return err
+	}
+	_, err = s.getFacts(pattern, cfg, callback)
+	return err
+}
+
+// Exists reports whether any fact matches pattern and satisfies the given
+// options, that is, whether GetBindings would yield at least one binding:
+// repeated variables must bind to equal values. The database stops after the
+// first matching row.
+func (s *FactStoreDB) Exists(pattern ast.Atom, opts ...QueryOption) (bool, error) {
+	cfg, err := newQueryConfig(pattern, opts)
+	if err != nil {
+		return false, err
+	}
+	if cfg.ordered() {
+		return false, errors.New("ordering and paging options are not supported by Exists")
+	}
+	if s.codec.keepsFloats() || s.typed[pattern.Predicate] != nil {
+		// Stop at the first fact that binds the pattern.
+		found := false
+		vars, positions := patternVariables(pattern)
+		err := s.getBindingsFromFacts(pattern, cfg, vars, positions, func(ast.ConstSubstMap) error {
+			found = true
+			return errStopIteration
+		})
+		if err != nil && !errors.Is(err, errStopIteration) {
+			return false, err
+		}
+		return found, nil
+	}
+
+	q := s.newQuery()
+	q.sql.WriteString("SELECT 1 FROM facts WHERE predicate = ")
+	q.sql.WriteString(q.bind(predicateToKey(pattern.Predicate)))
+	if err := q.writePatternFilters(pattern); err != nil {
+		return false, err
+	}
+	q.writeRepeatedVariableFilters(pattern)
+	if err := q.writeConstraints(cfg.constraints); err != nil {
+		return false, err
+	}
+	if err := q.writeNotExists(pattern, cfg.notExists); err != nil {
+		return false, err
+	}
+	q.sql.WriteString(" LIMIT 1")
+
+	rows, err := s.query(q.sql.String(), q.params...)
+	if err != nil {
+		return false, fmt.Errorf("failed to query existence: %w", err)
+	}
+	defer rows.Close()
+	found := rows.Next()
+	return found, rows.Err()
+}
+
+// GetBindings streams the variable bindings of every fact that matches pattern.
+// Instead of decoding whole atoms, only the argument positions bound to named
+// variables are selected from the database, which avoids decoding the full
+// args of wide predicates. Constant arguments filter the facts as in GetFacts,
+// repeated variables must bind to equal values, and the wildcard "_" is never bound.
+// If the pattern has no named variables, callback receives an empty map for each match.
+// Options such as Where, NotExists, OrderBy and Limit apply as in Query.
+func (s *FactStoreDB) GetBindings(pattern ast.Atom, callback func(ast.ConstSubstMap) error, opts ...QueryOption) error {
+	cfg, err := newQueryConfig(pattern, opts)
+	if err != nil {
+		return err
+	}
+	vars, positions := patternVariables(pattern)
+	if s.codec.keepsFloats() || s.typed[pattern.Predicate] != nil {
+		// Bindings are decoded from facts to keep the types of the codec.
+		return s.getBindingsFromFacts(pattern, cfg, vars, positions, callback)
+	}
+
+	q := s.newQuery()
+	q.sql.WriteString("SELECT ")
+	if len(positions) == 0 {
+		q.sql.WriteString("1")
+	}
+	for i, pos := range positions {
+		if i > 0 {
+			q.sql.WriteString(", ")
+		}
+		q.sql.WriteString(s.dialect.argJSONSQL(pos))
+	}
+	q.sql.WriteString(" FROM facts WHERE predicate = ")
+	q.sql.WriteString(q.bind(predicateToKey(pattern.Predicate)))
+	if err := q.writePatternFilters(pattern); err != nil {
+		return err
+	}
+	q.writeRepeatedVariableFilters(pattern)
+	if err := q.writeConstraints(cfg.constraints); err != nil {
+		return err
+	}
+	if err := q.writeNotExists(pattern, cfg.notExists); err != nil {
+		return err
+	}
+	if cfg.ordered() {
+		if err := q.writeOrdering(pattern, cfg); err != nil {
+			return err
+		}
+	}
+
+	rows, err := s.query(q.sql.String(), q.params...)
+	if err != nil {
+		return fmt.Errorf("failed to query bindings: %w", err)
+	}
+	defer rows.Close()
+
+	// Reuse the scan destinations across rows; only the decoded constants escape.
+	values := make([]string, len(positions))
+	dest := make([]any, len(positions))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	if len(dest) == 0 {
+		var one int
+		dest = append(dest, &one)
+	}
+
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return fmt.Errorf("failed to scan row: %w", err)
+		}
+
+		subst := make(ast.ConstSubstMap, len(vars))
+		for i, v := range vars {
+			c, err := s.decodeArgJSON(values[i])
+			if err != nil {
+				return fmt.Errorf("failed to decode binding for %v: %w", v, err)
+			}
+			subst[v] = c
+		}
+
+		if err := callback(subst); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// getBindingsFromFacts implements GetBindings by decoding whole facts, for
+// codecs without SQL filters and typed predicates. Repeated variables are checked in Go.
+func (s *FactStoreDB) getBindingsFromFacts(pattern ast.Atom, cfg *queryConfig, vars []ast.Variable, positions []int, callback func(ast.ConstSubstMap) error) error {
+	_, err := s.getFacts(pattern, cfg, func(fact ast.Atom) error {
+		subst := make(ast.ConstSubstMap, len(vars))
+		for i, v := range vars {
+			subst[v] = fact.Args[positions[i]].(ast.Constant)
+		}
+		for i, arg := range pattern.Args {
+			if v, ok := arg.(ast.Variable); ok && v.Symbol != "_" && !subst[v].Equals(fact.Args[i]) {
+				return nil
+			}
+		}
+		return callback(subst)
+	})
+	return err
+}
+
+// CreateArgIndex creates an index over the argument at position index for
+// every predicate, including the column at that position of typed predicates.
+// It speeds up equality, range and name-prefix filters on that position, at
+// the cost of extra storage and slower writes. It is safe to call repeatedly.
+func (s *FactStoreDB) CreateArgIndex(index int) error {
+	if index < 0 {
+		return fmt.Errorf("invalid argument index %d", index)
+	}
+	for _, stmt := range s.dialect.createArgIndexSQL(index) {
+		if _, err := s.db.Exec(stmt); err != nil {
+			return fmt.Errorf("failed to create argument index: %w", err)
+		}
+	}
+	for _, t := range s.typed {
+		if index >= len(t.columns) {
+			continue
+		}
+		column := typedColumn(index)
+		indexName := quoteIdent("idx_facts_" + predicateToKey(t.pred) + "_" + column)
+		if _, err := s.db.Exec("CREATE INDEX IF NOT EXISTS " + indexName + " ON " + t.name + "(" + column + ")"); err != nil {
+			return fmt.Errorf("failed to create argument index of typed predicate %v: %w", t.pred, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,393 @@
+package factstoredb
+
+import (
+	"testing"
+
+	"bitbucket.org/creachadair/stringset"
+	"github.com/google/mangle/ast"
+)
+
+// runGetBindingsTest tests that GetBindings returns only variable bindings.
+func runGetBindingsTest(t *testing.T, newStore func() (*FactStoreDB, error)) {
+	store, err := newStore()
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	facts := []ast.Atom{
+		atom("edge(/a, /b)"),
+		atom("edge(/a, /c)"),
+		atom("edge(/b, /b)"),
+		evalAtom(`info(/a, "hello", [1, 2], 3.5)`),
+		evalAtom(`info(/b, "[1, 2]", 7, {/k : /v})`),
+	}
+	for _, f := range facts {
+		store.Add(f)
+	}
+
+	tests := []struct {
+		pattern string
+		want    stringset.Set
+	}{
+		{
+			pattern: "edge(/a, Y)",
+			want:    stringset.New("Y=/b", "Y=/c"),
+		},
+		{
+			pattern: "edge(X, Y)",
+			want:    stringset.New("X=/a Y=/b", "X=/a Y=/c", "X=/b Y=/b"),
+		},
+		{
+			// Repeated variables must bind to the same value.
+			pattern: "edge(X, X)",
+			want:    stringset.New("X=/b"),
+		},
+		{
+			pattern: "edge(_, Y)",
+			want:    stringset.New("Y=/b", "Y=/c"),
+		},
+		{
+			// No variables: one empty binding per matching fact.
+			pattern: "edge(/a, /b)",
+			want:    stringset.New(""),
+		},
+		{
+			pattern: "info(/a, S, L, F)",
+			want:    stringset.New(`S="hello" L=[1, 2] F=3.5`),
+		},
+		{
+			// A string that looks like a list must stay a string.
+			pattern: "info(/b, S, N, M)",
+			want:    stringset.New(`S="[1, 2]" N=7 M={/k : /v}`),
+		},
+		{
+			pattern: "missing(X)",
+			want:    stringset.New(),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			pattern := atom(tt.pattern)
+			vars, _ := patternVariables(pattern)
+			got := stringset.New()
+			err := store.GetBindings(pattern, func(subst ast.ConstSubstMap) error {
+				if len(subst) != len(vars) {
+					t.Errorf("GetBindings(%q) bound %d variables, want %d", tt.pattern, len(subst), len(vars))
+				}
+				got.Add(formatBindings(vars, subst))
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("GetBindings(%q) error: %v", tt.pattern, err)
+			}
+			if !got.Equals(tt.want) {
+				t.Errorf("GetBindings(%q) = %v want %v", tt.pattern, got, tt.want)
+			}
+		})
+	}
+}
+
+// formatBindings renders a substitution as "X=a Y=b" in variable order.
+func formatBindings(vars []ast.Variable, subst ast.ConstSubstMap) string {
+	var s string
+	for i, v := range vars {
+		if i > 0 {
+			s += " "
+		}
+		s += v.Symbol + "=" + subst[v].String()
+	}
+	return s
+}
+
+// runConstraintsTest tests typed comparison, IN and prefix constraints.
+func runConstraintsTest(t *testing.T, newStore func() (*FactStoreDB, error)) {
+	store, err := newStore()
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	facts := []ast.Atom{
+		evalAtom("age(/alice, 30)"),
+		evalAtom("age(/bob, 9)"),
+		evalAtom("age(/carol, 18)"),
+		evalAtom("age(/dan, 17.5)"),
+		evalAtom("age(/erin, -4)"),
+		evalAtom(`age(/frank, "20")`),
+		evalAtom("age(/gina, /unknown)"),
+		evalAtom(`word(/w1, "apple")`),
+		evalAtom(`word(/w2, "banana")`),
+		evalAtom(`word(/w3, "Cherry")`),
+		evalAtom("word(/w4, /banana)"),
+		evalAtom(`word(/w5, b"bytes")`),
+		evalAtom(`word(/w6, "/banana")`),
+		evalAtom("member(/org/team/alice)"),
+		evalAtom("member(/org/team/bob)"),
+		evalAtom("member(/org/other/carl)"),
+		evalAtom("member(/org/teammate)"),
+	}
+	for _, f := range facts {
+		store.Add(f)
+	}
+
+	tests := []struct {
+		name        string
+		pattern     string
+		constraints []Constraint
+		want        stringset.Set
+	}{
+		{
+			name:        "number ge",
+			pattern:     "age(X, A)",
+			constraints: []Constraint{Ge(1, ast.Number(18))},
+			want:        stringset.New("age(/alice,30)", "age(/carol,18)"),
+		},
+		{
+			// Numeric, not lexicographic: 9 < 10 even though "9" > "10".
+			name:        "number lt",
+			pattern:     "age(X, A)",
+			constraints: []Constraint{Lt(1, ast.Number(10))},
+			want:        stringset.New("age(/bob,9)", "age(/erin,-4)"),
+		},
+		{
+			name:        "float range",
+			pattern:     "age(X, A)",
+			constraints: []Constraint{Gt(1, ast.Float64(10.5)), Le(1, ast.Float64(18))},
+			want:        stringset.New("age(/carol,18)", "age(/dan,17.5)"),
+		},
+		{
+			name:        "string gt",
+			pattern:     "word(X, W)",
+			constraints: []Constraint{Gt(1, ast.String("apple"))},
+			want:        stringset.New(`word(/w2,"banana")`),
+		},
+		{
+			// Byte order: uppercase sorts before lowercase.
+			name:        "string lt",
+			pattern:     "word(X, W)",
+			constraints: []Constraint{Lt(1, ast.String("b"))},
+			want:        stringset.New(`word(/w1,"apple")`, `word(/w3,"Cherry")`, `word(/w6,"/banana")`),
+		},
+		{
+			name:        "name ge",
+			pattern:     "word(X, W)",
+			constraints: []Constraint{Ge(1, name("/a"))},
+			want:        stringset.New("word(/w4,/banana)"),
+		},
+		{
+			name:        "ne",
+			pattern:     "age(X, A)",
+			constraints: []Constraint{Ne(1, ast.Number(30)), Ge(1, ast.Number(0))},
+			want:        stringset.New("age(/bob,9)", "age(/carol,18)", "age(/dan,17.5)"),
+		},
+		{
+			name:        "in",
+			pattern:     "age(X, A)",
+			constraints: []Constraint{In(1, ast.Number(9), ast.String("20"), name("/unknown"))},
+			want:        stringset.New("age(/bob,9)", `age(/frank,"20")`, "age(/gina,/unknown)"),
+		},
+		{
+			name:        "in empty",
+			pattern:     "age(X, A)",
+			constraints: []Constraint{In(1)},
+			want:        stringset.New(),
+		},
+		{
+			name:        "name prefix",
+			pattern:     "member(M)",
+			constraints: []Constraint{HasNamePrefix(0, "/org/team/")},
+			want:        stringset.New("member(/org/team/alice)", "member(/org/team/bob)"),
+		},
+		{
+			// Strings that look like names are not names.
+			name:        "name prefix excludes strings",
+			pattern:     "word(X, W)",
+			constraints: []Constraint{HasNamePrefix(1, "/ban")},
+			want:        stringset.New("word(/w4,/banana)"),
+		},
+		{
+			name:        "string lookalike equality",
+			pattern:     `word(X, "/banana")`,
+			constraints: nil,
+			want:        stringset.New(`word(/w6,"/banana")`),
+		},
+		{
+			name:        "constraint with constant",
+			pattern:     "age(/alice, A)",
+			constraints: []Constraint{Gt(1, ast.Number(18))},
+			want:        stringset.New("age(/alice,30)"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := stringset.New()
+			err := store.Query(atom(tt.pattern), func(fact ast.Atom) error {
+				got.Add(fact.String())
+				return nil
+			}, Where(tt.constraints...))
+			if err != nil {
+				t.Fatalf("Query(%q, %v) error: %v", tt.pattern, tt.constraints, err)
+			}
+			if !got.Equals(tt.want) {
+				t.Errorf("Query(%q, %v) = %v want %v", tt.pattern, tt.constraints, got, tt.want)
+			}
+		})
+	}
+
+	t.Run("bindings", func(t *testing.T) {
+		got := stringset.New()
+		err := store.GetBindings(atom("age(X, A)"), func(subst ast.ConstSubstMap) error {
+			got.Add(subst[ast.Variable{Symbol: "X"}].String())
+			return nil
+		}, Where(Ge(1, ast.Number(18))))
+		if err != nil {
+			t.Fatalf("GetBindings error: %v", err)
+		}
+		if want := stringset.New("/alice", "/carol"); !got.Equals(want) {
+			t.Errorf("GetBindings = %v want %v", got, want)
+		}
+	})
+
+	invalid := []struct {
+		name       string
+		constraint Constraint
+	}{
+		{"index out of range", Ge(2, ast.Number(1))},
+		{"unordered type", Lt(1, ast.List([]ast.Constant{ast.Number(1)}))},
+		{"prefix without slash", HasNamePrefix(0, "org")},
+	}
+	for _, tt := range invalid {
+		t.Run(tt.name, func(t *testing.T) {
+			err := store.Query(atom("age(X, A)"), func(ast.Atom) error { return nil }, Where(tt.constraint))
+			if err == nil {
+				t.Errorf("Query with %v succeeded, want error", tt.constraint)
+			}
+		})
+	}
+}
+
+// name returns the name constant for s, panicking on invalid input.
+func name(s string) ast.Constant {
+	c, err := ast.Name(s)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+func TestPrefixSuccessor(t *testing.T) {
+	tests := []struct {
+		prefix string
+		want   string
+		ok     bool
+	}{
+		{"/org/team/", "/org/team0", true},
+		{"/a", "/b", true},
+		{"/\U0010FFFF", "0", true},
+		{"/\uD7FF", "/\uE000", true}, // Skips surrogates.
+		{"\U0010FFFF", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := prefixSuccessor(tt.prefix)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("prefixSuccessor(%q) = %q, %v want %q, %v", tt.prefix, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+// runStructuredConstraintsTest tests constraints inside lists, maps and structs.
+func runStructuredConstraintsTest(t *testing.T, newStore func() (*FactStoreDB, error)) {
+	store, err := newStore()
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	facts := []ast.Atom{
+		evalAtom("ticket(/t1, [/x, /y], {/status: /open, /prio: 1})"),
+		evalAtom("ticket(/t2, [/y], {/status: /closed, /prio: 2})"),
+		evalAtom(`ticket(/t3, [[/x], "/x"], {/prio: /open, /status: 3})`),
+		evalAtom("ticket(/t4, /x, [/status: /open])"),
+		evalAtom(`ticket(/t5, ["[1]", [1]], {/status: "/open"})`),
+	}
+	for _, f := range facts {
+		store.Add(f)
+	}
+
+	tests := []struct {
+		name       string
+		constraint Constraint
+		want       stringset.Set
+	}{
+		{
+			name:       "field equals",
+			constraint: FieldEquals(2, name("/status"), name("/open")),
+			want:       stringset.New("/t1"),
+		},
+		{
+			// The value must belong to the field, not to another entry.
+			name:       "field pairing",
+			constraint: FieldEquals(2, name("/prio"), ast.Number(3)),
+			want:       stringset.New(),
+		},
+		{
+			name:       "field number",
+			constraint: FieldEquals(2, name("/prio"), ast.Number(2)),
+			want:       stringset.New("/t2"),
+		},
+		{
+			name:       "field string lookalike",
+			constraint: FieldEquals(2, name("/status"), ast.String("/open")),
+			want:       stringset.New("/t5"),
+		},
+		{
+			name:       "map entry",
+			constraint: MapEntryEquals(2, name("/status"), name("/open")),
+			want:       stringset.New("/t4"),
+		},
+		{
+			name:       "list contains",
+			constraint: ListContains(1, name("/x")),
+			want:       stringset.New("/t1"),
+		},
+		{
+			name:       "list contains string lookalike",
+			constraint: ListContains(1, ast.String("/x")),
+			want:       stringset.New("/t3"),
+		},
+		{
+			name:       "list contains list",
+			constraint: ListContains(1, ast.List([]ast.Constant{name("/x")})),
+			want:       stringset.New("/t3"),
+		},
+		{
+			name:       "list contains number list",
+			constraint: ListContains(1, ast.List([]ast.Constant{ast.Number(1)})),
+			want:       stringset.New("/t5"),
+		},
+		{
+			name:       "list contains string like list",
+			constraint: ListContains(1, ast.String("[1]")),
+			want:       stringset.New("/t5"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := stringset.New()
+			err := store.GetBindings(atom("ticket(T, L, S)"), func(subst ast.ConstSubstMap) error {
+				got.Add(subst[ast.Variable{Symbol: "T"}].String())
+				return nil
+			}, Where(tt.constraint))
+			if err != nil {
+				t.Fatalf("GetBindings with %v error: %v", tt.constraint, err)
+			}
+			if !got.Equals(tt.want) {
+				t.Errorf("GetBindings with %v = %v want %v", tt.constraint, got, tt.want)
+			}
+		})
+	}
+}
@@ -7,12 +7,33 @@ import (
 	"strconv"
 	"sync/atomic"
 
+	"github.com/google/mangle/ast"
 	_ "modernc.org/sqlite" // SQLite driver
 )
 
 // config holds configuration options for the DBFactStore.
 type config struct {
 	pragmas map[string]string
+	// stmtCacheSize bounds the number of cached prepared query statements;
+	// it is negative unless set by WithStatementCacheSize.
+	stmtCacheSize int
+	// codec is the codec requested with WithCodec, or "" for the recorded or default one.
+	codec Codec
+	// interning is set by WithInterning, which interns strings of at least
+	// internMinStringLen bytes if that is positive.
+	interning          bool
+	internMinStringLen int
+	// typed holds the column types of the predicates declared WithTypedPredicate.
+	typed map[ast.PredicateSym][]ColumnType
+	// migrationDryRun is set by WithMigrationDryRun.
+	migrationDryRun bool
+	// durability is the profile set WithDurability.
+	durability Durability
+	// durabilitySet is set by WithDurability, so that the default profile
+	// is not recorded.
+	durabilitySet bool
+	// immutable is set by WithImmutable.
+	immutable bool
 }
 
 // Counter for generating unique in-memory database names
@@ -33,13 +54,32 @@ func WithPragma(key, value string) StoreOption {
 	}
 }
 
+// WithStatementCacheSize sets how many prepared query statements the store
+// keeps, evicting the least recently used ones beyond that. Zero disables the
+// cache, so that every query is sent unprepared. PostgreSQL stores keep 64
+// statements by default; SQLite stores, where preparing saves nothing, none.
+func WithStatementCacheSize(n int) StoreOption {
+	return func(c *config) {
+		c.stmtCacheSize = n
+	}
+}
+
+// statementCacheSize returns the configured statement cache size, or def if
+// none was configured.
+func (c *config) statementCacheSize(def int) int {
+	if c.stmtCacheSize < 0 {
+		return def
+	}
+	return c.stmtCacheSize
+}
+
 // defaultConfig returns a new config with default PRAGMA settings
-// for performance and concurrency.
+// for performance and concurrency. The synchronous PRAGMA is set by the
+// durability profile.
 func defaultConfig() *config {
 	return &config{
 		pragmas: map[string]string{
 			"journal_mode": "WAL",
-			"synchronous":  "OFF",
 			"cache_size":   "-64000",
 			"temp_store":   "MEMORY",
 			"mmap_size":    "268435456",
@@ -47,6 +87,8 @@ func defaultConfig() *config {
 			"foreign_keys": "OFF",
 			"auto_vacuum":  "INCREMENTAL",
 		},
+		stmtCacheSize: -1,
+		durability:    DurabilityBalanced,
 	}
 }
 
@@ -58,12 +100,33 @@ func NewFactStoreSQLite(connStr string, opts ...StoreOption) (*FactStoreDB, erro
 	// This allows concurrent connections within the same database while keeping
 	// different database instances separate
 	if connStr == ":memory:" {
-		// Generate unique name for this in-memory database instance
-		id := inMemoryDBCounter.Add(1)
-		connStr = "file:factstore_" + strconv.FormatUint(id, 10) + "?mode=memory&cache=shared"
+		connStr = newInMemoryConnStr()
 	}
+	return openSQLite(connStr, nil, opts)
+}
 
-	db, err := sql.Open("sqlite", connStr)
+// newInMemoryConnStr returns the connection string of a new in-memory database.
+func newInMemoryConnStr() string {
+	// Generate unique name for this in-memory database instance
+	id := inMemoryDBCounter.Add(1)
+	return "file:factstore_" + strconv.FormatUint(id, 10) + "?mode=memory&cache=shared"
+}
+
+// openSQLite opens the store at connStr. If load is not nil, it is called
+// with the new connection pool before the PRAGMAs are applied and the schema
+// is initialized.
+func openSQLite(connStr string, load func(*sql.DB) error, opts []StoreOption) (*FactStoreDB, error) {
+	// Apply default and user-provided options
+	cfg := defaultConfig()
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	pragmas, err := cfg.sqlitePragmas()
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open("sqlite", cfg.withConnectionPragmas(connStr, pragmas))
 	if err != nil {
 		return nil, fmt.Errorf("failed to open SQLite: %w", err)
 	}
@@ -76,22 +139,23 @@ func NewFactStoreSQLite(connStr string, opts ...StoreOption) (*FactStoreDB, erro
 	db.SetMaxIdleConns(4)
 	// Note: ConnMaxLifetime intentionally not set for in-memory databases
 
-	// Apply default and user-provided options
-	cfg := defaultConfig()
-	for _, opt := range opts {
-		opt(cfg)
+	if load != nil {
+		if err := load(db); err != nil {
+			db.Close()
+			return nil, err
+		}
 	}
 
 	// Sort keys for deterministic execution order (good for testing)
-	keys := make([]string, 0, len(cfg.pragmas))
-	for k := range cfg.pragmas {
+	keys := make([]string, 0, len(pragmas))
+	for k := range pragmas {
 		keys = append(keys, k)
 	}
 	sort.Strings(keys)
 
 	// Apply PRAGMA settings
 	for _, key := range keys {
-		value := cfg.pragmas[key]
+		value := pragmas[key]
 		pragmaSQL := "PRAGMA " + key + "=" + value
 		if _, err := db.Exec(pragmaSQL); err != nil {
 			db.Close()
@@ -103,9 +167,11 @@ func NewFactStoreSQLite(connStr string, opts ...StoreOption) (*FactStoreDB, erro
 		db:      db,
 		ownsDB:  true,
 		dialect: sqliteDialect{},
+		stmts:   newStmtCache(db, cfg.statementCacheSize(0)),
+		pragmas: pragmas,
 	}
 
-	if err := store.initSchemaAndStatements(); err != nil {
+	if err := store.initSchemaAndStatements(cfg); err != nil {
 		db.Close()
 		return nil, fmt.Errorf("failed to initialize schema: %w", err)
 	}
@@ -123,17 +189,21 @@ func NewFactStoreSQLiteFromDB(db *sql.DB, opts ...StoreOption) (*FactStoreDB, er
 	for _, opt := range opts {
 		opt(cfg)
 	}
+	pragmas, err := cfg.sqlitePragmas()
+	if err != nil {
+		return nil, err
+	}
 
 	// Sort keys for deterministic execution order (good for testing)
-	keys := make([]string, 0, len(cfg.pragmas))
-	for k := range cfg.pragmas {
+	keys := make([]string, 0, len(pragmas))
+	for k := range pragmas {
 		keys = append(keys, k)
 	}
 	sort.Strings(keys)
 
 	// Apply PRAGMA settings
 	for _, key := range keys {
-		value := cfg.pragmas[key]
+		value := pragmas[key]
 		pragmaSQL := "PRAGMA " + key + "=" + value
 		if _, err := db.Exec(pragmaSQL); err != nil {
 			return nil, fmt.Errorf("failed to set pragma %q: %w", pragmaSQL, err)
@@ -144,33 +214,89 @@ func NewFactStoreSQLiteFromDB(db *sql.DB, opts ...StoreOption) (*FactStoreDB, er
 		db:      db,
 		ownsDB:  false,
 		dialect: sqliteDialect{},
+		stmts:   newStmtCache(db, cfg.statementCacheSize(0)),
+		pragmas: pragmas,
 	}
 
-	if err := store.initSchemaAndStatements(); err != nil {
+	if err := store.initSchemaAndStatements(cfg); err != nil {
 		return nil, fmt.Errorf("failed to initialize schema: %w", err)
 	}
 
 	return store, nil
 }
 
-// initSchemaAndStatements creates the table, indexes, and prepared statements.
-func (s *FactStoreDB) initSchemaAndStatements() error {
-	// Create facts table with 3 columns for optimal performance
+// initSchemaAndStatements creates the tables, indexes, and prepared statements.
+func (s *FactStoreDB) initSchemaAndStatements(cfg *config) error {
+	// Check the schema version before changing anything.
+	version, existed, err := s.schemaVersion()
+	if err != nil {
+		return err
+	}
+	if s.readOnly && !existed {
+		return fmt.Errorf("no fact store to open read-only")
+	}
+	if cfg.migrationDryRun && version < len(migrations) {
+		return pendingMigrationsError(version)
+	}
+
+	// The metadata table records the schema version and how the facts are encoded.
+	if !s.readOnly {
+		if _, err := s.db.Exec(s.dialect.createMetaTableSQL()); err != nil {
+			return fmt.Errorf("failed to create metadata table: %w", err)
+		}
+	}
+	if _, err := s.resolveSetting(metaHashKey, hashAlgorithm, hashAlgorithm, hashAlgorithm, existed); err != nil {
+		return err
+	}
+	if !s.readOnly {
+		if _, err := cfg.profile(); err != nil {
+			return err
+		}
+		s.durability = cfg.durability
+		if cfg.durabilitySet {
+			if err := s.setMetadata(metaDurabilityKey, string(cfg.durability)); err != nil {
+				return err
+			}
+		}
+	}
+	if s.codec, err = s.resolveCodec(cfg.codec, existed); err != nil {
+		return err
+	}
+	if err := s.initDictionary(cfg, existed); err != nil {
+		return err
+	}
+
+	// Migrations create the facts table, with 3 columns for optimal performance:
 	// atom_hash: UNIQUE constraint ensures deduplication and concurrent safety
-	// args: Stored in a format suitable for the dialect (BLOB for SQLite, JSONB for PG)
-	createTableSQL := s.dialect.createTableSQL()
-	if _, err := s.db.Exec(createTableSQL); err != nil {
-		return fmt.Errorf("failed to create facts table: %w", err)
+	// args: Stored in the codec's format (JSONB by default)
+	// and the index on predicate for faster GetFacts queries. Read-only stores
+	// are read at their version if their pending migrations change nothing.
+	if s.readOnly {
+		if err := s.checkPendingMigrations(version); err != nil {
+			return err
+		}
+	} else if err := s.migrate(version); err != nil {
+		return err
 	}
 
-	// Create index on predicate for faster GetFacts queries
-	createIndexSQL := s.dialect.createIndexSQL()
-	if _, err := s.db.Exec(createIndexSQL); err != nil {
-		return fmt.Errorf("failed to create predicate index: %w", err)
+	// Typed predicates are kept in tables of their own.
+	if err := s.initTypedTables(cfg); err != nil {
+		return err
+	}
+
+	// Prepare statement for Contains
+	containsSQL := s.dialect.containsSQL()
+	containsStmt, err := s.db.Prepare(containsSQL)
+	if err != nil {
+		return fmt.Errorf("failed to prepare contains statement: %w", err)
+	}
+	s.containsStmt = containsStmt
+	if s.readOnly {
+		return nil
 	}
 
 	// Prepare statement for Add with ON CONFLICT for concurrent safety
-	addSQL := s.dialect.addSQL()
+	addSQL := s.dialect.addSQL(s.codec.column(), s.argsValueSQL)
 	addStmt, err := s.db.Prepare(addSQL)
 	if err != nil {
 		return fmt.Errorf("failed to prepare add statement: %w", err)
@@ -185,13 +311,5 @@ func (s *FactStoreDB) initSchemaAndStatements() error {
 	}
 	s.removeStmt = removeStmt
 
-	// Prepare statement for Contains
-	containsSQL := s.dialect.containsSQL()
-	containsStmt, err := s.db.Prepare(containsSQL)
-	if err != nil {
-		return fmt.Errorf("failed to prepare contains statement: %w", err)
-	}
-	s.containsStmt = containsStmt
-
 	return nil
 }
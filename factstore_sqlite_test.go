@@ -2,6 +2,7 @@ package factstoredb
 
 import (
 	"database/sql"
+	"strings"
 	"testing"
 
 	"github.com/google/mangle/ast"
@@ -106,6 +107,46 @@ func TestSQLiteFactStore(t *testing.T) {
 		runJSONRoundTripTest(t)
 	})
 
+	t.Run("GetBindings", func(t *testing.T) {
+		runGetBindingsTest(t, newSQLiteDBStore)
+	})
+
+	t.Run("Constraints", func(t *testing.T) {
+		runConstraintsTest(t, newSQLiteDBStore)
+	})
+
+	t.Run("StructuredConstraints", func(t *testing.T) {
+		runStructuredConstraintsTest(t, newSQLiteDBStore)
+	})
+
+	t.Run("Aggregate", func(t *testing.T) {
+		runAggregateTest(t, newSQLiteDBStore)
+	})
+
+	t.Run("Ordering", func(t *testing.T) {
+		runOrderingTest(t, newSQLiteDBStore)
+	})
+
+	t.Run("Pagination", func(t *testing.T) {
+		runPaginationTest(t, newSQLiteDBStore)
+	})
+
+	t.Run("Iterators", func(t *testing.T) {
+		runIteratorTest(t, newSQLiteDBStore)
+	})
+
+	t.Run("Graph", func(t *testing.T) {
+		runGraphTest(t, newSQLiteDBStore)
+	})
+
+	t.Run("Negation", func(t *testing.T) {
+		runNegationTest(t, newSQLiteDBStore)
+	})
+
+	t.Run("Explain", func(t *testing.T) {
+		runExplainTest(t, newSQLiteDBStore)
+	})
+
 	// This test is specific to the implementation detail of how atoms are stored
 	// and is not part of the generic FactStore interface tests.
 	t.Run("UnmarshalAtom", func(t *testing.T) {
@@ -123,3 +164,46 @@ func TestSQLiteFactStore(t *testing.T) {
 		}
 	})
 }
+
+// TestCreateArgIndex verifies that name-prefix queries are answered from an argument index.
+func TestCreateArgIndex(t *testing.T) {
+	store, err := NewFactStoreSQLite(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	if err := store.CreateArgIndex(0); err != nil {
+		t.Fatalf("CreateArgIndex failed: %v", err)
+	}
+	// Creating the same index again is a no-op.
+	if err := store.CreateArgIndex(0); err != nil {
+		t.Fatalf("second CreateArgIndex failed: %v", err)
+	}
+
+	q := newFactsQuery(store.dialect)
+	q.sql.WriteString("EXPLAIN QUERY PLAN ")
+	q.sql.WriteString(store.dialect.getFactsBaseSQL(store.dialect.argsSQL()))
+	q.params = append(q.params, "member_1")
+	if err := q.writeConstraints([]Constraint{HasNamePrefix(0, "/org/team/")}); err != nil {
+		t.Fatalf("writeConstraints failed: %v", err)
+	}
+
+	rows, err := store.db.Query(q.sql.String(), q.params...)
+	if err != nil {
+		t.Fatalf("EXPLAIN QUERY PLAN failed: %v", err)
+	}
+	defer rows.Close()
+	var plan strings.Builder
+	for rows.Next() {
+		var id, parent, notused int
+		var detail string
+		if err := rows.Scan(&id, &parent, &notused, &detail); err != nil {
+			t.Fatalf("Failed to scan plan row: %v", err)
+		}
+		plan.WriteString(detail)
+	}
+	if !strings.Contains(plan.String(), "idx_arg_0") {
+		t.Errorf("query plan %q does not use idx_arg_0", plan.String())
+	}
+}
@@ -0,0 +1,197 @@
+package factstoredb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/go-json-experiment/json"
+	"github.com/google/mangle/ast"
+)
+
+// SQLite JSONB element types, stored in the low four bits of each element header.
+// See https://sqlite.org/jsonb.html.
+const (
+	jsonbNull    = 0
+	jsonbTrue    = 1
+	jsonbFalse   = 2
+	jsonbInt     = 3
+	jsonbInt5    = 4
+	jsonbFloat   = 5
+	jsonbFloat5  = 6
+	jsonbText    = 7
+	jsonbTextJ   = 8
+	jsonbText5   = 9
+	jsonbTextRaw = 10
+	jsonbArray   = 11
+	jsonbObject  = 12
+)
+
+var errTruncatedJSONB = errors.New("truncated JSONB element")
+
+// jsonbHeader decodes the element header at the start of data and returns
+// the element type, the header length and the payload length.
+func jsonbHeader(data []byte) (typ byte, headerLen, payloadLen int, err error) {
+	if len(data) == 0 {
+		return 0, 0, 0, errTruncatedJSONB
+	}
+	typ = data[0] & 0x0f
+	size := data[0] >> 4
+	var n uint64
+	switch {
+	case size <= 11:
+		headerLen, n = 1, uint64(size)
+	case size == 12 && len(data) >= 2:
+		headerLen, n = 2, uint64(data[1])
+	case size == 13 && len(data) >= 3:
+		headerLen, n = 3, uint64(binary.BigEndian.Uint16(data[1:]))
+	case size == 14 && len(data) >= 5:
+		headerLen, n = 5, uint64(binary.BigEndian.Uint32(data[1:]))
+	case size == 15 && len(data) >= 9:
+		headerLen, n = 9, binary.BigEndian.Uint64(data[1:])
+	default:
+		return 0, 0, 0, errTruncatedJSONB
+	}
+	if n > uint64(len(data)-headerLen) {
+		return 0, 0, 0, errTruncatedJSONB
+	}
+	return typ, headerLen, int(n), nil
+}
+
+// decodeJSONBArgs decodes the args column of a fact stored as SQLite JSONB:
+// an array with one element per argument.
+func decodeJSONBArgs(data []byte) ([]ast.BaseTerm, error) {
+	typ, h, n, err := jsonbHeader(data)
+	if err != nil {
+		return nil, err
+	}
+	if typ != jsonbArray || h+n != len(data) {
+		return nil, errors.New("args are not a JSONB array")
+	}
+	var args []ast.BaseTerm
+	for payload := data[h:]; len(payload) > 0; {
+		c, size, err := decodeJSONBConstant(payload)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, c)
+		payload = payload[size:]
+	}
+	return args, nil
+}
+
+// decodeJSONBConstant decodes the JSONB element at the start of data into a
+// constant, following the same conventions as constantJSON, and returns the
+// number of bytes it occupies.
+func decodeJSONBConstant(data []byte) (ast.Constant, int, error) {
+	typ, h, n, err := jsonbHeader(data)
+	if err != nil {
+		return ast.Constant{}, 0, err
+	}
+	payload := data[h : h+n]
+	size := h + n
+
+	switch typ {
+	case jsonbInt:
+		if i, err := strconv.ParseInt(string(payload), 10, 64); err == nil {
+			return ast.Number(i), size, nil
+		}
+		// Out of int64 range; decode like any other JSON number.
+		c, err := jsonNumberConstant(string(payload))
+		return c, size, err
+
+	case jsonbFloat:
+		c, err := jsonNumberConstant(string(payload))
+		return c, size, err
+
+	case jsonbText, jsonbTextRaw:
+		c, err := constantFromJSONString(string(payload))
+		return c, size, err
+
+	case jsonbTextJ, jsonbText5:
+		// The payload keeps its escape sequences; let the JSON decoder resolve them.
+		var str string
+		if err := json.Unmarshal([]byte(`"`+string(payload)+`"`), &str); err != nil {
+			return ast.Constant{}, 0, fmt.Errorf("failed to unescape JSONB string: %w", err)
+		}
+		c, err := constantFromJSONString(str)
+		return c, size, err
+
+	case jsonbArray:
+		var elems []ast.Constant
+		for rest := payload; len(rest) > 0; {
+			elem, m, err := decodeJSONBConstant(rest)
+			if err != nil {
+				return ast.Constant{}, 0, fmt.Errorf("failed to decode list element: %w", err)
+			}
+			elems = append(elems, elem)
+			rest = rest[m:]
+		}
+		return ast.List(elems), size, nil
+
+	case jsonbObject:
+		c, err := decodeJSONBObject(payload)
+		return c, size, err
+
+	case jsonbNull:
+		return ast.Constant{}, 0, errors.New("null values are not supported for constants")
+
+	default:
+		return ast.Constant{}, 0, fmt.Errorf("unsupported JSONB element type %d", typ)
+	}
+}
+
+// decodeJSONBObject decodes the payload of a {"fn:...": [args]} object.
+func decodeJSONBObject(payload []byte) (ast.Constant, error) {
+	key, m, err := decodeJSONBConstant(payload)
+	if err != nil {
+		return ast.Constant{}, fmt.Errorf("failed to read object key: %w", err)
+	}
+	if key.Type != ast.StringType {
+		return ast.Constant{}, errors.New("expected string key for function object")
+	}
+	symbol, _ := key.StringValue()
+
+	rest := payload[m:]
+	typ, h, n, err := jsonbHeader(rest)
+	if err != nil {
+		return ast.Constant{}, err
+	}
+	if typ != jsonbArray || h+n != len(rest) {
+		return ast.Constant{}, fmt.Errorf("expected a single array value for %s", symbol)
+	}
+	var args []ast.Constant
+	if symbol == fnString {
+		// The wrapped string must not be interpreted as a name or bytes.
+		elem := rest[h:]
+		typ, eh, en, err := jsonbHeader(elem)
+		if err != nil {
+			return ast.Constant{}, err
+		}
+		if eh+en != len(elem) {
+			return ast.Constant{}, fmt.Errorf("%s expects a single string arg", fnString)
+		}
+		switch typ {
+		case jsonbText, jsonbTextRaw:
+			return ast.String(string(elem[eh:])), nil
+		case jsonbTextJ, jsonbText5:
+			var str string
+			if err := json.Unmarshal([]byte(`"`+string(elem[eh:])+`"`), &str); err != nil {
+				return ast.Constant{}, fmt.Errorf("failed to unescape JSONB string: %w", err)
+			}
+			return ast.String(str), nil
+		default:
+			return ast.Constant{}, fmt.Errorf("%s expects a single string arg", fnString)
+		}
+	}
+	for elems := rest[h:]; len(elems) > 0; {
+		arg, m, err := decodeJSONBConstant(elems)
+		if err != nil {
+			return ast.Constant{}, fmt.Errorf("failed to unmarshal arg: %w", err)
+		}
+		args = append(args, arg)
+		elems = elems[m:]
+	}
+	return applyFnConstant(symbol, args)
+}
@@ -0,0 +1,114 @@
+package factstoredb
+
+import (
+	"testing"
+
+	"github.com/google/mangle/ast"
+)
+
+func TestDecodeJSONBArgs(t *testing.T) {
+	store, err := NewFactStoreSQLite(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	tests := []string{
+		"p(/name, 42, -7, 3.5, 123456.789)",
+		`p("plain", "with \"quotes\" and \\ backslash", "tab\tnewline\n", "unicode é世")`,
+		`p("/looks/like/a/name", "b\"not bytes\"", b"\x00\x01bytes")`,
+		"p([], [1, [2, /x]], fn:pair(/a, 1))",
+		`p(fn:map(/k, "v", 2, [3]), {/field: /value, /n: 1.25})`,
+		"p(9007199254740993, -9223372036854775808)",
+	}
+	for _, src := range tests {
+		want := evalAtom(src)
+		_, argsJSON, err := atomToRowForInsertBinary(want)
+		if err != nil {
+			t.Fatalf("atomToRowForInsertBinary(%s) error = %v", src, err)
+		}
+		var data []byte
+		if err := store.db.QueryRow("SELECT jsonb(CAST(? AS TEXT))", argsJSON).Scan(&data); err != nil {
+			t.Fatalf("jsonb(%s) error = %v", argsJSON, err)
+		}
+		if len(data) > 0 && data[0] == '[' {
+			t.Fatalf("jsonb(%s) returned JSON text", argsJSON)
+		}
+		got, err := store.dialect.decodeArgs(want.Predicate, data)
+		if err != nil {
+			t.Fatalf("decodeArgs(%s) error = %v", src, err)
+		}
+		if !got.Equals(want) {
+			t.Errorf("decodeArgs(%s) = %v, want %v", src, got, want)
+		}
+	}
+}
+
+func TestDecodeJSONBFromSQL(t *testing.T) {
+	store, err := NewFactStoreSQLite(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	// JSONB built by SQL functions may use raw text elements.
+	var data []byte
+	if err := store.db.QueryRow(`SELECT jsonb_array('/name', 'say "hi"', 1, 2.5, jsonb_array())`).Scan(&data); err != nil {
+		t.Fatalf("jsonb_array() error = %v", err)
+	}
+	pred := ast.PredicateSym{Symbol: "p", Arity: 5}
+	got, err := store.dialect.decodeArgs(pred, data)
+	if err != nil {
+		t.Fatalf("decodeArgs() error = %v", err)
+	}
+	want := evalAtom(`p(/name, "say \"hi\"", 1, 2.5, [])`)
+	if !got.Equals(want) {
+		t.Errorf("decodeArgs() = %v, want %v", got, want)
+	}
+
+	if _, err := store.dialect.decodeArgs(pred, []byte{0x5b, 0x01}); err == nil {
+		t.Error("decodeArgs() of truncated JSONB succeeded, want error")
+	}
+}
+
+func TestSQLiteLegacyTextArgs(t *testing.T) {
+	store, err := NewFactStoreSQLite(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	// Earlier versions stored the JSON text itself in the args blob.
+	fact := evalAtom(`legacy(/a, "text", [1, 2])`)
+	hash, argsJSON, err := atomToRowForInsertBinary(fact)
+	if err != nil {
+		t.Fatalf("atomToRowForInsertBinary() error = %v", err)
+	}
+	if _, err := store.db.Exec("INSERT INTO facts (predicate, atom_hash, args) VALUES (?, ?, ?)",
+		predicateToKey(fact.Predicate), hash, argsJSON); err != nil {
+		t.Fatalf("insert error = %v", err)
+	}
+	store.Add(evalAtom(`legacy(/b, "jsonb", [3])`))
+
+	var got []ast.Atom
+	if err := store.GetFacts(atom("legacy(X, Y, Z)"), func(a ast.Atom) error {
+		got = append(got, a)
+		return nil
+	}); err != nil {
+		t.Fatalf("GetFacts() error = %v", err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("GetFacts() = %v, want 2 facts", got)
+	}
+	if !store.Contains(fact) {
+		t.Errorf("Contains(%v) = false, want true", fact)
+	}
+
+	var jsonb bool
+	if err := store.db.QueryRow("SELECT json_valid(args, 8) FROM facts WHERE args -> '$[0]' = '\"/b\"'").Scan(&jsonb); err != nil {
+		t.Fatalf("json_valid() error = %v", err)
+	}
+	if !jsonb {
+		t.Error("Add() did not store args as JSONB")
+	}
+}
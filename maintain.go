@@ -0,0 +1,193 @@
+package factstoredb
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// MaintenanceTask selects work done by Maintain. Tasks combine with |.
+type MaintenanceTask int
+
+const (
+	// MaintainVacuum releases the pages freed by removed facts: PRAGMA
+	// incremental_vacuum on SQLite, VACUUM on Postgres.
+	MaintainVacuum MaintenanceTask = 1 << iota
+	// MaintainCheckpoint copies the write-ahead log into the database and
+	// truncates it: PRAGMA wal_checkpoint(TRUNCATE) on SQLite. Postgres
+	// checkpoints on its own.
+	MaintainCheckpoint
+	// MaintainAnalyze updates the statistics of the query planner: PRAGMA
+	// optimize on SQLite, ANALYZE on Postgres.
+	MaintainAnalyze
+
+	// MaintainAll runs all tasks.
+	MaintainAll = MaintainVacuum | MaintainCheckpoint | MaintainAnalyze
+)
+
+// MaintainOption configures Maintain and ScheduleMaintenance.
+type MaintainOption func(*maintainConfig)
+
+type maintainConfig struct {
+	tasks       MaintenanceTask
+	vacuumPages int
+}
+
+// WithMaintenanceTasks limits Maintain to the given tasks instead of MaintainAll.
+func WithMaintenanceTasks(tasks MaintenanceTask) MaintainOption {
+	return func(c *maintainConfig) {
+		c.tasks = tasks
+	}
+}
+
+// WithVacuumPages limits the pages an incremental vacuum releases per run,
+// which bounds the time it holds the write lock. By default, all free pages
+// are released. Postgres ignores the limit.
+func WithVacuumPages(pages int) MaintainOption {
+	return func(c *maintainConfig) {
+		c.vacuumPages = pages
+	}
+}
+
+// MaintenanceReport is the result of Maintain.
+type MaintenanceReport struct {
+	// FreedPages is the number of unused pages the vacuum removed from the
+	// database file. It is 0 for databases that do not report free pages.
+	FreedPages int
+	// Duration is the time Maintain took.
+	Duration time.Duration
+}
+
+// Maintain runs maintenance tasks that keep the database compact and its
+// queries well planned. It should run after heavy Remove traffic, since
+// removed facts do not shrink the database file otherwise, and periodically
+// for long-lived stores. Vacuum and analyze cover the facts table, the tables
+// of typed predicates and the dictionary.
+func (s *FactStoreDB) Maintain(ctx context.Context, opts ...MaintainOption) (MaintenanceReport, error) {
+	cfg := &maintainConfig{tasks: MaintainAll}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	start := time.Now()
+	var report MaintenanceReport
+
+	tables := []string{"facts"}
+	for _, t := range s.typed {
+		tables = append(tables, t.name)
+	}
+	if s.dict != nil {
+		tables = append(tables, "constants")
+	}
+
+	freePages := s.dialect.freePagesSQL()
+	var before int
+	if freePages != "" {
+		if err := s.db.QueryRowContext(ctx, freePages).Scan(&before); err != nil {
+			return report, fmt.Errorf("failed to count free pages: %w", err)
+		}
+	}
+	for _, stmt := range s.dialect.maintenanceSQL(cfg.tasks, tables, cfg.vacuumPages) {
+		// Some pragmas do their work one step at a time, so the statements
+		// run as queries whose rows are drained.
+		rows, err := s.db.QueryContext(ctx, stmt)
+		if err != nil {
+			return report, fmt.Errorf("failed to run %q: %w", stmt, err)
+		}
+		for rows.Next() {
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return report, fmt.Errorf("failed to run %q: %w", stmt, err)
+		}
+	}
+	if freePages != "" {
+		var after int
+		if err := s.db.QueryRowContext(ctx, freePages).Scan(&after); err != nil {
+			return report, fmt.Errorf("failed to count free pages: %w", err)
+		}
+		report.FreedPages = max(before-after, 0)
+	}
+	report.Duration = time.Since(start)
+	return report, nil
+}
+
+// MaintenanceScheduler runs Maintain in the background. It is created by
+// ScheduleMaintenance and must be stopped before the store is closed.
+type MaintenanceScheduler struct {
+	store     *FactStoreDB
+	opts      []MaintainOption
+	maxWrites int64
+	// lastWrites is the write count of the store at the previous tick.
+	lastWrites int64
+	runs       atomic.Int64
+	skipped    atomic.Int64
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// ScheduleMaintenance runs Maintain with opts every interval, unless more
+// than maxWrites facts were added or removed since the previous interval, so
+// that maintenance does not compete with heavy write load. Skipped runs are
+// retried at the next interval. Errors are logged.
+func (s *FactStoreDB) ScheduleMaintenance(interval time.Duration, maxWrites int, opts ...MaintainOption) *MaintenanceScheduler {
+	ctx, cancel := context.WithCancel(context.Background())
+	m := &MaintenanceScheduler{
+		store:      s,
+		opts:       opts,
+		maxWrites:  int64(maxWrites),
+		lastWrites: s.writes.Load(),
+		cancel:     cancel,
+	}
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				m.tick(ctx)
+			}
+		}
+	}()
+	return m
+}
+
+// tick runs Maintain unless the write load is too high and reports whether it ran.
+func (m *MaintenanceScheduler) tick(ctx context.Context) bool {
+	writes := m.store.writes.Load()
+	recent := writes - m.lastWrites
+	m.lastWrites = writes
+	if recent > m.maxWrites {
+		m.skipped.Add(1)
+		return false
+	}
+	if _, err := m.store.Maintain(ctx, m.opts...); err != nil && ctx.Err() == nil {
+		log.Printf("DBFactStore maintenance failed: %v", err)
+	}
+	m.runs.Add(1)
+	return true
+}
+
+// Runs returns the number of times the scheduler ran Maintain.
+func (m *MaintenanceScheduler) Runs() int {
+	return int(m.runs.Load())
+}
+
+// Skipped returns the number of runs skipped because of write load.
+func (m *MaintenanceScheduler) Skipped() int {
+	return int(m.skipped.Load())
+}
+
+// Stop stops the scheduler and waits for a running Maintain to return.
+func (m *MaintenanceScheduler) Stop() {
+	m.cancel()
+	m.wg.Wait()
+}
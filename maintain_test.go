@@ -0,0 +1,91 @@
+package factstoredb
+
+import (
+	"context"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/mangle/ast"
+)
+
+func TestMaintain(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewFactStoreSQLite(filepath.Join(t.TempDir(), "facts.db"))
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	padding := strings.Repeat("x", 200)
+	facts := make([]ast.Atom, 2000)
+	for i := range facts {
+		facts[i] = evalAtom("p(" + strconv.Itoa(i) + `, "` + padding + `")`)
+	}
+	for _, fact := range facts {
+		store.Add(fact)
+	}
+	for _, fact := range facts {
+		store.Remove(fact)
+	}
+	var free int
+	if err := store.db.QueryRow("PRAGMA freelist_count").Scan(&free); err != nil || free < 10 {
+		t.Fatalf("freelist_count = %d, %v, want free pages after removing all facts", free, err)
+	}
+
+	report, err := store.Maintain(ctx, WithVacuumPages(5))
+	if err != nil {
+		t.Fatalf("Maintain() error = %v", err)
+	}
+	// Releasing pages may also free pointer-map pages of the file.
+	if report.FreedPages < 5 || report.FreedPages >= free {
+		t.Errorf("Maintain(WithVacuumPages(5)) freed %d of %d pages, want about 5", report.FreedPages, free)
+	}
+	report, err = store.Maintain(ctx)
+	if err != nil {
+		t.Fatalf("Maintain() error = %v", err)
+	}
+	if err := store.db.QueryRow("PRAGMA freelist_count").Scan(&free); err != nil || free != 0 || report.FreedPages == 0 {
+		t.Errorf("Maintain() freed %d pages and left %d (%v), want all free pages released", report.FreedPages, free, err)
+	}
+	var stats int
+	if err := store.db.QueryRow("SELECT COUNT(*) FROM sqlite_stat1").Scan(&stats); err != nil || stats == 0 {
+		t.Errorf("sqlite_stat1 has %d rows (%v) after Maintain(), want statistics", stats, err)
+	}
+	var busy, logFrames, checkpointed int
+	if err := store.db.QueryRow("PRAGMA wal_checkpoint(PASSIVE)").Scan(&busy, &logFrames, &checkpointed); err != nil || logFrames != 0 {
+		t.Errorf("WAL holds %d frames (%v) after Maintain(), want 0", logFrames, err)
+	}
+
+	if report, err := store.Maintain(ctx, WithMaintenanceTasks(MaintainAnalyze)); err != nil || report.FreedPages != 0 {
+		t.Errorf("Maintain(WithMaintenanceTasks(MaintainAnalyze)) = %+v, %v, want no pages freed", report, err)
+	}
+}
+
+func TestMaintenanceScheduler(t *testing.T) {
+	store, err := NewFactStoreSQLite(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	// A long interval leaves the ticks to the test.
+	m := store.ScheduleMaintenance(time.Hour, 2)
+	defer m.Stop()
+	for i := range 3 {
+		store.Add(evalAtom("p(" + strconv.Itoa(i) + ")"))
+	}
+	ctx := context.Background()
+	if m.tick(ctx) {
+		t.Error("tick() ran maintenance after 3 writes, want it skipped")
+	}
+	store.Add(evalAtom("p(/a)"))
+	if !m.tick(ctx) {
+		t.Error("tick() skipped maintenance after 1 write, want it run")
+	}
+	if m.Runs() != 1 || m.Skipped() != 1 {
+		t.Errorf("Runs(), Skipped() = %d, %d, want 1, 1", m.Runs(), m.Skipped())
+	}
+}
@@ -0,0 +1,124 @@
+package factstoredb
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Store settings that decide how facts are encoded are recorded in the
+// factstore_meta table when a store is created, so that it is always read
+// and written with the settings it was created with.
+
+// resolveSetting returns the value of the store setting recorded under key.
+// A recorded value wins over the default and must match an explicitly
+// configured one; configured is "" if the caller did not ask for a value.
+// If nothing is recorded, stores that existed before the setting was
+// recorded get legacy and new stores get configured, or def if that is empty.
+// The resolved value is recorded, unless the store is read-only.
+func (s *FactStoreDB) resolveSetting(key, configured, legacy, def string, existed bool) (string, error) {
+	recorded, err := s.metadata(key)
+	if err != nil {
+		return "", err
+	}
+	if recorded != "" {
+		if configured != "" && configured != recorded {
+			return "", fmt.Errorf("store was written with %s %q and cannot be opened with %s %q", key, recorded, key, configured)
+		}
+		return recorded, nil
+	}
+
+	value := legacy
+	if !existed {
+		value = configured
+		if value == "" {
+			value = def
+		}
+	}
+	if existed && configured != "" && configured != value {
+		return "", fmt.Errorf("store was written with %s %q and cannot be opened with %s %q", key, value, key, configured)
+	}
+	if s.readOnly {
+		return value, nil
+	}
+	if err := s.setMetadata(key, value); err != nil {
+		return "", err
+	}
+	return value, nil
+}
+
+// metadata returns the value recorded under key in factstore_meta, or "" if there is none.
+func (s *FactStoreDB) metadata(key string) (string, error) {
+	var value string
+	err := s.db.QueryRow("SELECT value FROM factstore_meta WHERE key = "+s.dialect.placeholder(1), key).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		if !s.hasMetaTable() {
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to read store metadata %q: %w", key, err)
+	}
+	return value, nil
+}
+
+// hasMetaTable reports whether the factstore_meta table exists. Stores at
+// schema version 1 opened read-only have none.
+func (s *FactStoreDB) hasMetaTable() bool {
+	exists, err := s.tableExists("factstore_meta")
+	return err != nil || exists
+}
+
+// metadataWithPrefix returns the values recorded under keys starting with prefix, by key.
+func (s *FactStoreDB) metadataWithPrefix(prefix string) (map[string]string, error) {
+	rows, err := s.db.Query("SELECT key, value FROM factstore_meta")
+	if err != nil {
+		if !s.hasMetaTable() {
+			return map[string]string{}, nil
+		}
+		return nil, fmt.Errorf("failed to read store metadata: %w", err)
+	}
+	defer rows.Close()
+	values := make(map[string]string)
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, fmt.Errorf("failed to read store metadata: %w", err)
+		}
+		if strings.HasPrefix(key, prefix) {
+			values[key] = value
+		}
+	}
+	return values, rows.Err()
+}
+
+// setMetadata records value under key in factstore_meta.
+func (s *FactStoreDB) setMetadata(key, value string) error {
+	return s.writeMetadata(s.db, key, value)
+}
+
+// execer is implemented by *sql.DB and *sql.Tx.
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// writeMetadata records value under key in factstore_meta through ex.
+func (s *FactStoreDB) writeMetadata(ex execer, key, value string) error {
+	query := "INSERT INTO factstore_meta (key, value) VALUES (" + s.dialect.placeholder(1) + ", " + s.dialect.placeholder(2) + ")" +
+		" ON CONFLICT (key) DO UPDATE SET value = excluded.value"
+	if _, err := ex.Exec(query, key, value); err != nil {
+		return fmt.Errorf("failed to write store metadata %q: %w", key, err)
+	}
+	return nil
+}
+
+// tableExists reports whether the table exists in the store's schema.
+func (s *FactStoreDB) tableExists(table string) (bool, error) {
+	var count int
+	if err := s.db.QueryRow(s.dialect.tableExistsSQL(), table).Scan(&count); err != nil {
+		return false, fmt.Errorf("failed to look up table %q: %w", table, err)
+	}
+	return count > 0, nil
+}
@@ -0,0 +1,369 @@
+package factstoredb
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/go-json-experiment/json/jsontext"
+	"github.com/google/mangle/ast"
+)
+
+// ErrMigrationsPending is returned when a store opened WithMigrationDryRun
+// needs migrations. The error lists them.
+var ErrMigrationsPending = errors.New("store schema needs migrations")
+
+// ErrSchemaTooNew is returned when a store was written by a newer version of
+// this package, with a schema version it does not know.
+var ErrSchemaTooNew = errors.New("store schema is newer than supported")
+
+// WithMigrationDryRun makes the constructor check the schema version of the
+// store without changing the database. If migrations are pending, it returns
+// an error wrapping ErrMigrationsPending that lists them; otherwise the store
+// opens as usual.
+func WithMigrationDryRun() StoreOption {
+	return func(c *config) {
+		c.migrationDryRun = true
+	}
+}
+
+// metaSchemaVersionKey is the factstore_meta key recording the schema version,
+// the number of migrations applied to the store.
+const metaSchemaVersionKey = "schema_version"
+
+// metaHashKey is the factstore_meta key recording how atom_hash is computed.
+const metaHashKey = "hash"
+
+// hashAlgorithm names the atom_hash computation of atomHash: FNV-1a of the
+// predicate symbol and the constant hashes, combined with Szudzik pairing.
+const hashAlgorithm = "fnv1a-szudzik"
+
+// migration upgrades the schema of a store by one version. Migrations run on
+// open, in order, each in its own transaction together with the update of
+// the recorded version. They must also work on an empty database.
+type migration struct {
+	description string
+	up          func(s *FactStoreDB, tx *sql.Tx) error
+	// needed reports, without changing the database, whether a store at the
+	// previous version cannot be read as it is; nil means it cannot. Read-only
+	// stores open at an older version if none of their pending migrations
+	// is needed.
+	needed func(s *FactStoreDB) (bool, error)
+}
+
+// migrations lists the schema migrations; the schema version of a store is
+// the number of migrations applied to it. Stores created before the version
+// was recorded are at version 1.
+var migrations = []migration{
+	{
+		description: "create the facts table and predicate index",
+		up: func(s *FactStoreDB, tx *sql.Tx) error {
+			if _, err := tx.Exec(s.dialect.createTableSQL(s.codec.columnType(s.dialect))); err != nil {
+				return fmt.Errorf("failed to create facts table: %w", err)
+			}
+			if _, err := tx.Exec(s.dialect.createIndexSQL()); err != nil {
+				return fmt.Errorf("failed to create predicate index: %w", err)
+			}
+			return nil
+		},
+	},
+	{
+		description: "convert args stored as JSON text to JSONB",
+		up: func(s *FactStoreDB, tx *sql.Tx) error {
+			query := s.dialect.convertTextArgsSQL()
+			if query == "" || s.codec.name() != CodecJSON {
+				return nil
+			}
+			if _, err := tx.Exec(query); err != nil {
+				return fmt.Errorf("failed to convert args: %w", err)
+			}
+			return nil
+		},
+		needed: func(s *FactStoreDB) (bool, error) {
+			// decodeArgs falls back to JSON text, which SQLite's JSON
+			// functions accept too.
+			return false, nil
+		},
+	},
+	{
+		description: "move binary args to args_bin and compute their JSON keys as args",
+		up: func(s *FactStoreDB, tx *sql.Tx) error {
+			if s.codec.column() != "args_bin" {
+				return nil
+			}
+			stmts := []string{
+				"ALTER TABLE facts RENAME COLUMN args TO args_bin",
+				"ALTER TABLE facts ADD COLUMN " + s.dialect.binaryArgsColumnSQL(),
+			}
+			var quarantine int
+			if err := tx.QueryRow(s.dialect.tableExistsSQL(), "facts_quarantine").Scan(&quarantine); err != nil {
+				return fmt.Errorf("failed to look up quarantine table: %w", err)
+			}
+			if quarantine > 0 {
+				// Quarantined rows keep the stored column only.
+				stmts = append(stmts, "ALTER TABLE facts_quarantine RENAME COLUMN args TO args_bin")
+			}
+			for _, stmt := range stmts {
+				if _, err := tx.Exec(stmt); err != nil {
+					return fmt.Errorf("failed to move binary args: %w", err)
+				}
+			}
+			return nil
+		},
+		needed: func(s *FactStoreDB) (bool, error) {
+			return s.codec.column() == "args_bin", nil
+		},
+	},
+	{
+		description: "wrap stored strings that look like names or bytes in fn:string",
+		up: func(s *FactStoreDB, tx *sql.Tx) error {
+			if !s.mayHoldBareStrings() {
+				return nil
+			}
+			rows, err := bareStringRows(tx, s.dialect, false)
+			if err != nil {
+				return err
+			}
+			update := "UPDATE facts SET args = " + s.argsValueSQL(s.dialect.placeholder(1)) +
+				" WHERE atom_hash = " + s.dialect.placeholder(2)
+			for _, row := range rows {
+				if _, err := tx.Exec(update, row.args, row.atomHash); err != nil {
+					return fmt.Errorf("failed to rewrite args of row %d: %w", row.atomHash, err)
+				}
+			}
+			return nil
+		},
+		needed: func(s *FactStoreDB) (bool, error) {
+			if !s.mayHoldBareStrings() {
+				return false, nil
+			}
+			rows, err := bareStringRows(s.db, s.dialect, true)
+			return len(rows) > 0, err
+		},
+	},
+}
+
+// mayHoldBareStrings reports whether the store may hold strings that look
+// like names or bytes as plain JSON strings, as written before fn:string.
+// Binary args and dictionaries came later.
+func (s *FactStoreDB) mayHoldBareStrings() bool {
+	return s.codec.column() == "args" && s.dict == nil
+}
+
+// queryer is implemented by *sql.DB and *sql.Tx.
+type queryer interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+}
+
+// bareStringRows returns the rows of the facts table that hold strings
+// looking like names or bytes as plain JSON strings, with their args
+// rewritten to wrap those strings in fn:string. Such strings read back as
+// names or bytes; the row is told apart by its atom_hash, which was computed
+// over the strings. With first, it stops at the first such row. Rows that do
+// not decode, or whose hash matches no reading, are left to Verify.
+func bareStringRows(q queryer, d dialect, first bool) ([]factRow, error) {
+	rows, err := q.Query("SELECT predicate, atom_hash, " + d.argsTextSQL() + " FROM facts")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read args: %w", err)
+	}
+	defer rows.Close()
+	var out []factRow
+	var predicate string
+	var hash int64
+	var args []byte
+	for rows.Next() {
+		if err := rows.Scan(&predicate, &hash, &args); err != nil {
+			return nil, fmt.Errorf("failed to scan args: %w", err)
+		}
+		pred, err := keyToPredicate(predicate)
+		if err != nil {
+			continue
+		}
+		wrapped, ok, err := wrapBareStrings(pred, args, hash)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			out = append(out, factRow{predicate, hash, wrapped})
+			if first {
+				break
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read args: %w", err)
+	}
+	return out, nil
+}
+
+// maxBareStrings is the largest number of strings looking like names or
+// bytes in a row that wrapBareStrings tries the readings of.
+const maxBareStrings = 8
+
+// wrapBareStrings returns the JSON text of args with the strings that look
+// like names or bytes wrapped in fn:string, as far as stored is the hash of
+// the fact with those strings. It returns false if args already hash to
+// stored as they are, or if no such reading does.
+func wrapBareStrings(pred ast.PredicateSym, args []byte, stored int64) ([]byte, bool, error) {
+	if fact, err := unmarshalArgs(pred, args); err == nil {
+		if hash, err := atomHash(fact); err == nil && hash == stored {
+			return nil, false, nil
+		}
+	}
+
+	// Collect the tokens of args and the positions of the strings that
+	// read back as names or bytes; object names are fn: symbols.
+	dec := jsontext.NewDecoder(bytes.NewReader(args))
+	var tokens []jsontext.Token
+	var bare []int
+	for {
+		tok, err := dec.ReadToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, false, nil
+		}
+		if tok.Kind() == '"' {
+			kind, length := dec.StackIndex(dec.StackDepth())
+			if !(kind == '{' && length%2 == 1) && isAmbiguousString(tok.String()) {
+				bare = append(bare, len(tokens))
+			}
+		}
+		tokens = append(tokens, tok.Clone())
+	}
+	if len(bare) == 0 || len(bare) > maxBareStrings {
+		return nil, false, nil
+	}
+
+	// Try the readings in which some of these are strings. The first one
+	// takes them all as stored.
+	for mask := range 1 << len(bare) {
+		var buf bytes.Buffer
+		enc := jsontext.NewEncoder(&buf)
+		next := 0
+		for i, tok := range tokens {
+			wrap := next < len(bare) && bare[next] == i
+			if wrap {
+				wrap = mask&(1<<next) != 0
+				next++
+			}
+			if wrap {
+				for _, t := range []jsontext.Token{jsontext.BeginObject, jsontext.String(fnString), jsontext.BeginArray, tok, jsontext.EndArray, jsontext.EndObject} {
+					if err := enc.WriteToken(t); err != nil {
+						return nil, false, err
+					}
+				}
+			} else if err := enc.WriteToken(tok); err != nil {
+				return nil, false, err
+			}
+		}
+		fact, err := unmarshalArgs(pred, buf.Bytes())
+		if err != nil {
+			continue
+		}
+		hash, err := atomHash(fact)
+		if err != nil {
+			continue
+		}
+		match := hash == stored
+		if !match {
+			// The JSON codec also stores integral floats as numbers.
+			if match, _, _, err = floatVariantMatches(fact, stored); err != nil {
+				return nil, false, err
+			}
+		}
+		if match {
+			return buf.Bytes(), mask != 0, nil
+		}
+	}
+	return nil, false, nil
+}
+
+// schemaVersion returns the schema version of the store without changing
+// the database, and whether the store existed before it was opened.
+func (s *FactStoreDB) schemaVersion() (int, bool, error) {
+	existed, err := s.tableExists("facts")
+	if err != nil {
+		return 0, false, err
+	}
+	hasMeta, err := s.tableExists("factstore_meta")
+	if err != nil {
+		return 0, false, err
+	}
+	recorded := ""
+	if hasMeta {
+		if recorded, err = s.metadata(metaSchemaVersionKey); err != nil {
+			return 0, false, err
+		}
+	}
+	switch {
+	case recorded != "":
+		version, err := strconv.Atoi(recorded)
+		if err != nil {
+			return 0, false, fmt.Errorf("invalid schema version %q: %w", recorded, err)
+		}
+		if version > len(migrations) {
+			return 0, false, fmt.Errorf("%w: the store has schema version %d, but this version of factstoredb supports up to %d; upgrade factstoredb to open it",
+				ErrSchemaTooNew, version, len(migrations))
+		}
+		return version, existed, nil
+	case existed:
+		return 1, true, nil
+	default:
+		return 0, false, nil
+	}
+}
+
+// pendingMigrationsError returns the error reporting the migrations from version on.
+func pendingMigrationsError(version int) error {
+	descriptions := make([]string, 0, len(migrations)-version)
+	for i := version; i < len(migrations); i++ {
+		descriptions = append(descriptions, strconv.Itoa(i+1)+": "+migrations[i].description)
+	}
+	return fmt.Errorf("%w from version %d to %d: %s", ErrMigrationsPending, version, len(migrations), strings.Join(descriptions, "; "))
+}
+
+// checkPendingMigrations returns the error reporting the migrations from
+// version on if any of them is needed.
+func (s *FactStoreDB) checkPendingMigrations(version int) error {
+	for i := version; i < len(migrations); i++ {
+		if migrations[i].needed == nil {
+			return pendingMigrationsError(version)
+		}
+		needed, err := migrations[i].needed(s)
+		if err != nil {
+			return fmt.Errorf("migration %d (%s): %w", i+1, migrations[i].description, err)
+		}
+		if needed {
+			return pendingMigrationsError(version)
+		}
+	}
+	return nil
+}
+
+// migrate applies the migrations after version and records the new version.
+func (s *FactStoreDB) migrate(version int) error {
+	for i := version; i < len(migrations); i++ {
+		tx, err := s.db.Begin()
+		if err != nil {
+			return fmt.Errorf("failed to begin migration %d: %w", i+1, err)
+		}
+		if err := migrations[i].up(s, tx); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d (%s): %w", i+1, migrations[i].description, err)
+		}
+		if err := s.writeMetadata(tx, metaSchemaVersionKey, strconv.Itoa(i+1)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("failed to commit migration %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+package factstoredb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/google/mangle/ast"
+)
+
+func TestSchemaVersion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "facts.db")
+	store, err := NewFactStoreSQLite(path)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	for key, want := range map[string]string{
+		metaSchemaVersionKey: strconv.Itoa(len(migrations)),
+		metaHashKey:          hashAlgorithm,
+		metaCodecKey:         string(CodecJSON),
+	} {
+		if got, err := store.metadata(key); err != nil || got != want {
+			t.Errorf("metadata(%q) = %q, %v, want %q", key, got, err, want)
+		}
+	}
+	store.Add(evalAtom("p(/a)"))
+	store.Close()
+
+	// An up-to-date store opens in a dry run.
+	store, err = NewFactStoreSQLite(path, WithMigrationDryRun())
+	if err != nil {
+		t.Fatalf("Failed to reopen store in a dry run: %v", err)
+	}
+	store.Close()
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("UPDATE factstore_meta SET value = '99' WHERE key = ?", metaSchemaVersionKey); err != nil {
+		t.Fatalf("Failed to update schema version: %v", err)
+	}
+	if _, err := NewFactStoreSQLite(path); !errors.Is(err, ErrSchemaTooNew) {
+		t.Errorf("opening a store with a newer schema: error = %v, want ErrSchemaTooNew", err)
+	}
+
+	if _, err := db.Exec("UPDATE factstore_meta SET value = CASE key WHEN ? THEN ? ELSE 'sha256' END WHERE key IN (?, ?)",
+		metaSchemaVersionKey, strconv.Itoa(len(migrations)), metaSchemaVersionKey, metaHashKey); err != nil {
+		t.Fatalf("Failed to update metadata: %v", err)
+	}
+	if _, err := NewFactStoreSQLite(path); err == nil {
+		t.Error("opening a store with another hash algorithm succeeded, want error")
+	}
+}
+
+func TestMigrateLegacyStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "facts.db")
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	// Stores written before schema versioning have no metadata and may hold
+	// args as JSON text.
+	if _, err := db.Exec(sqliteDialect{}.createTableSQL("BLOB")); err != nil {
+		t.Fatalf("Failed to create facts table: %v", err)
+	}
+	facts := []ast.Atom{
+		evalAtom(`legacy(/a, "text", [10, 2])`),
+		evalAtom(`legacy(/b, 1.5, {/k: /v})`),
+	}
+	for _, fact := range facts {
+		hash, argsJSON, err := atomToRowForInsertBinary(fact)
+		if err != nil {
+			t.Fatalf("atomToRowForInsertBinary() error = %v", err)
+		}
+		if _, err := db.Exec("INSERT INTO facts (predicate, atom_hash, args) VALUES (?, ?, ?)",
+			predicateToKey(fact.Predicate), hash, argsJSON); err != nil {
+			t.Fatalf("insert error = %v", err)
+		}
+	}
+
+	// A dry run reports the pending migrations and leaves the database alone.
+	_, err = NewFactStoreSQLite(path, WithMigrationDryRun())
+	if !errors.Is(err, ErrMigrationsPending) {
+		t.Fatalf("dry run error = %v, want ErrMigrationsPending", err)
+	}
+	var tables int
+	if err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name = 'factstore_meta'").Scan(&tables); err != nil || tables != 0 {
+		t.Errorf("dry run created the metadata table (%d, %v)", tables, err)
+	}
+
+	store, err := NewFactStoreSQLite(path)
+	if err != nil {
+		t.Fatalf("Failed to migrate store: %v", err)
+	}
+	defer store.Close()
+	if version, err := store.metadata(metaSchemaVersionKey); err != nil || version != strconv.Itoa(len(migrations)) {
+		t.Errorf("schema version = %q, %v, want %d", version, err, len(migrations))
+	}
+	var text int
+	if err := db.QueryRow("SELECT COUNT(*) FROM facts WHERE NOT json_valid(args, 8)").Scan(&text); err != nil || text != 0 {
+		t.Errorf("%d rows still hold JSON text (%v), want 0", text, err)
+	}
+	for _, fact := range facts {
+		if !store.Contains(fact) {
+			t.Errorf("Contains(%v) = false after migration", fact)
+		}
+	}
+	n := 0
+	if err := store.GetFacts(atom("legacy(/a, X, Y)"), func(a ast.Atom) error {
+		if !a.Equals(facts[0]) {
+			t.Errorf("GetFacts() = %v, want %v", a, facts[0])
+		}
+		n++
+		return nil
+	}); err != nil || n != 1 {
+		t.Errorf("GetFacts(legacy(/a, X, Y)) = %d facts, %v, want 1", n, err)
+	}
+}
+
+func TestMigrateBareStrings(t *testing.T) {
+	// The first release stored strings that look like names or bytes as
+	// plain JSON strings, like names and bytes.
+	path, _ := copyFixture(t, "baseline_strings.db")
+	nameLike := ast.String("/looks/like/a/name")
+	facts := []ast.Atom{
+		ast.NewAtom("note", name("/a"), name("/looks/like/a/name")),
+		ast.NewAtom("note", name("/b"), nameLike),
+		ast.NewAtom("note", name("/c"), ast.String(`b"not bytes"`)),
+	}
+	// Elements of lists hash without their type, so the string "/x" in
+	// tags(/d, ["/x", /x], "plain") cannot be told apart and keeps reading
+	// as a name.
+	tags := ast.NewAtom("tags", name("/d"), ast.List([]ast.Constant{name("/x"), name("/x")}), ast.String("plain"))
+
+	// Read as they are, the strings would be names and bytes.
+	if _, err := NewFactStoreSQLiteReadOnly(path); !errors.Is(err, ErrMigrationsPending) {
+		t.Errorf("NewFactStoreSQLiteReadOnly() of a store with bare strings: error = %v, want ErrMigrationsPending", err)
+	}
+
+	store, err := NewFactStoreSQLite(path)
+	if err != nil {
+		t.Fatalf("Failed to migrate store: %v", err)
+	}
+	for _, fact := range facts {
+		var got []ast.Atom
+		if err := store.GetFacts(fact, func(a ast.Atom) error {
+			got = append(got, a)
+			return nil
+		}); err != nil || len(got) != 1 || !got[0].Equals(fact) {
+			t.Errorf("GetFacts(%v) after migration = %v, %v, want [%v]", fact, got, err, fact)
+		}
+	}
+	var got []ast.Atom
+	if err := store.GetFacts(atom("tags(X, Y, Z)"), func(a ast.Atom) error {
+		got = append(got, a)
+		return nil
+	}); err != nil || len(got) != 1 || !got[0].Equals(tags) {
+		t.Errorf("GetFacts(tags(X, Y, Z)) after migration = %v, %v, want [%v]", got, err, tags)
+	}
+	got = nil
+	if err := store.Query(atom("note(X, Y)"), func(a ast.Atom) error {
+		got = append(got, a)
+		return nil
+	}, Where(HasNamePrefix(1, "/looks/"))); err != nil || len(got) != 1 || !got[0].Equals(facts[0]) {
+		t.Errorf("Query() with HasNamePrefix after migration = %v, %v, want [%v]", got, err, facts[0])
+	}
+	if report, err := store.Verify(context.Background()); err != nil || !report.OK() {
+		t.Errorf("Verify() after migration = %+v, %v, want no issues", report, err)
+	}
+	store.Close()
+
+	ro, err := NewFactStoreSQLiteReadOnly(path)
+	if err != nil {
+		t.Fatalf("NewFactStoreSQLiteReadOnly() after migration error = %v", err)
+	}
+	ro.Close()
+}
@@ -0,0 +1,227 @@
+package factstoredb
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/google/mangle/ast"
+	"github.com/google/mangle/factstore"
+)
+
+// OverlayStore is a copy-on-write fact store: a read-only base, such as a
+// shared SQLite bundle opened with NewFactStoreSQLiteReadOnly, overlaid with
+// a small writable delta, such as an in-memory store. The base is never
+// written. The delta holds the facts added on top of the base and tombstones
+// for the facts of the base that were removed; reads present the base
+// without its removed facts plus the added ones.
+//
+// Writes are serialized by the overlay. Reads do not wait for them, so a read
+// running alongside a write may or may not observe it.
+type OverlayStore struct {
+	base  factstore.ReadOnlyFactStore
+	delta *FactStoreDB
+	// mu serializes writes, which check the base before writing the delta.
+	mu sync.Mutex
+}
+
+// Verify that OverlayStore implements the FactStoreWithRemove interface
+var _ factstore.FactStoreWithRemove = (*OverlayStore)(nil)
+
+// tombstonePrefix starts the predicate symbol of the tombstones in the delta
+// of an OverlayStore. The tombstone of a fact of the base is the fact with
+// its symbol prefixed, so that it is stored and matched like the fact.
+const tombstonePrefix = "factstore_tombstone:"
+
+// tombstone returns the tombstone of atom, which may be a pattern.
+func tombstone(atom ast.Atom) ast.Atom {
+	atom.Predicate.Symbol = tombstonePrefix + atom.Predicate.Symbol
+	return atom
+}
+
+// isTombstone reports whether pred is the predicate of tombstones.
+func isTombstone(pred ast.PredicateSym) bool {
+	return strings.HasPrefix(pred.Symbol, tombstonePrefix)
+}
+
+// NewOverlayStore returns a store presenting base overlaid with delta. The
+// delta may hold the additions and tombstones of an earlier overlay of the
+// same base, for example a store on disk; otherwise it should be empty. The
+// overlay does not own either store: closing them is up to the caller.
+func NewOverlayStore(base factstore.ReadOnlyFactStore, delta *FactStoreDB) *OverlayStore {
+	return &OverlayStore{base: base, delta: delta}
+}
+
+// Add adds a fact to the delta and returns true if it was not in the
+// overlay. A removed fact of the base is added back by dropping its
+// tombstone.
+func (o *OverlayStore) Add(atom ast.Atom) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.base.Contains(atom) {
+		return o.delta.Remove(tombstone(atom))
+	}
+	return o.delta.Add(atom)
+}
+
+// Remove removes a fact and returns true if it was in the overlay. Facts of
+// the base are removed by recording a tombstone in the delta.
+func (o *OverlayStore) Remove(atom ast.Atom) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.base.Contains(atom) {
+		return o.delta.Add(tombstone(atom))
+	}
+	return o.delta.Remove(atom)
+}
+
+// Merge adds all facts of other to the overlay.
+func (o *OverlayStore) Merge(other factstore.ReadOnlyFactStore) {
+	for _, pred := range other.ListPredicates() {
+		if err := other.GetFacts(ast.NewQuery(pred), func(atom ast.Atom) error {
+			o.Add(atom)
+			return nil
+		}); err != nil {
+			log.Printf("OverlayStore failed to merge facts of %v: %v", pred, err)
+		}
+	}
+}
+
+// Contains returns true if given atom is present in the overlay.
+func (o *OverlayStore) Contains(atom ast.Atom) bool {
+	if o.base.Contains(atom) {
+		return !o.delta.Contains(tombstone(atom))
+	}
+	return o.delta.Contains(atom)
+}
+
+// GetFacts returns a stream of facts that match a given atom: those of the
+// base that were not removed, then those added to the delta.
+func (o *OverlayStore) GetFacts(pattern ast.Atom, callback func(ast.Atom) error) error {
+	removed, err := o.removed(pattern)
+	if err != nil {
+		return err
+	}
+	if err := o.base.GetFacts(pattern, func(atom ast.Atom) error {
+		if len(removed) > 0 {
+			hash, err := atomHash(atom)
+			if err != nil {
+				return err
+			}
+			if removed[hash] {
+				return nil
+			}
+		}
+		return callback(atom)
+	}); err != nil {
+		return err
+	}
+	return o.delta.GetFacts(pattern, callback)
+}
+
+// removed returns the hashes of the facts of the base matching pattern that
+// were removed.
+func (o *OverlayStore) removed(pattern ast.Atom) (map[int64]bool, error) {
+	removed := make(map[int64]bool)
+	err := o.delta.GetFacts(tombstone(pattern), func(t ast.Atom) error {
+		t.Predicate = pattern.Predicate
+		hash, err := atomHash(t)
+		if err != nil {
+			return err
+		}
+		removed[hash] = true
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tombstones: %w", err)
+	}
+	return removed, nil
+}
+
+// ListPredicates lists the predicates of the base and of the facts added to
+// the delta. A predicate of the base whose facts were all removed is listed.
+func (o *OverlayStore) ListPredicates() []ast.PredicateSym {
+	predicates := o.base.ListPredicates()
+	seen := make(map[ast.PredicateSym]bool, len(predicates))
+	for _, pred := range predicates {
+		seen[pred] = true
+	}
+	for _, pred := range o.delta.ListPredicates() {
+		if !isTombstone(pred) && !seen[pred] {
+			seen[pred] = true
+			predicates = append(predicates, pred)
+		}
+	}
+	return predicates
+}
+
+// EstimateFactCount returns the estimated number of facts in the overlay:
+// those of the base, less the removed ones, plus the added ones.
+func (o *OverlayStore) EstimateFactCount() int {
+	tombstones := 0
+	for _, pred := range o.delta.ListPredicates() {
+		if !isTombstone(pred) {
+			continue
+		}
+		if err := o.delta.GetFacts(ast.NewQuery(pred), func(ast.Atom) error {
+			tombstones++
+			return nil
+		}); err != nil {
+			log.Printf("OverlayStore failed to count tombstones of %v: %v", pred, err)
+		}
+	}
+	// The delta holds the added facts and the tombstones.
+	return o.base.EstimateFactCount() + o.delta.EstimateFactCount() - 2*tombstones
+}
+
+// Flatten returns a new store holding the facts of the overlay, the delta
+// folded into a copy of the base. A base that is a FactStoreDB or a
+// ReadOnlyFactStoreDB is copied with Clone; other bases are merged into an
+// in-memory SQLite store. The overlay, its base and its delta are unchanged;
+// the returned store can serve as the base of a new overlay with an empty
+// delta. Closing it is up to the caller.
+func (o *OverlayStore) Flatten(ctx context.Context) (*FactStoreDB, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	var flat *FactStoreDB
+	var err error
+	switch base := o.base.(type) {
+	case *FactStoreDB:
+		flat, err = base.Clone(ctx)
+	case *ReadOnlyFactStoreDB:
+		flat, err = base.s.Clone(ctx)
+	default:
+		flat, err = NewFactStoreSQLite(":memory:")
+		if err == nil {
+			flat.Merge(o.base)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy base: %w", err)
+	}
+
+	var added []ast.Atom
+	for _, pred := range o.delta.ListPredicates() {
+		err := o.delta.GetFacts(ast.NewQuery(pred), func(atom ast.Atom) error {
+			if isTombstone(pred) {
+				atom.Predicate.Symbol = strings.TrimPrefix(atom.Predicate.Symbol, tombstonePrefix)
+				flat.Remove(atom)
+				return nil
+			}
+			added = append(added, atom)
+			return nil
+		})
+		if err != nil {
+			flat.Close()
+			return nil, fmt.Errorf("failed to read delta: %w", err)
+		}
+	}
+	if err := flat.batchInsertFacts(added); err != nil {
+		flat.Close()
+		return nil, err
+	}
+	return flat, nil
+}
@@ -0,0 +1,110 @@
+package factstoredb
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/mangle/ast"
+)
+
+func TestOverlayStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bundle.db")
+	store, err := NewFactStoreSQLite(path)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	baseFacts := []ast.Atom{
+		evalAtom("edge(/a, /b)"),
+		evalAtom("edge(/b, /c)"),
+		evalAtom(`label(/a, "start")`),
+	}
+	for _, fact := range baseFacts {
+		store.Add(fact)
+	}
+	store.Close()
+
+	base, err := NewFactStoreSQLiteReadOnly(path)
+	if err != nil {
+		t.Fatalf("NewFactStoreSQLiteReadOnly() error = %v", err)
+	}
+	defer base.Close()
+	delta, err := NewFactStoreSQLite(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer delta.Close()
+	overlay := NewOverlayStore(base, delta)
+
+	if overlay.Add(baseFacts[0]) {
+		t.Errorf("Add(%v) of a fact of the base = true, want false", baseFacts[0])
+	}
+	if !overlay.Add(evalAtom("edge(/c, /d)")) {
+		t.Error("Add(edge(/c, /d)) = false, want true")
+	}
+	if !overlay.Remove(baseFacts[1]) {
+		t.Errorf("Remove(%v) = false, want true", baseFacts[1])
+	}
+	if overlay.Remove(baseFacts[1]) {
+		t.Errorf("second Remove(%v) = true, want false", baseFacts[1])
+	}
+	if overlay.Contains(baseFacts[1]) {
+		t.Errorf("Contains(%v) = true after Remove", baseFacts[1])
+	}
+	if !base.Contains(baseFacts[1]) {
+		t.Errorf("Remove(%v) changed the base", baseFacts[1])
+	}
+
+	var got []string
+	if err := overlay.GetFacts(atom("edge(X, Y)"), func(a ast.Atom) error {
+		got = append(got, a.String())
+		return nil
+	}); err != nil {
+		t.Fatalf("GetFacts() error = %v", err)
+	}
+	want := []string{"edge(/a,/b)", "edge(/c,/d)"}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("GetFacts(edge(X, Y)) = %v, want %v", got, want)
+	}
+	if got := overlay.EstimateFactCount(); got != 3 {
+		t.Errorf("EstimateFactCount() = %d, want 3", got)
+	}
+	if got := len(overlay.ListPredicates()); got != 2 {
+		t.Errorf("ListPredicates() = %v, want edge and label", overlay.ListPredicates())
+	}
+
+	// Removing a fact of the delta deletes it; adding back a removed fact of
+	// the base lifts its tombstone.
+	if !overlay.Remove(evalAtom("edge(/c, /d)")) || overlay.Contains(evalAtom("edge(/c, /d)")) {
+		t.Error("Remove(edge(/c, /d)) of a fact of the delta did not remove it")
+	}
+	if !overlay.Add(baseFacts[1]) || !overlay.Contains(baseFacts[1]) {
+		t.Errorf("Add(%v) of a removed fact of the base did not add it back", baseFacts[1])
+	}
+	if got := delta.EstimateFactCount(); got != 0 {
+		t.Errorf("delta holds %d facts, want 0", got)
+	}
+
+	overlay.Remove(baseFacts[2])
+	overlay.Add(evalAtom(`label(/d, "end")`))
+	flat, err := overlay.Flatten(context.Background())
+	if err != nil {
+		t.Fatalf("Flatten() error = %v", err)
+	}
+	defer flat.Close()
+	wantFacts := []ast.Atom{baseFacts[0], baseFacts[1], evalAtom(`label(/d, "end")`)}
+	for _, fact := range wantFacts {
+		if !flat.Contains(fact) {
+			t.Errorf("flattened store does not contain %v", fact)
+		}
+	}
+	if flat.Contains(baseFacts[2]) {
+		t.Errorf("flattened store contains removed fact %v", baseFacts[2])
+	}
+	if got := flat.EstimateFactCount(); got != len(wantFacts) {
+		t.Errorf("EstimateFactCount() of flattened store = %d, want %d", got, len(wantFacts))
+	}
+	if !base.Contains(baseFacts[2]) {
+		t.Error("Flatten() changed the base")
+	}
+}
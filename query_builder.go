@@ -0,0 +1,134 @@
+package factstoredb
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/go-json-experiment/json/jsontext"
+	"github.com/google/mangle/ast"
+)
+
+// factsQuery accumulates the SQL text and bind parameters of a query over the
+// facts table. It keeps placeholder numbering consistent across dialects.
+type factsQuery struct {
+	dialect dialect
+	// dict translates interned constants to references; nil if the store does not intern.
+	dict *dictionary
+	// typed holds the typed predicates of the store, which the facts table does not hold.
+	typed  map[ast.PredicateSym]*typedTable
+	sql    strings.Builder
+	params []any
+}
+
+// newFactsQuery returns an empty query for the given dialect.
+func newFactsQuery(d dialect) *factsQuery {
+	return &factsQuery{dialect: d}
+}
+
+// bind appends a parameter and returns the placeholder that refers to it.
+func (q *factsQuery) bind(v any) string {
+	q.params = append(q.params, v)
+	return q.dialect.placeholder(len(q.params))
+}
+
+// writePatternFilters appends one equality filter for each constant argument of pattern.
+// The query must already contain a WHERE clause.
+func (q *factsQuery) writePatternFilters(pattern ast.Atom) error {
+	for i, arg := range pattern.Args {
+		constant, ok := arg.(ast.Constant)
+		if !ok {
+			// Variables are wildcards.
+			continue
+		}
+		jsonStr, err := q.argJSON(constant)
+		if err != nil {
+			return fmt.Errorf("failed to marshal pattern arg: %w", err)
+		}
+		q.sql.WriteString(q.dialect.getFactsFragment(i, &q.params))
+		q.params = append(q.params, q.dialect.jsonParam(jsonStr))
+	}
+	return nil
+}
+
+// argJSON returns the JSON a top-level argument equal to c is stored as.
+func (q *factsQuery) argJSON(c ast.Constant) (string, error) {
+	if q.dict == nil {
+		return marshalConstant(c)
+	}
+	return q.dict.argJSON(c)
+}
+
+// plainQueryShape returns the statement cache key of a GetFacts query over
+// pattern without options, together with the parameters that
+// writePatternFilters would bind for it. The key records the arity and the
+// bound argument positions, which determine the SQL.
+func plainQueryShape(d dialect, dict *dictionary, pattern ast.Atom) (string, []any, error) {
+	q := factsQuery{dialect: d, dict: dict}
+	key := make([]byte, 0, len(pattern.Args)+1)
+	key = append(key, 'p')
+	params := []any{predicateToKey(pattern.Predicate)}
+	for _, arg := range pattern.Args {
+		constant, ok := arg.(ast.Constant)
+		if !ok {
+			key = append(key, '_')
+			continue
+		}
+		jsonStr, err := q.argJSON(constant)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to marshal pattern arg: %w", err)
+		}
+		key = append(key, 'c')
+		params = append(params, d.jsonParam(jsonStr))
+	}
+	return string(key), params, nil
+}
+
+// patternVariables returns the named variables of pattern in order of first
+// occurrence, together with the argument position of that first occurrence.
+// The wildcard variable "_" is skipped.
+func patternVariables(pattern ast.Atom) ([]ast.Variable, []int) {
+	var vars []ast.Variable
+	var positions []int
+	seen := make(map[ast.Variable]bool)
+	for i, arg := range pattern.Args {
+		v, ok := arg.(ast.Variable)
+		if !ok || v.Symbol == "_" || seen[v] {
+			continue
+		}
+		seen[v] = true
+		vars = append(vars, v)
+		positions = append(positions, i)
+	}
+	return vars, positions
+}
+
+// writeRepeatedVariableFilters constrains repeated occurrences of the same
+// variable to hold the same value as the first occurrence.
+func (q *factsQuery) writeRepeatedVariableFilters(pattern ast.Atom) {
+	first := make(map[ast.Variable]int)
+	for i, arg := range pattern.Args {
+		v, ok := arg.(ast.Variable)
+		if !ok || v.Symbol == "_" {
+			continue
+		}
+		j, seen := first[v]
+		if !seen {
+			first[v] = i
+			continue
+		}
+		q.sql.WriteString(" AND ")
+		q.sql.WriteString(q.dialect.argJSONSQL(j))
+		q.sql.WriteString(" = ")
+		q.sql.WriteString(q.dialect.argJSONSQL(i))
+	}
+}
+
+// marshalConstant serializes a constant to JSON in the same format used for storage.
+func marshalConstant(c ast.Constant) (string, error) {
+	var buf strings.Builder
+	enc := jsontext.NewEncoder(&buf)
+	if err := (constantJSON{c}).MarshalJSONTo(enc); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
@@ -0,0 +1,376 @@
+package factstoredb
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/mangle/ast"
+)
+
+// ConstraintOp identifies the test a Constraint applies to an argument.
+type ConstraintOp int
+
+const (
+	// OpLt matches arguments less than the constraint value.
+	OpLt ConstraintOp = iota
+	// OpLe matches arguments less than or equal to the constraint value.
+	OpLe
+	// OpGt matches arguments greater than the constraint value.
+	OpGt
+	// OpGe matches arguments greater than or equal to the constraint value.
+	OpGe
+	// OpNe matches arguments not equal to the constraint value.
+	OpNe
+	// OpIn matches arguments equal to any of the constraint values.
+	OpIn
+	// OpNamePrefix matches name arguments that start with the constraint prefix.
+	OpNamePrefix
+	// OpFieldEquals matches struct arguments whose field Values[0] equals Values[1].
+	OpFieldEquals
+	// OpMapEntryEquals matches map arguments whose key Values[0] maps to Values[1].
+	OpMapEntryEquals
+	// OpListContains matches list arguments that contain Values[0] as an element.
+	OpListContains
+)
+
+// String returns the SQL-like spelling of the operator.
+func (op ConstraintOp) String() string {
+	switch op {
+	case OpLt:
+		return "<"
+	case OpLe:
+		return "<="
+	case OpGt:
+		return ">"
+	case OpGe:
+		return ">="
+	case OpNe:
+		return "!="
+	case OpIn:
+		return "IN"
+	case OpNamePrefix:
+		return "PREFIX"
+	case OpFieldEquals:
+		return "FIELD"
+	case OpMapEntryEquals:
+		return "ENTRY"
+	case OpListContains:
+		return "CONTAINS"
+	default:
+		return "ConstraintOp(" + strconv.Itoa(int(op)) + ")"
+	}
+}
+
+// Constraint restricts the value of the argument at position Index.
+// Constraints are evaluated by the database, next to the equality filters
+// derived from the constants of a query pattern.
+//
+// Ordering operators are typed by their value: a NumberType or Float64Type
+// value only matches numeric arguments and compares numerically, while a
+// StringType or NameType value only matches arguments of that same type and
+// compares by byte order. Arguments of any other type never match.
+type Constraint struct {
+	Index  int
+	Op     ConstraintOp
+	Values []ast.Constant
+	// Prefix holds the name prefix for OpNamePrefix.
+	Prefix string
+}
+
+// Lt returns a constraint matching arguments at index less than c.
+func Lt(index int, c ast.Constant) Constraint {
+	return Constraint{Index: index, Op: OpLt, Values: []ast.Constant{c}}
+}
+
+// Le returns a constraint matching arguments at index less than or equal to c.
+func Le(index int, c ast.Constant) Constraint {
+	return Constraint{Index: index, Op: OpLe, Values: []ast.Constant{c}}
+}
+
+// Gt returns a constraint matching arguments at index greater than c.
+func Gt(index int, c ast.Constant) Constraint {
+	return Constraint{Index: index, Op: OpGt, Values: []ast.Constant{c}}
+}
+
+// Ge returns a constraint matching arguments at index greater than or equal to c.
+func Ge(index int, c ast.Constant) Constraint {
+	return Constraint{Index: index, Op: OpGe, Values: []ast.Constant{c}}
+}
+
+// Ne returns a constraint matching arguments at index not equal to c.
+func Ne(index int, c ast.Constant) Constraint {
+	return Constraint{Index: index, Op: OpNe, Values: []ast.Constant{c}}
+}
+
+// In returns a constraint matching arguments at index equal to any of cs.
+// An empty set matches nothing.
+func In(index int, cs ...ast.Constant) Constraint {
+	return Constraint{Index: index, Op: OpIn, Values: cs}
+}
+
+// HasNamePrefix returns a constraint matching name arguments at index that start with prefix.
+// The prefix must start with "/", for example "/org/team/".
+func HasNamePrefix(index int, prefix string) Constraint {
+	return Constraint{Index: index, Op: OpNamePrefix, Prefix: prefix}
+}
+
+// FieldEquals returns a constraint matching struct arguments at index whose
+// field has the given value, for example FieldEquals(2, /status, /open).
+func FieldEquals(index int, field, value ast.Constant) Constraint {
+	return Constraint{Index: index, Op: OpFieldEquals, Values: []ast.Constant{field, value}}
+}
+
+// MapEntryEquals returns a constraint matching map arguments at index that map key to value.
+func MapEntryEquals(index int, key, value ast.Constant) Constraint {
+	return Constraint{Index: index, Op: OpMapEntryEquals, Values: []ast.Constant{key, value}}
+}
+
+// ListContains returns a constraint matching list arguments at index that contain elem.
+func ListContains(index int, elem ast.Constant) Constraint {
+	return Constraint{Index: index, Op: OpListContains, Values: []ast.Constant{elem}}
+}
+
+// String returns a readable form of the constraint, such as "A1 >= 18".
+func (c Constraint) String() string {
+	var sb strings.Builder
+	sb.WriteString("A")
+	sb.WriteString(strconv.Itoa(c.Index))
+	sb.WriteString(" ")
+	sb.WriteString(c.Op.String())
+	sb.WriteString(" ")
+	switch c.Op {
+	case OpNamePrefix:
+		sb.WriteString(strconv.Quote(c.Prefix))
+	case OpFieldEquals, OpMapEntryEquals:
+		for i, v := range c.Values {
+			if i > 0 {
+				sb.WriteString(" = ")
+			}
+			sb.WriteString(v.String())
+		}
+	case OpIn:
+		sb.WriteString("{")
+		for i, v := range c.Values {
+			if i > 0 {
+				sb.WriteString(", ")
+			}
+			sb.WriteString(v.String())
+		}
+		sb.WriteString("}")
+	default:
+		for _, v := range c.Values {
+			sb.WriteString(v.String())
+		}
+	}
+	return sb.String()
+}
+
+// validate checks that the constraint is well-formed for a predicate of the given arity.
+func (c Constraint) validate(arity int) error {
+	if c.Index < 0 || c.Index >= arity {
+		return fmt.Errorf("constraint %v: index out of range for arity %d", c, arity)
+	}
+	switch c.Op {
+	case OpLt, OpLe, OpGt, OpGe:
+		if len(c.Values) != 1 {
+			return fmt.Errorf("constraint %v: expected exactly one value, got %d", c, len(c.Values))
+		}
+		if _, err := orderingKind(c.Values[0]); err != nil {
+			return fmt.Errorf("constraint %v: %w", c, err)
+		}
+	case OpNe, OpListContains:
+		if len(c.Values) != 1 {
+			return fmt.Errorf("constraint %v: expected exactly one value, got %d", c, len(c.Values))
+		}
+	case OpFieldEquals, OpMapEntryEquals:
+		if len(c.Values) != 2 {
+			return fmt.Errorf("constraint %v: expected a key and a value, got %d values", c, len(c.Values))
+		}
+	case OpIn:
+	case OpNamePrefix:
+		if !strings.HasPrefix(c.Prefix, "/") {
+			return fmt.Errorf("constraint %v: name prefix must start with \"/\"", c)
+		}
+	default:
+		return fmt.Errorf("constraint %v: unknown operator", c)
+	}
+	return nil
+}
+
+// errUnorderedType is returned for ordering constraints on types without an order.
+var errUnorderedType = errors.New("ordering is only supported for numbers, floats, strings and names")
+
+// errInterned is returned for filters and orderings that compare the
+// contents of strings or names, which interned stores only hold as ids.
+var errInterned = errors.New("comparing string or name contents is not supported by stores with interning")
+
+// orderingKind returns the argument kind an ordering comparison with c applies to.
+func orderingKind(c ast.Constant) (argKind, error) {
+	switch c.Type {
+	case ast.NumberType, ast.Float64Type:
+		return argNumber, nil
+	case ast.StringType:
+		return argString, nil
+	case ast.NameType:
+		return argName, nil
+	default:
+		return argAny, errUnorderedType
+	}
+}
+
+// orderingParam returns the SQL parameter for an ordering comparison with c.
+func orderingParam(c ast.Constant) (any, error) {
+	switch c.Type {
+	case ast.NumberType:
+		return c.NumberValue()
+	case ast.Float64Type:
+		return c.Float64Value()
+	case ast.StringType:
+		return c.StringValue()
+	case ast.NameType:
+		return c.NameValue()
+	default:
+		return nil, errUnorderedType
+	}
+}
+
+// prefixSuccessor returns the smallest string that is greater than every string
+// starting with prefix, in byte order. It returns false if there is none.
+// Since UTF-8 preserves code point order, the result is computed on runes so
+// that it remains valid UTF-8.
+func prefixSuccessor(prefix string) (string, bool) {
+	runes := []rune(prefix)
+	for i := len(runes) - 1; i >= 0; i-- {
+		r := runes[i] + 1
+		if r >= 0xD800 && r <= 0xDFFF {
+			r = 0xE000 // Skip the surrogate range.
+		}
+		if r <= utf8.MaxRune {
+			runes[i] = r
+			return string(runes[:i+1]), true
+		}
+	}
+	return "", false
+}
+
+// writeConstraints appends a filter for each constraint. The constraints must
+// have been validated. The query must already contain a WHERE clause.
+func (q *factsQuery) writeConstraints(constraints []Constraint) error {
+	for _, c := range constraints {
+		q.sql.WriteString(" AND ")
+		if err := q.writeConstraint(c); err != nil {
+			return fmt.Errorf("constraint %v: %w", c, err)
+		}
+	}
+	return nil
+}
+
+func (q *factsQuery) writeConstraint(c Constraint) error {
+	d := q.dialect
+	switch c.Op {
+	case OpLt, OpLe, OpGt, OpGe:
+		kind, err := orderingKind(c.Values[0])
+		if err != nil {
+			return err
+		}
+		if q.dict != nil && kind != argNumber {
+			return errInterned
+		}
+		param, err := orderingParam(c.Values[0])
+		if err != nil {
+			return err
+		}
+		q.sql.WriteString("(")
+		q.sql.WriteString(d.argGuardSQL(c.Index, kind))
+		q.sql.WriteString(" AND ")
+		q.sql.WriteString(d.argValueSQL(c.Index, kind))
+		q.sql.WriteString(" ")
+		q.sql.WriteString(c.Op.String())
+		q.sql.WriteString(" ")
+		q.sql.WriteString(q.bind(param))
+		q.sql.WriteString(")")
+
+	case OpNe:
+		jsonStr, err := q.argJSON(c.Values[0])
+		if err != nil {
+			return err
+		}
+		q.sql.WriteString("NOT (")
+		q.sql.WriteString(d.argValueSQL(c.Index, argAny))
+		q.sql.WriteString(" = ")
+		q.sql.WriteString(d.jsonParamSQL(q.bind(d.jsonParam(jsonStr))))
+		q.sql.WriteString(")")
+
+	case OpIn:
+		if len(c.Values) == 0 {
+			q.sql.WriteString("1 = 0")
+			return nil
+		}
+		q.sql.WriteString(d.argValueSQL(c.Index, argAny))
+		q.sql.WriteString(" IN (")
+		for i, v := range c.Values {
+			jsonStr, err := q.argJSON(v)
+			if err != nil {
+				return err
+			}
+			if i > 0 {
+				q.sql.WriteString(", ")
+			}
+			q.sql.WriteString(d.jsonParamSQL(q.bind(d.jsonParam(jsonStr))))
+		}
+		q.sql.WriteString(")")
+
+	case OpNamePrefix:
+		if q.dict != nil {
+			return errInterned
+		}
+		// A prefix is matched as the half-open range [prefix, successor),
+		// which both backends can answer from an index on the argument.
+		q.sql.WriteString("(")
+		q.sql.WriteString(d.argGuardSQL(c.Index, argName))
+		q.sql.WriteString(" AND ")
+		q.sql.WriteString(d.argValueSQL(c.Index, argName))
+		q.sql.WriteString(" >= ")
+		q.sql.WriteString(q.bind(c.Prefix))
+		if upper, ok := prefixSuccessor(c.Prefix); ok {
+			q.sql.WriteString(" AND ")
+			q.sql.WriteString(d.argValueSQL(c.Index, argName))
+			q.sql.WriteString(" < ")
+			q.sql.WriteString(q.bind(upper))
+		}
+		q.sql.WriteString(")")
+
+	case OpFieldEquals, OpMapEntryEquals:
+		fn := fnStruct
+		if c.Op == OpMapEntryEquals {
+			fn = fnMap
+		}
+		keyJSON, err := marshalConstant(c.Values[0])
+		if err != nil {
+			return err
+		}
+		valueJSON, err := marshalConstant(c.Values[1])
+		if err != nil {
+			return err
+		}
+		keyPlaceholder := q.bind(d.jsonParam(keyJSON))
+		valuePlaceholder := q.bind(d.jsonParam(valueJSON))
+		q.sql.WriteString(d.entryEqualsSQL(c.Index, fn, keyPlaceholder, valuePlaceholder))
+
+	case OpListContains:
+		elemJSON, err := marshalConstant(c.Values[0])
+		if err != nil {
+			return err
+		}
+		// Scalars are JSON strings and numbers; lists and objects are containers.
+		scalar := elemJSON[0] != '[' && elemJSON[0] != '{'
+		q.sql.WriteString(d.listContainsSQL(c.Index, q.bind(d.jsonParam(elemJSON)), scalar))
+
+	default:
+		return errors.New("unknown operator")
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package factstoredb
+
+import (
+	"fmt"
+
+	"github.com/google/mangle/ast"
+)
+
+// NotExists keeps the facts for which no fact matches negated. Variables that
+// negated shares with the query pattern are bound to the values of the
+// candidate fact; its other variables range over all values. For example,
+// querying task(T, _) with NotExists(done(T)) yields the tasks without a done
+// fact. The condition is evaluated by the database as a NOT EXISTS subquery,
+// so the facts of negated are never loaded into memory.
+func NotExists(negated ast.Atom) QueryOption {
+	return func(c *queryConfig) {
+		c.notExists = append(c.notExists, negated)
+	}
+}
+
+// validateNegations checks the NotExists patterns.
+func (c *queryConfig) validateNegations() error {
+	for _, negated := range c.notExists {
+		for _, arg := range negated.Args {
+			switch arg.(type) {
+			case ast.Constant, ast.Variable:
+			default:
+				return fmt.Errorf("NotExists(%v): argument %v is neither a constant nor a variable", negated, arg)
+			}
+		}
+	}
+	return nil
+}
+
+// writeNotExists appends one NOT EXISTS subquery per negated pattern. The
+// outer query must select from the facts table without an alias; the
+// subquery aliases its own facts table so that unqualified columns refer to it.
+func (q *factsQuery) writeNotExists(pattern ast.Atom, negations []ast.Atom) error {
+	vars, positions := patternVariables(pattern)
+	outer := make(map[ast.Variable]int, len(vars))
+	for i, v := range vars {
+		outer[v] = positions[i]
+	}
+
+	for _, negated := range negations {
+		if q.typed[negated.Predicate] != nil {
+			return fmt.Errorf("NotExists(%v): typed predicates cannot be negated", negated)
+		}
+		q.sql.WriteString(" AND NOT EXISTS (SELECT 1 FROM facts AS negated WHERE predicate = ")
+		q.sql.WriteString(q.bind(predicateToKey(negated.Predicate)))
+		if err := q.writePatternFilters(negated); err != nil {
+			return fmt.Errorf("NotExists(%v): %w", negated, err)
+		}
+		q.writeRepeatedVariableFilters(negated)
+		for j, arg := range negated.Args {
+			v, ok := arg.(ast.Variable)
+			if !ok || v.Symbol == "_" {
+				continue
+			}
+			i, shared := outer[v]
+			if !shared {
+				continue
+			}
+			q.sql.WriteString(" AND ")
+			q.sql.WriteString(q.dialect.argJSONSQL(j))
+			q.sql.WriteString(" = ")
+			q.sql.WriteString(q.dialect.tableArgJSONSQL("facts", i))
+		}
+		q.sql.WriteString(")")
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package factstoredb
+
+import (
+	"testing"
+
+	"bitbucket.org/creachadair/stringset"
+	"github.com/google/mangle/ast"
+)
+
+// runNegationTest tests Exists and the NotExists query option.
+func runNegationTest(t *testing.T, newStore func() (*FactStoreDB, error)) {
+	store, err := newStore()
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	facts := []ast.Atom{
+		evalAtom("task(/t1, /alice)"),
+		evalAtom("task(/t2, /bob)"),
+		evalAtom("task(/t3, /alice)"),
+		evalAtom(`task("/t4", /carol)`),
+		evalAtom("done(/t1)"),
+		evalAtom(`done("/t4")`),
+		evalAtom("blocked(/t2, /t3)"),
+		evalAtom("edge(/a, /b)"),
+		evalAtom("edge(/b, /a)"),
+		evalAtom("edge(/b, /c)"),
+		evalAtom("edge(/d, /d)"),
+	}
+	for _, f := range facts {
+		store.Add(f)
+	}
+
+	t.Run("Exists", func(t *testing.T) {
+		tests := []struct {
+			pattern string
+			opts    []QueryOption
+			want    bool
+		}{
+			{pattern: "task(X, /alice)", want: true},
+			{pattern: "task(X, /dave)", want: false},
+			{pattern: "missing(X)", want: false},
+			{pattern: "done(/t1)", want: true},
+			{pattern: "done(/t4)", want: false},
+			{pattern: "task(X, Y)", opts: []QueryOption{Where(HasNamePrefix(1, "/c"))}, want: true},
+			{pattern: "task(X, /carol)", opts: []QueryOption{NotExists(atom("done(X)"))}, want: false},
+			{pattern: "task(X, X)", want: false},
+			{pattern: "edge(X, X)", want: true},
+			{pattern: "edge(X, /d)", opts: []QueryOption{NotExists(atom("edge(X, X)"))}, want: false},
+		}
+		for _, tt := range tests {
+			got, err := store.Exists(atom(tt.pattern), tt.opts...)
+			if err != nil {
+				t.Fatalf("Exists(%s) error = %v", tt.pattern, err)
+			}
+			if got != tt.want {
+				t.Errorf("Exists(%s) = %v, want %v", tt.pattern, got, tt.want)
+			}
+		}
+		if _, err := store.Exists(atom("task(X, Y)"), Limit(1)); err == nil {
+			t.Error("Exists() with Limit succeeded, want error")
+		}
+	})
+
+	tests := []struct {
+		name    string
+		pattern string
+		opts    []QueryOption
+		want    stringset.Set
+	}{
+		{
+			name:    "not done",
+			pattern: "task(T, _)",
+			opts:    []QueryOption{NotExists(atom("done(T)"))},
+			want:    stringset.New("/t2", "/t3"),
+		},
+		{
+			name:    "not done and not blocked",
+			pattern: "task(T, _)",
+			opts:    []QueryOption{NotExists(atom("done(T)")), NotExists(atom("blocked(_, T)"))},
+			want:    stringset.New("/t2"),
+		},
+		{
+			name:    "constant in negation",
+			pattern: "task(T, _)",
+			opts:    []QueryOption{NotExists(atom("blocked(/t2, T)"))},
+			want:    stringset.New("/t1", "/t2", `"/t4"`),
+		},
+		{
+			name:    "existential variable",
+			pattern: "task(T, _)",
+			opts:    []QueryOption{NotExists(atom("blocked(T, Other)"))},
+			want:    stringset.New("/t1", "/t3", `"/t4"`),
+		},
+		{
+			name:    "same predicate",
+			pattern: "edge(X, Y)",
+			opts:    []QueryOption{NotExists(atom("edge(Y, X)"))},
+			want:    stringset.New("/b"),
+		},
+		{
+			name:    "repeated variable",
+			pattern: "edge(X, _)",
+			opts:    []QueryOption{NotExists(atom("edge(X, X)"))},
+			want:    stringset.New("/a", "/b"),
+		},
+		{
+			name:    "with constraint",
+			pattern: "task(T, _)",
+			opts:    []QueryOption{Where(Ne(0, name("/t2"))), NotExists(atom("done(T)"))},
+			want:    stringset.New("/t3"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := stringset.New()
+			err := store.Query(atom(tt.pattern), func(a ast.Atom) error {
+				got.Add(a.Args[0].String())
+				return nil
+			}, tt.opts...)
+			if err != nil {
+				t.Fatalf("Query() error = %v", err)
+			}
+			if !got.Equals(tt.want) {
+				t.Errorf("Query() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("bindings", func(t *testing.T) {
+		got := stringset.New()
+		err := store.GetBindings(atom("task(T, _)"), func(subst ast.ConstSubstMap) error {
+			got.Add(subst[ast.Variable{Symbol: "T"}].String())
+			return nil
+		}, NotExists(atom("done(T)")))
+		if err != nil {
+			t.Fatalf("GetBindings() error = %v", err)
+		}
+		if want := stringset.New("/t2", "/t3"); !got.Equals(want) {
+			t.Errorf("GetBindings() = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("aggregate", func(t *testing.T) {
+		rows, err := store.Aggregate(atom("task(T, _)"), nil, []Aggregate{Count()}, NotExists(atom("done(T)")))
+		if err != nil {
+			t.Fatalf("Aggregate() error = %v", err)
+		}
+		if len(rows) != 1 || !rows[0][0].Equals(ast.Number(2)) {
+			t.Errorf("Aggregate() = %v, want [[2]]", rows)
+		}
+	})
+}
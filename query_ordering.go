@@ -0,0 +1,310 @@
+package factstoredb
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/go-json-experiment/json"
+	"github.com/google/mangle/ast"
+)
+
+// OrderKey orders query results by the argument at position Index.
+//
+// Arguments are ordered by type first: numbers, then strings, then names,
+// then all other values. Within a type, numbers compare numerically, strings
+// and names by byte order, and other values by their JSON encoding. Ties are
+// broken by atom_hash, which makes the order total and stable across pages.
+type OrderKey struct {
+	Index int
+	Desc  bool
+}
+
+// Asc returns an ascending order key on the argument at index.
+func Asc(index int) OrderKey {
+	return OrderKey{Index: index}
+}
+
+// Desc returns a descending order key on the argument at index.
+func Desc(index int) OrderKey {
+	return OrderKey{Index: index, Desc: true}
+}
+
+// OrderBy sorts query results by the given keys, in order of significance.
+func OrderBy(keys ...OrderKey) QueryOption {
+	return func(c *queryConfig) {
+		c.orderBy = append(c.orderBy, keys...)
+	}
+}
+
+// Limit returns at most n results. n must be positive.
+func Limit(n int) QueryOption {
+	return func(c *queryConfig) {
+		c.limit = n
+	}
+}
+
+// After resumes a query after the last fact of a previous page.
+// The cursor must come from Page.Next of a query with the same predicate and ordering.
+func After(cursor string) QueryOption {
+	return func(c *queryConfig) {
+		c.after = cursor
+	}
+}
+
+// Page is one page of query results.
+type Page struct {
+	Facts []ast.Atom
+	// Next is an opaque cursor for the following page, to be passed to After.
+	// It is empty when there are no more results.
+	Next string
+}
+
+// QueryPage returns one page of the facts that match pattern and satisfy the
+// given options, which must include a Limit. Without OrderBy, facts are
+// returned in atom_hash order. Pages are keyset-based: resuming with After
+// costs the same as reading the first page, and facts added or removed
+// between pages do not shift the remaining results.
+func (s *FactStoreDB) QueryPage(pattern ast.Atom, opts ...QueryOption) (Page, error) {
+	cfg, err := newQueryConfig(pattern, opts)
+	if err != nil {
+		return Page{}, err
+	}
+	if cfg.limit == 0 {
+		return Page{}, errors.New("QueryPage requires a Limit option")
+	}
+	cfg.wantCursor = true
+
+	var page Page
+	next, err := s.getFacts(pattern, cfg, func(fact ast.Atom) error {
+		page.Facts = append(page.Facts, fact)
+		return nil
+	})
+	if err != nil {
+		return Page{}, err
+	}
+	page.Next = next
+	return page, nil
+}
+
+// validateOrdering checks the ordering options against a predicate of the given arity.
+func (c *queryConfig) validateOrdering(arity int) error {
+	for _, k := range c.orderBy {
+		if k.Index < 0 || k.Index >= arity {
+			return fmt.Errorf("order key %d: index out of range for arity %d", k.Index, arity)
+		}
+	}
+	if c.limit < 0 {
+		return fmt.Errorf("invalid limit %d", c.limit)
+	}
+	return nil
+}
+
+// ordered reports whether the query needs an ORDER BY clause.
+func (c *queryConfig) ordered() bool {
+	return len(c.orderBy) > 0 || c.limit > 0 || c.after != ""
+}
+
+// orderFingerprint identifies the predicate and ordering a cursor belongs to.
+func orderFingerprint(pattern ast.Atom, keys []OrderKey) string {
+	var sb strings.Builder
+	sb.WriteString(predicateToKey(pattern.Predicate))
+	for _, k := range keys {
+		sb.WriteString(",")
+		sb.WriteString(strconv.Itoa(k.Index))
+		if k.Desc {
+			sb.WriteString("d")
+		}
+	}
+	return sb.String()
+}
+
+// sortExprs returns the SQL expressions that implement the collation of the
+// argument at index: a type rank, the numeric value and the text value.
+// None of them is NULL, so they can be compared in keyset conditions.
+func sortExprs(d dialect, index int) [3]string {
+	rank := "(CASE WHEN " + d.argGuardSQL(index, argNumber) + " THEN 0" +
+		" WHEN " + d.argGuardSQL(index, argString) + " THEN 1" +
+		" WHEN " + d.argGuardSQL(index, argName) + " THEN 2 ELSE 3 END)"
+	num := "COALESCE(CASE WHEN " + d.argGuardSQL(index, argNumber) + " THEN " + d.argValueSQL(index, argNumber) + " END, 0)"
+	text := "(CASE WHEN " + d.argGuardSQL(index, argNumber) + " THEN ''" +
+		" WHEN " + d.argGuardSQL(index, argString) + " THEN " + d.argValueSQL(index, argString) +
+		" WHEN " + d.argGuardSQL(index, argName) + " THEN " + d.argValueSQL(index, argName) +
+		" ELSE " + d.argJSONSQL(index) + " END)"
+	return [3]string{rank, num, text}
+}
+
+// sortKey is one column of the total order used by ordered queries.
+type sortKey struct {
+	expr string
+	desc bool
+}
+
+// sortKeys returns the columns of the total order for keys, ending with atom_hash.
+func sortKeys(d dialect, keys []OrderKey) []sortKey {
+	var out []sortKey
+	for _, k := range keys {
+		for _, expr := range sortExprs(d, k.Index) {
+			out = append(out, sortKey{expr: expr, desc: k.Desc})
+		}
+	}
+	return append(out, sortKey{expr: "atom_hash"})
+}
+
+// writeSortColumns appends the sort keys to the select list.
+func (q *factsQuery) writeSortColumns(keys []sortKey) {
+	for _, k := range keys {
+		q.sql.WriteString(", ")
+		q.sql.WriteString(k.expr)
+	}
+}
+
+// writeAfter appends a keyset condition selecting rows that sort after the cursor.
+func (q *factsQuery) writeAfter(keys []sortKey, values []any) {
+	// (k1 > v1) OR (k1 = v1 AND k2 > v2) OR ..., with < for descending keys.
+	q.sql.WriteString(" AND (")
+	for i := range keys {
+		if i > 0 {
+			q.sql.WriteString(" OR ")
+		}
+		q.sql.WriteString("(")
+		for j := range i {
+			q.sql.WriteString(keys[j].expr)
+			q.sql.WriteString(" = ")
+			q.sql.WriteString(q.bind(values[j]))
+			q.sql.WriteString(" AND ")
+		}
+		q.sql.WriteString(keys[i].expr)
+		if keys[i].desc {
+			q.sql.WriteString(" < ")
+		} else {
+			q.sql.WriteString(" > ")
+		}
+		q.sql.WriteString(q.bind(values[i]))
+		q.sql.WriteString(")")
+	}
+	q.sql.WriteString(")")
+}
+
+// writeOrdering appends the keyset condition of the After option followed by
+// the ORDER BY and LIMIT clauses.
+func (q *factsQuery) writeOrdering(pattern ast.Atom, cfg *queryConfig) error {
+	if q.dict != nil && len(cfg.orderBy) > 0 {
+		return errInterned
+	}
+	keys := sortKeys(q.dialect, cfg.orderBy)
+	if cfg.after != "" {
+		values, err := decodeCursor(cfg.after, orderFingerprint(pattern, cfg.orderBy), len(keys))
+		if err != nil {
+			return err
+		}
+		q.writeAfter(keys, values)
+	}
+	q.writeOrderBy(keys, cfg.limit)
+	return nil
+}
+
+// writeOrderBy appends the ORDER BY and LIMIT clauses.
+func (q *factsQuery) writeOrderBy(keys []sortKey, limit int) {
+	q.sql.WriteString(" ORDER BY ")
+	for i, k := range keys {
+		if i > 0 {
+			q.sql.WriteString(", ")
+		}
+		q.sql.WriteString(k.expr)
+		if k.desc {
+			q.sql.WriteString(" DESC")
+		}
+	}
+	if limit > 0 {
+		q.sql.WriteString(" LIMIT ")
+		q.sql.WriteString(strconv.Itoa(limit))
+	}
+}
+
+// cursorState is the decoded form of a page cursor.
+type cursorState struct {
+	Order string      `json:"o"`
+	Keys  []cursorKey `json:"k"`
+}
+
+// cursorKey holds the value of one sort key. Numbers keep their integer or
+// float representation so that they bind with the right SQL type.
+type cursorKey struct {
+	Int   *int64   `json:"i,omitzero"`
+	Float *float64 `json:"f,omitzero"`
+	Text  *string  `json:"t,omitzero"`
+}
+
+// encodeCursor builds an opaque cursor from the sort key values of a row.
+func encodeCursor(fingerprint string, values []any) (string, error) {
+	state := cursorState{Order: fingerprint, Keys: make([]cursorKey, len(values))}
+	for i, v := range values {
+		switch v := v.(type) {
+		case int64:
+			state.Keys[i].Int = &v
+		case float64:
+			state.Keys[i].Float = &v
+		case string:
+			state.Keys[i].Text = &v
+		case []byte:
+			// PostgreSQL returns numeric and text values as bytes; the
+			// numeric sort key sits at position 1 of each argument triple.
+			if i%3 == 1 && i != len(values)-1 {
+				c, err := parseNumericText(string(v))
+				if err != nil {
+					return "", err
+				}
+				if c.Type == ast.NumberType {
+					n, _ := c.NumberValue()
+					state.Keys[i].Int = &n
+				} else {
+					f, _ := c.Float64Value()
+					state.Keys[i].Float = &f
+				}
+				continue
+			}
+			text := string(v)
+			state.Keys[i].Text = &text
+		default:
+			return "", fmt.Errorf("unexpected sort key type %T", v)
+		}
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// decodeCursor returns the sort key values stored in a cursor, checking that
+// it was produced for the same predicate and ordering.
+func decodeCursor(cursor, fingerprint string, numKeys int) ([]any, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor: %w", err)
+	}
+	var state cursorState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("invalid cursor: %w", err)
+	}
+	if state.Order != fingerprint || len(state.Keys) != numKeys {
+		return nil, errors.New("cursor does not belong to this query")
+	}
+	values := make([]any, len(state.Keys))
+	for i, k := range state.Keys {
+		switch {
+		case k.Int != nil:
+			values[i] = *k.Int
+		case k.Float != nil:
+			values[i] = *k.Float
+		case k.Text != nil:
+			values[i] = *k.Text
+		default:
+			return nil, errors.New("invalid cursor: empty key")
+		}
+	}
+	return values, nil
+}
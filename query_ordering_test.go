@@ -0,0 +1,192 @@
+package factstoredb
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/google/mangle/ast"
+)
+
+// runOrderingTest tests OrderBy and Limit with mixed argument types.
+func runOrderingTest(t *testing.T, newStore func() (*FactStoreDB, error)) {
+	store, err := newStore()
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	facts := []ast.Atom{
+		evalAtom("item(/a, 10)"),
+		evalAtom("item(/b, 2)"),
+		evalAtom("item(/c, 2.5)"),
+		evalAtom("item(/d, -3)"),
+		evalAtom(`item(/e, "zeta")`),
+		evalAtom(`item(/f, "alpha")`),
+		evalAtom("item(/g, /name)"),
+		evalAtom("item(/h, [1, 2])"),
+	}
+	for _, f := range facts {
+		store.Add(f)
+	}
+
+	tests := []struct {
+		name string
+		opts []QueryOption
+		want []string
+	}{
+		{
+			name: "ascending",
+			opts: []QueryOption{OrderBy(Asc(1))},
+			want: []string{"/d", "/b", "/c", "/a", "/f", "/e", "/g", "/h"},
+		},
+		{
+			name: "descending",
+			opts: []QueryOption{OrderBy(Desc(1))},
+			want: []string{"/h", "/g", "/e", "/f", "/a", "/c", "/b", "/d"},
+		},
+		{
+			name: "limit",
+			opts: []QueryOption{OrderBy(Asc(1)), Limit(3)},
+			want: []string{"/d", "/b", "/c"},
+		},
+		{
+			name: "constraint",
+			opts: []QueryOption{Where(Gt(1, ast.Number(0))), OrderBy(Desc(1))},
+			want: []string{"/a", "/c", "/b"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			err := store.Query(atom("item(X, Y)"), func(a ast.Atom) error {
+				got = append(got, a.Args[0].String())
+				return nil
+			}, tt.opts...)
+			if err != nil {
+				t.Fatalf("Query() error = %v", err)
+			}
+			if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+				t.Errorf("Query() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("bindings", func(t *testing.T) {
+		var got []string
+		err := store.GetBindings(atom("item(X, Y)"), func(subst ast.ConstSubstMap) error {
+			got = append(got, subst[ast.Variable{Symbol: "X"}].String())
+			return nil
+		}, OrderBy(Desc(1)), Limit(2))
+		if err != nil {
+			t.Fatalf("GetBindings() error = %v", err)
+		}
+		if want := "/h /g"; strings.Join(got, " ") != want {
+			t.Errorf("GetBindings() = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		noop := func(ast.Atom) error { return nil }
+		if err := store.Query(atom("item(X, Y)"), noop, OrderBy(Asc(2))); err == nil {
+			t.Error("Query() with out-of-range order key succeeded, want error")
+		}
+		if err := store.Query(atom("item(X, Y)"), noop, Limit(-1)); err == nil {
+			t.Error("Query() with negative limit succeeded, want error")
+		}
+		if _, err := store.Aggregate(atom("item(X, Y)"), nil, []Aggregate{Count()}, Limit(1)); err == nil {
+			t.Error("Aggregate() with Limit succeeded, want error")
+		}
+	})
+}
+
+// runPaginationTest tests paging through results with QueryPage and After.
+func runPaginationTest(t *testing.T, newStore func() (*FactStoreDB, error)) {
+	store, err := newStore()
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	const n = 25
+	for i := range n {
+		// Use few distinct scores so that ties are broken by atom_hash.
+		store.Add(evalAtom(fmt.Sprintf("score(/p%d, %d)", i, i%4)))
+	}
+	store.Add(evalAtom(`score(/q, "high")`))
+	store.Add(evalAtom("score(/r, 1.5)"))
+
+	orders := []struct {
+		name string
+		opts []QueryOption
+	}{
+		{name: "atom_hash"},
+		{name: "asc", opts: []QueryOption{OrderBy(Asc(1))}},
+		{name: "desc", opts: []QueryOption{OrderBy(Desc(1), Asc(0))}},
+	}
+
+	for _, o := range orders {
+		t.Run(o.name, func(t *testing.T) {
+			var want []string
+			err := store.Query(atom("score(X, Y)"), func(a ast.Atom) error {
+				want = append(want, a.String())
+				return nil
+			}, append(o.opts, Limit(100))...)
+			if err != nil {
+				t.Fatalf("Query() error = %v", err)
+			}
+			if len(want) != n+2 {
+				t.Fatalf("Query() returned %d facts, want %d", len(want), n+2)
+			}
+
+			var got []string
+			cursor := ""
+			for pages := 0; ; pages++ {
+				if pages > n {
+					t.Fatal("QueryPage() did not terminate")
+				}
+				opts := append([]QueryOption{Limit(4)}, o.opts...)
+				if cursor != "" {
+					opts = append(opts, After(cursor))
+				}
+				page, err := store.QueryPage(atom("score(X, Y)"), opts...)
+				if err != nil {
+					t.Fatalf("QueryPage() error = %v", err)
+				}
+				for _, f := range page.Facts {
+					got = append(got, f.String())
+				}
+				if page.Next == "" {
+					break
+				}
+				cursor = page.Next
+			}
+			if strings.Join(got, " ") != strings.Join(want, " ") {
+				t.Errorf("pages = %v, want %v", got, want)
+			}
+		})
+	}
+
+	t.Run("cursor mismatch", func(t *testing.T) {
+		page, err := store.QueryPage(atom("score(X, Y)"), Limit(2), OrderBy(Asc(1)))
+		if err != nil {
+			t.Fatalf("QueryPage() error = %v", err)
+		}
+		if page.Next == "" {
+			t.Fatal("QueryPage() returned no cursor")
+		}
+		if _, err := store.QueryPage(atom("score(X, Y)"), Limit(2), OrderBy(Desc(1)), After(page.Next)); err == nil {
+			t.Error("QueryPage() with cursor of another ordering succeeded, want error")
+		}
+		if _, err := store.QueryPage(atom("score(X, Y)"), Limit(2), After("not a cursor")); err == nil {
+			t.Error("QueryPage() with malformed cursor succeeded, want error")
+		}
+	})
+
+	t.Run("no limit", func(t *testing.T) {
+		if _, err := store.QueryPage(atom("score(X, Y)")); err == nil {
+			t.Error("QueryPage() without Limit succeeded, want error")
+		}
+	})
+}
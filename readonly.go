@@ -0,0 +1,212 @@
+package factstoredb
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"iter"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/google/mangle/ast"
+	"github.com/google/mangle/factstore"
+)
+
+// ReadOnlyFactStoreDB is a SQLite fact store opened with
+// NewFactStoreSQLiteReadOnly. It has the read methods of FactStoreDB only, so
+// that writes to it do not compile.
+type ReadOnlyFactStoreDB struct {
+	s *FactStoreDB
+}
+
+// Verify that ReadOnlyFactStoreDB implements the ReadOnlyFactStore interface
+var _ factstore.ReadOnlyFactStore = (*ReadOnlyFactStoreDB)(nil)
+
+// WithImmutable declares that the file opened by NewFactStoreSQLiteReadOnly
+// does not change while it is open, not even by other processes, so that
+// SQLite skips locking and change detection. Reading a file that does change
+// returns wrong results or errors. Other constructors ignore it.
+func WithImmutable() StoreOption {
+	return func(c *config) {
+		c.immutable = true
+	}
+}
+
+// readOnlyConfig returns the default config without the PRAGMAs that write
+// to the database file.
+func readOnlyConfig() *config {
+	cfg := defaultConfig()
+	for _, key := range []string{"journal_mode", "auto_vacuum", "foreign_keys"} {
+		delete(cfg.pragmas, key)
+	}
+	cfg.pragmas["query_only"] = "1"
+	return cfg
+}
+
+// NewFactStoreSQLiteReadOnly opens the SQLite fact store at path read-only,
+// for example a prebuilt bundle of facts. It neither creates tables nor
+// writes PRAGMAs or metadata; the store must exist and be at the current
+// schema version. Options that would change the store, such as a codec or a
+// typed predicate the store was not written with, are refused. PRAGMAs are
+// set on every connection; journal_mode and auto_vacuum are left alone.
+func NewFactStoreSQLiteReadOnly(path string, opts ...StoreOption) (*ReadOnlyFactStoreDB, error) {
+	cfg := readOnlyConfig()
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	db, err := sql.Open("sqlite", readOnlyConnStr(path, cfg))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open SQLite: %w", err)
+	}
+	db.SetMaxOpenConns(10)
+	db.SetMaxIdleConns(4)
+
+	store := &FactStoreDB{
+		db:       db,
+		ownsDB:   true,
+		readOnly: true,
+		dialect:  sqliteDialect{},
+		stmts:    newStmtCache(db, cfg.statementCacheSize(0)),
+		pragmas:  cfg.pragmas,
+	}
+	if err := store.initSchemaAndStatements(cfg); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to open store read-only: %w", err)
+	}
+	return &ReadOnlyFactStoreDB{s: store}, nil
+}
+
+// readOnlyConnStr returns the URI opening path read-only, with the PRAGMAs
+// of cfg run on every connection.
+func readOnlyConnStr(path string, cfg *config) string {
+	uri := path
+	if !strings.HasPrefix(path, "file:") {
+		uri = "file:" + (&url.URL{Path: path}).EscapedPath()
+	}
+	params := url.Values{}
+	params.Set("mode", "ro")
+	if cfg.immutable {
+		params.Set("immutable", "1")
+	}
+	keys := make([]string, 0, len(cfg.pragmas))
+	for key := range cfg.pragmas {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		params.Add("_pragma", key+"("+cfg.pragmas[key]+")")
+	}
+	sep := "?"
+	if strings.Contains(uri, "?") {
+		sep = "&"
+	}
+	return uri + sep + params.Encode()
+}
+
+// Contains returns true if given atom is present in the store.
+func (r *ReadOnlyFactStoreDB) Contains(atom ast.Atom) bool {
+	return r.s.Contains(atom)
+}
+
+// GetFacts returns a stream of facts that match a given atom.
+func (r *ReadOnlyFactStoreDB) GetFacts(pattern ast.Atom, callback func(ast.Atom) error) error {
+	return r.s.GetFacts(pattern, callback)
+}
+
+// ListPredicates lists predicates available in this store.
+func (r *ReadOnlyFactStoreDB) ListPredicates() []ast.PredicateSym {
+	return r.s.ListPredicates()
+}
+
+// EstimateFactCount returns the number of facts in the store.
+func (r *ReadOnlyFactStoreDB) EstimateFactCount() int {
+	return r.s.EstimateFactCount()
+}
+
+// Query is FactStoreDB.Query.
+func (r *ReadOnlyFactStoreDB) Query(pattern ast.Atom, callback func(ast.Atom) error, opts ...QueryOption) error {
+	return r.s.Query(pattern, callback, opts...)
+}
+
+// QueryPage is FactStoreDB.QueryPage.
+func (r *ReadOnlyFactStoreDB) QueryPage(pattern ast.Atom, opts ...QueryOption) (Page, error) {
+	return r.s.QueryPage(pattern, opts...)
+}
+
+// Exists is FactStoreDB.Exists.
+func (r *ReadOnlyFactStoreDB) Exists(pattern ast.Atom, opts ...QueryOption) (bool, error) {
+	return r.s.Exists(pattern, opts...)
+}
+
+// GetBindings is FactStoreDB.GetBindings.
+func (r *ReadOnlyFactStoreDB) GetBindings(pattern ast.Atom, callback func(ast.ConstSubstMap) error, opts ...QueryOption) error {
+	return r.s.GetBindings(pattern, callback, opts...)
+}
+
+// Facts is FactStoreDB.Facts.
+func (r *ReadOnlyFactStoreDB) Facts(pattern ast.Atom, opts ...QueryOption) iter.Seq2[ast.Atom, error] {
+	return r.s.Facts(pattern, opts...)
+}
+
+// All is FactStoreDB.All.
+func (r *ReadOnlyFactStoreDB) All(opts ...QueryOption) iter.Seq2[ast.Atom, error] {
+	return r.s.All(opts...)
+}
+
+// Predicates is FactStoreDB.Predicates.
+func (r *ReadOnlyFactStoreDB) Predicates() iter.Seq[ast.PredicateSym] {
+	return r.s.Predicates()
+}
+
+// Aggregate is FactStoreDB.Aggregate.
+func (r *ReadOnlyFactStoreDB) Aggregate(pattern ast.Atom, groupBy []ast.Variable, aggs []Aggregate, opts ...QueryOption) ([][]ast.Constant, error) {
+	return r.s.Aggregate(pattern, groupBy, aggs, opts...)
+}
+
+// Explain is FactStoreDB.Explain.
+func (r *ReadOnlyFactStoreDB) Explain(pattern ast.Atom, opts ...QueryOption) (Explanation, error) {
+	return r.s.Explain(pattern, opts...)
+}
+
+// Reachable is FactStoreDB.Reachable.
+func (r *ReadOnlyFactStoreDB) Reachable(pred ast.PredicateSym, from ast.Constant, maxDepth int) ([]ast.Constant, error) {
+	return r.s.Reachable(pred, from, maxDepth)
+}
+
+// Paths is FactStoreDB.Paths.
+func (r *ReadOnlyFactStoreDB) Paths(pred ast.PredicateSym, from, to ast.Constant, maxDepth int) ([][]ast.Constant, error) {
+	return r.s.Paths(pred, from, to, maxDepth)
+}
+
+// TransitiveClosure is FactStoreDB.TransitiveClosure.
+func (r *ReadOnlyFactStoreDB) TransitiveClosure(pred ast.PredicateSym, maxDepth int) ([]ast.Atom, error) {
+	return r.s.TransitiveClosure(pred, maxDepth)
+}
+
+// WriteTo writes all facts of the store to w in JSON format, as FactStoreDB.WriteTo.
+func (r *ReadOnlyFactStoreDB) WriteTo(w io.Writer) (int64, error) {
+	return r.s.WriteTo(w)
+}
+
+// Backup is FactStoreDB.Backup.
+func (r *ReadOnlyFactStoreDB) Backup(ctx context.Context, destPath string, opts ...BackupOption) error {
+	return r.s.Backup(ctx, destPath, opts...)
+}
+
+// Verify checks the rows of the facts table as FactStoreDB.Verify, without repairing them.
+func (r *ReadOnlyFactStoreDB) Verify(ctx context.Context) (VerifyReport, error) {
+	return r.s.Verify(ctx)
+}
+
+// Config returns the settings of the store. Its Durability is empty.
+func (r *ReadOnlyFactStoreDB) Config() StoreConfig {
+	return r.s.Config()
+}
+
+// Close closes the store.
+func (r *ReadOnlyFactStoreDB) Close() error {
+	return r.s.Close()
+}
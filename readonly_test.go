@@ -0,0 +1,157 @@
+package factstoredb
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/mangle/ast"
+)
+
+func TestSQLiteReadOnly(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bundle.db")
+	typedPred := ast.PredicateSym{Symbol: "edge", Arity: 2}
+	store, err := NewFactStoreSQLite(path, WithInterning(8), WithTypedPredicate(typedPred, ColumnName, ColumnName))
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	facts := []ast.Atom{
+		evalAtom(`p(/a, "a long string", [1, 2])`),
+		evalAtom("p(/b, 2.5, /c)"),
+		evalAtom("edge(/a, /b)"),
+	}
+	for _, fact := range facts {
+		store.Add(fact)
+	}
+	store.Close()
+	before, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read store: %v", err)
+	}
+
+	for _, opts := range [][]StoreOption{nil, {WithImmutable()}} {
+		ro, err := NewFactStoreSQLiteReadOnly(path, opts...)
+		if err != nil {
+			t.Fatalf("NewFactStoreSQLiteReadOnly() error = %v", err)
+		}
+		for _, fact := range facts {
+			if !ro.Contains(fact) {
+				t.Errorf("Contains(%v) = false", fact)
+			}
+		}
+		if got := ro.EstimateFactCount(); got != len(facts) {
+			t.Errorf("EstimateFactCount() = %d, want %d", got, len(facts))
+		}
+		if got := len(ro.ListPredicates()); got != 2 {
+			t.Errorf("ListPredicates() has %d predicates, want 2", got)
+		}
+		var got []ast.Atom
+		if err := ro.Query(atom("p(/a, Y, Z)"), func(a ast.Atom) error {
+			got = append(got, a)
+			return nil
+		}, Limit(1)); err != nil || len(got) != 1 || !got[0].Equals(facts[0]) {
+			t.Errorf("Query(p(/a, Y, Z), Limit(1)) = %v, %v, want [%v]", got, err, facts[0])
+		}
+		if report, err := ro.Verify(context.Background()); err != nil || !report.OK() {
+			t.Errorf("Verify() = %+v, %v, want no issues", report, err)
+		}
+		if _, err := ro.s.db.Exec("DELETE FROM facts"); err == nil {
+			t.Error("DELETE on a read-only store succeeded, want error")
+		}
+		ro.Close()
+	}
+
+	after, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read store: %v", err)
+	}
+	if !bytes.Equal(before, after) {
+		t.Error("opening the store read-only changed the file")
+	}
+
+	if _, err := NewFactStoreSQLiteReadOnly(path, WithCodec(CodecBinary)); err == nil {
+		t.Error("NewFactStoreSQLiteReadOnly() with another codec succeeded, want error")
+	}
+	if _, err := NewFactStoreSQLiteReadOnly(filepath.Join(t.TempDir(), "missing.db")); err == nil {
+		t.Error("NewFactStoreSQLiteReadOnly() of a missing file succeeded, want error")
+	}
+}
+
+// copyFixture copies the store in testdata/name to a temporary directory and
+// returns its path and contents.
+func copyFixture(t *testing.T, name string) (string, []byte) {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatalf("Failed to read fixture: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatalf("Failed to copy fixture: %v", err)
+	}
+	return path, data
+}
+
+func TestSQLiteReadOnlyLegacyStore(t *testing.T) {
+	// The bundle was written by the first release: schema version 1, no
+	// metadata table and args stored as JSON text.
+	path, before := copyFixture(t, "baseline_bundle.db")
+	facts := []ast.Atom{
+		evalAtom("edge(/a, /b)"),
+		evalAtom("edge(/b, /c)"),
+		evalAtom(`label(/a, "start")`),
+		evalAtom("reading(/s1, 42, 2.5, [1, 2])"),
+		evalAtom("config(/s1, {/k: 1, /v: [/x]})"),
+	}
+
+	// Its pending migrations are not needed to read it, so it opens
+	// read-only as it is.
+	ro, err := NewFactStoreSQLiteReadOnly(path)
+	if err != nil {
+		t.Fatalf("NewFactStoreSQLiteReadOnly() of a version 1 store error = %v", err)
+	}
+	for _, fact := range facts {
+		if !ro.Contains(fact) {
+			t.Errorf("Contains(%v) = false, want true", fact)
+		}
+		var got []ast.Atom
+		if err := ro.GetFacts(fact, func(a ast.Atom) error {
+			got = append(got, a)
+			return nil
+		}); err != nil || len(got) != 1 || !got[0].Equals(fact) {
+			t.Errorf("GetFacts(%v) = %v, %v, want the fact", fact, got, err)
+		}
+	}
+	var got []ast.Atom
+	if err := ro.Query(atom("edge(X, Y)"), func(a ast.Atom) error {
+		got = append(got, a)
+		return nil
+	}, Where(HasNamePrefix(0, "/a"))); err != nil || len(got) != 1 || !got[0].Equals(facts[0]) {
+		t.Errorf("Query(edge(X, Y)) with Where = %v, %v, want [%v]", got, err, facts[0])
+	}
+	reached, err := ro.Reachable(ast.PredicateSym{Symbol: "edge", Arity: 2}, name("/a"), 0)
+	if err != nil || len(reached) != 2 {
+		t.Errorf("Reachable(/a) = %v, %v, want /b and /c", reached, err)
+	}
+	ro.Close()
+	if after, err := os.ReadFile(path); err != nil || !bytes.Equal(before, after) {
+		t.Errorf("opening a version 1 store read-only changed the database (error = %v)", err)
+	}
+
+	// Binary codec stores at schema version 2 cannot be read before
+	// migration 3 moves their args.
+	path = filepath.Join(t.TempDir(), "binary.db")
+	store, err := NewFactStoreSQLite(path, WithCodec(CodecBinary))
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	store.Add(evalAtom("p(/a)"))
+	downgradeBinaryStore(t, store)
+	store.Close()
+	if _, err := NewFactStoreSQLiteReadOnly(path); !errors.Is(err, ErrMigrationsPending) {
+		t.Errorf("NewFactStoreSQLiteReadOnly() of a version 2 binary store: error = %v, want ErrMigrationsPending", err)
+	}
+}
@@ -0,0 +1,143 @@
+package factstoredb
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// NewFactStoreSQLiteFromBytes creates an in-memory SQLite store holding a
+// copy of the database in data, the bytes of a SQLite database file such as
+// those returned by Serialize. The store is writable and independent of data.
+// Database files of earlier versions are migrated in memory.
+//
+// Combined with embed, this compiles a bundle of facts into a binary:
+//
+//	//go:embed facts.db
+//	var factsDB []byte
+//
+//	store, err := factstoredb.NewFactStoreSQLiteFromBytes(factsDB)
+func NewFactStoreSQLiteFromBytes(data []byte, opts ...StoreOption) (*FactStoreDB, error) {
+	if !bytes.HasPrefix(data, []byte(sqliteHeader)) {
+		return nil, fmt.Errorf("data is not a SQLite database")
+	}
+	connStr := newInMemoryConnStr()
+	return openSQLite(connStr, func(db *sql.DB) error {
+		// The connection keeps the in-memory database alive while it is loaded;
+		// the pool keeps it open afterwards.
+		conn, err := db.Conn(context.Background())
+		if err != nil {
+			return fmt.Errorf("failed to connect to SQLite: %w", err)
+		}
+		defer conn.Close()
+		return loadDatabase(data, connStr)
+	}, opts)
+}
+
+// NewFactStoreSQLiteFromFS is NewFactStoreSQLiteFromBytes with the database
+// file name of fsys, for example an embed.FS.
+func NewFactStoreSQLiteFromFS(fsys fs.FS, name string, opts ...StoreOption) (*FactStoreDB, error) {
+	data, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", name, err)
+	}
+	return NewFactStoreSQLiteFromBytes(data, opts...)
+}
+
+// loadDatabase copies the database in data to the database at connStr. The
+// bytes are written to a temporary file, which is copied with the online
+// backup API; deserializing into a connection of the driver does not free
+// its memory safely on close.
+func loadDatabase(data []byte, connStr string) error {
+	dir, err := os.MkdirTemp("", "factstoredb")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "load.db")
+	if err := os.WriteFile(path, rollbackJournalHeader(data), 0o600); err != nil {
+		return fmt.Errorf("failed to write database: %w", err)
+	}
+
+	src, err := sql.Open("sqlite", path)
+	if err != nil {
+		return fmt.Errorf("failed to open SQLite: %w", err)
+	}
+	defer src.Close()
+	conn, err := src.Conn(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed to connect to SQLite: %w", err)
+	}
+	defer conn.Close()
+	return conn.Raw(func(driverConn any) error {
+		return copyDatabase(driverConn, connStr)
+	})
+}
+
+// rollbackJournalHeader returns data with the file format version bytes of
+// the header set to the rollback journal. A serialized database has no WAL,
+// and SQLite cannot read an image that claims to use one.
+func rollbackJournalHeader(data []byte) []byte {
+	if len(data) < 20 || (data[18] == 1 && data[19] == 1) {
+		return data
+	}
+	patched := make([]byte, len(data))
+	copy(patched, data)
+	patched[18], patched[19] = 1, 1
+	return patched
+}
+
+// Serialize returns the database of a SQLite store as the bytes of a database
+// file, which opens with NewFactStoreSQLiteFromBytes, or with
+// NewFactStoreSQLite once written to disk. The bytes are a consistent
+// snapshot even while other connections write. Drivers that cannot serialize
+// fall back to VACUUM INTO a temporary file. Postgres stores cannot be
+// serialized.
+func (s *FactStoreDB) Serialize() ([]byte, error) {
+	conn, err := s.db.Conn(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get connection: %w", err)
+	}
+	var data []byte
+	err = conn.Raw(func(driverConn any) error {
+		c, ok := driverConn.(interface {
+			Serialize() ([]byte, error)
+		})
+		if !ok {
+			return errors.ErrUnsupported
+		}
+		data, err = c.Serialize()
+		return err
+	})
+	conn.Close()
+	if errors.Is(err, errors.ErrUnsupported) {
+		data, err = s.serializeFile()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize store: %w", err)
+	}
+	return rollbackJournalHeader(data), nil
+}
+
+// serializeFile serializes the database through a temporary file.
+func (s *FactStoreDB) serializeFile() ([]byte, error) {
+	query := s.dialect.backupSQL()
+	if query == "" {
+		return nil, errors.New("the database cannot be serialized")
+	}
+	dir, err := os.MkdirTemp("", "factstoredb")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "store.db")
+	if _, err := s.db.Exec(query, path); err != nil {
+		return nil, err
+	}
+	return os.ReadFile(path)
+}
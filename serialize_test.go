@@ -0,0 +1,83 @@
+package factstoredb
+
+import (
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/google/mangle/ast"
+)
+
+func TestSQLiteSerialize(t *testing.T) {
+	facts := []ast.Atom{
+		evalAtom(`p(/a, "x", [1, 2])`),
+		evalAtom("p(/b, 2.5, {/k: /v})"),
+	}
+	for _, connStr := range []string{":memory:", filepath.Join(t.TempDir(), "facts.db")} {
+		store, err := NewFactStoreSQLite(connStr)
+		if err != nil {
+			t.Fatalf("Failed to create store: %v", err)
+		}
+		for _, fact := range facts {
+			store.Add(fact)
+		}
+		data, err := store.Serialize()
+		if err != nil {
+			t.Fatalf("Serialize() error = %v", err)
+		}
+		viaFile, err := store.serializeFile()
+		if err != nil {
+			t.Fatalf("serializeFile() error = %v", err)
+		}
+		store.Close()
+
+		for i, data := range [][]byte{data, viaFile} {
+			loaded, err := NewFactStoreSQLiteFromBytes(data)
+			if err != nil {
+				t.Fatalf("NewFactStoreSQLiteFromBytes() of serialization %d of %s: error = %v", i, connStr, err)
+			}
+			for _, fact := range facts {
+				if !loaded.Contains(fact) {
+					t.Errorf("store loaded from serialization %d of %s does not contain %v", i, connStr, fact)
+				}
+			}
+			// The loaded store is writable and independent of data.
+			loaded.Add(evalAtom("q(/new)"))
+			if got := loaded.EstimateFactCount(); got != len(facts)+1 {
+				t.Errorf("EstimateFactCount() = %d after Add, want %d", got, len(facts)+1)
+			}
+			loaded.Close()
+		}
+	}
+
+	if _, err := NewFactStoreSQLiteFromBytes([]byte("not a database")); err == nil {
+		t.Error("NewFactStoreSQLiteFromBytes() of garbage succeeded, want error")
+	}
+}
+
+func TestNewFactStoreSQLiteFromFS(t *testing.T) {
+	store, err := NewFactStoreSQLite(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	fact := evalAtom("p(/a)")
+	store.Add(fact)
+	data, err := store.Serialize()
+	store.Close()
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+
+	fsys := fstest.MapFS{"bundle/facts.db": {Data: data}}
+	loaded, err := NewFactStoreSQLiteFromFS(fsys, "bundle/facts.db")
+	if err != nil {
+		t.Fatalf("NewFactStoreSQLiteFromFS() error = %v", err)
+	}
+	defer loaded.Close()
+	if !loaded.Contains(fact) {
+		t.Errorf("Contains(%v) = false", fact)
+	}
+	if _, err := NewFactStoreSQLiteFromFS(fsys, "missing.db"); err == nil {
+		t.Error("NewFactStoreSQLiteFromFS() of a missing file succeeded, want error")
+	}
+}
@@ -0,0 +1,186 @@
+package factstoredb
+
+import (
+	"container/list"
+	"database/sql"
+	"sync"
+)
+
+// defaultStmtCacheSize is the number of prepared query statements kept per
+// PostgreSQL store. SQLite stores have no cache unless one is configured.
+const defaultStmtCacheSize = 64
+
+// stmtCache is a bounded LRU cache of prepared statements keyed by query shape.
+//
+// Values are always bound as parameters, so the SQL of a query only depends
+// on its shape: the predicate arity, the bound argument positions and the
+// kinds of its constraints and options. Plain pattern queries are keyed by a
+// compact shape key, which also saves building their SQL on a hit; other
+// queries are keyed by their SQL text. Queries of the same shape share a
+// statement, which PostgreSQL does not have to parse and plan again. The SQLite
+// driver compiles statements on every execution, so there the cache only saves
+// building the SQL, which measures as no gain; it is off by default.
+type stmtCache struct {
+	db   *sql.DB
+	size int
+
+	mu      sync.Mutex
+	lru     *list.List // of *cachedStmt, most recently used first
+	entries map[string]*list.Element
+	closed  bool
+}
+
+// cachedStmt is a prepared statement together with the number of queries
+// currently starting on it. An evicted statement is closed once unused.
+type cachedStmt struct {
+	key     string
+	stmt    *sql.Stmt
+	refs    int
+	evicted bool
+}
+
+// newStmtCache returns a cache holding up to size statements, or nil if size
+// is not positive, in which case queries run unprepared.
+func newStmtCache(db *sql.DB, size int) *stmtCache {
+	if size <= 0 {
+		return nil
+	}
+	return &stmtCache{
+		db:      db,
+		size:    size,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// query runs a read query through the statement cache, if the store has one.
+func (s *FactStoreDB) query(query string, args ...any) (*sql.Rows, error) {
+	if s.stmts == nil {
+		return s.db.Query(query, args...)
+	}
+	return s.stmts.query(query, func() (string, error) { return query, nil }, args...)
+}
+
+// query runs the statement cached under key with args. On a miss, buildSQL
+// returns the SQL to prepare.
+func (c *stmtCache) query(key string, buildSQL func() (string, error), args ...any) (*sql.Rows, error) {
+	entry, err := c.acquire(key, buildSQL)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		query, err := buildSQL()
+		if err != nil {
+			return nil, err
+		}
+		return c.db.Query(query, args...)
+	}
+	// Open rows keep the statement alive even if it is closed meanwhile,
+	// so it can be released as soon as the query has started.
+	rows, err := entry.stmt.Query(args...)
+	c.release(entry)
+	return rows, err
+}
+
+// acquire returns the statement for key, preparing it on a miss.
+// It returns nil after the cache has been closed.
+func (c *stmtCache) acquire(key string, buildSQL func() (string, error)) (*cachedStmt, error) {
+	if entry, closed := c.lookup(key); entry != nil || closed {
+		return entry, nil
+	}
+
+	// The statement is prepared without holding the lock, which would make
+	// every query wait for a round trip to the database.
+	query, err := buildSQL()
+	if err != nil {
+		return nil, err
+	}
+	stmt, err := c.db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		stmt.Close()
+		return nil, nil
+	}
+	if elem, ok := c.entries[key]; ok {
+		// Another query prepared the same statement meanwhile.
+		stmt.Close()
+		return c.use(elem), nil
+	}
+	entry := &cachedStmt{key: key, stmt: stmt, refs: 1}
+	c.entries[key] = c.lru.PushFront(entry)
+	for c.lru.Len() > c.size {
+		c.evict(c.lru.Back())
+	}
+	return entry, nil
+}
+
+// lookup returns the cached statement for key, if any, and whether the cache
+// has been closed.
+func (c *stmtCache) lookup(key string) (*cachedStmt, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil, true
+	}
+	if elem, ok := c.entries[key]; ok {
+		return c.use(elem), false
+	}
+	return nil, false
+}
+
+// use marks elem as the most recently used and starts a query on it. The
+// caller must hold c.mu.
+func (c *stmtCache) use(elem *list.Element) *cachedStmt {
+	c.lru.MoveToFront(elem)
+	entry := elem.Value.(*cachedStmt)
+	entry.refs++
+	return entry
+}
+
+// release marks the end of a query started on entry.
+func (c *stmtCache) release(entry *cachedStmt) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry.refs--
+	if entry.evicted && entry.refs == 0 {
+		entry.stmt.Close()
+	}
+}
+
+// evict removes elem from the cache. The caller must hold c.mu.
+func (c *stmtCache) evict(elem *list.Element) {
+	entry := c.lru.Remove(elem).(*cachedStmt)
+	delete(c.entries, entry.key)
+	entry.evicted = true
+	if entry.refs == 0 {
+		entry.stmt.Close()
+	}
+}
+
+// len returns the number of cached statements.
+func (c *stmtCache) len() int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// close closes all cached statements. Later queries run unprepared.
+func (c *stmtCache) close() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for c.lru.Len() > 0 {
+		c.evict(c.lru.Back())
+	}
+	c.closed = true
+}
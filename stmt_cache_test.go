@@ -0,0 +1,130 @@
+package factstoredb
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/google/mangle/ast"
+)
+
+func TestStmtCache(t *testing.T) {
+	store, err := NewFactStoreSQLite(":memory:", WithStatementCacheSize(2))
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	for i := range 5 {
+		store.Add(evalAtom(fmt.Sprintf("p(/a%d, %d, /b)", i, i)))
+	}
+
+	count := func(pattern string, opts ...QueryOption) int {
+		t.Helper()
+		n := 0
+		if err := store.Query(atom(pattern), func(ast.Atom) error { n++; return nil }, opts...); err != nil {
+			t.Fatalf("Query(%s) error = %v", pattern, err)
+		}
+		return n
+	}
+
+	// Patterns with the same bound positions share one statement.
+	if got := count("p(/a1, X, Y)"); got != 1 {
+		t.Errorf("p(/a1, X, Y) matched %d facts, want 1", got)
+	}
+	if got := count("p(/a2, X, Y)"); got != 1 {
+		t.Errorf("p(/a2, X, Y) matched %d facts, want 1", got)
+	}
+	if got := store.stmts.len(); got != 1 {
+		t.Errorf("cache holds %d statements, want 1", got)
+	}
+
+	// More shapes than the cache holds evict the least recently used ones.
+	if got := count("p(X, Y, /b)"); got != 5 {
+		t.Errorf("p(X, Y, /b) matched %d facts, want 5", got)
+	}
+	if got := count("p(X, Y, Z)", Where(Ge(1, ast.Number(3)))); got != 2 {
+		t.Errorf("p(X, Y, Z) with Ge matched %d facts, want 2", got)
+	}
+	if got := store.stmts.len(); got != 2 {
+		t.Errorf("cache holds %d statements, want 2", got)
+	}
+	if got := count("p(/a1, X, Y)"); got != 1 {
+		t.Errorf("p(/a1, X, Y) after eviction matched %d facts, want 1", got)
+	}
+
+	// Concurrent queries keep working while statements are evicted.
+	var wg sync.WaitGroup
+	errs := make(chan error, 40)
+	for i := range 40 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := store.Query(atom("p(X, Y, Z)"), func(ast.Atom) error { return nil }, Where(Ge(1, ast.Number(int64(i%5)))))
+			if err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("concurrent Query() error = %v", err)
+	}
+
+	// Queries missing the cache at once prepare it concurrently; one statement
+	// is kept.
+	errs = make(chan error, 20)
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := store.Query(atom("p(X, /a1, Z)"), func(ast.Atom) error { return nil }); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("concurrent Query() error = %v", err)
+	}
+	if got := store.stmts.len(); got != 2 {
+		t.Errorf("cache holds %d statements, want 2", got)
+	}
+
+	store.stmts.close()
+	if got := store.stmts.len(); got != 0 {
+		t.Errorf("cache holds %d statements after close, want 0", got)
+	}
+	if got := count("p(/a3, X, Y)"); got != 1 {
+		t.Errorf("p(/a3, X, Y) after close matched %d facts, want 1", got)
+	}
+}
+
+func TestStmtCacheDisabled(t *testing.T) {
+	store, err := NewFactStoreSQLite(":memory:", WithStatementCacheSize(0))
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	store.Add(evalAtom("p(/a)"))
+	found, err := store.Exists(atom("p(/a)"))
+	if err != nil || !found {
+		t.Errorf("Exists() = %v, %v, want true", found, err)
+	}
+	if store.stmts != nil {
+		t.Error("statement cache created with size 0")
+	}
+
+	// SQLite stores have no cache by default.
+	store, err = NewFactStoreSQLite(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer store.Close()
+	if store.stmts != nil {
+		t.Error("statement cache created for a SQLite store by default")
+	}
+}
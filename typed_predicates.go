@@ -0,0 +1,613 @@
+package factstoredb
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/mangle/ast"
+)
+
+// ColumnType is the type of an argument of a typed predicate. The names match
+// the Mangle type expressions /number, /float64, /string, /name and /bytes.
+type ColumnType string
+
+const (
+	// ColumnNumber holds numbers in a BIGINT column.
+	ColumnNumber ColumnType = "number"
+	// ColumnFloat64 holds floats in a DOUBLE column.
+	ColumnFloat64 ColumnType = "float64"
+	// ColumnString holds strings in a TEXT column.
+	ColumnString ColumnType = "string"
+	// ColumnName holds names in a TEXT column.
+	ColumnName ColumnType = "name"
+	// ColumnBytes holds byte strings in a BLOB (SQLite) or BYTEA (PostgreSQL) column.
+	ColumnBytes ColumnType = "bytes"
+)
+
+// WithTypedPredicate stores the facts of pred in a dedicated table with one
+// typed column per argument instead of the facts table. Add, Remove,
+// Contains and GetFacts on pred use that table, and bound arguments are
+// compared as native column values, which is faster than JSON for wide,
+// fixed-schema predicates. Facts whose arguments do not have the declared
+// types are not added.
+//
+// Typed predicates support Query and GetBindings without options, Exists and
+// CreateArgIndex. Options such as Where, NotExists, OrderBy and Limit, as well
+// as Aggregate and graph queries, return an error for them, and they cannot
+// be negated with NotExists. Their arguments are never interned.
+//
+// Declarations are recorded in the store metadata: reopening a store keeps its
+// typed predicates, declaring one with different columns fails, and so does
+// declaring a predicate that already has facts in the facts table.
+func WithTypedPredicate(pred ast.PredicateSym, columns ...ColumnType) StoreOption {
+	return func(c *config) {
+		if c.typed == nil {
+			c.typed = make(map[ast.PredicateSym][]ColumnType)
+		}
+		c.typed[pred] = columns
+	}
+}
+
+// WithTypedDecls declares a typed predicate, as with WithTypedPredicate, for
+// each declaration with a single bound whose type expressions are all
+// /number, /float64, /string, /name or /bytes. Other declarations are ignored
+// and their facts are stored in the facts table.
+//
+//	foo(X, Y) bound [/name, /number].
+func WithTypedDecls(decls ...ast.Decl) StoreOption {
+	return func(c *config) {
+		for _, decl := range decls {
+			if columns, ok := declColumns(decl); ok {
+				WithTypedPredicate(decl.DeclaredAtom.Predicate, columns...)(c)
+			}
+		}
+	}
+}
+
+// declColumns returns the column types of decl if it can be stored in a typed table.
+func declColumns(decl ast.Decl) ([]ColumnType, bool) {
+	if len(decl.Bounds) != 1 {
+		return nil, false
+	}
+	bounds := decl.Bounds[0].Bounds
+	if len(bounds) != decl.DeclaredAtom.Predicate.Arity {
+		return nil, false
+	}
+	columns := make([]ColumnType, len(bounds))
+	for i, bound := range bounds {
+		switch bound {
+		case ast.NumberBound:
+			columns[i] = ColumnNumber
+		case ast.Float64Bound:
+			columns[i] = ColumnFloat64
+		case ast.StringBound:
+			columns[i] = ColumnString
+		case ast.NameBound:
+			columns[i] = ColumnName
+		case ast.BytesBound:
+			columns[i] = ColumnBytes
+		default:
+			return nil, false
+		}
+	}
+	return columns, true
+}
+
+// metaTypedPrefix prefixes the factstore_meta keys that record typed
+// predicates. The key ends with the predicate key; the value lists the
+// column types separated by commas.
+const metaTypedPrefix = "typed:"
+
+// typedTable holds the facts of a typed predicate. Its columns are atom_hash,
+// computed as for the facts table, and a0, a1, ... for the arguments.
+type typedTable struct {
+	pred    ast.PredicateSym
+	columns []ColumnType
+	// name is the quoted table name.
+	name string
+
+	addStmt      *sql.Stmt
+	removeStmt   *sql.Stmt
+	containsStmt *sql.Stmt
+}
+
+// initTypedTables records the configured typed predicates and creates the
+// tables of all typed predicates of the store. The facts table must exist.
+func (s *FactStoreDB) initTypedTables(cfg *config) error {
+	recorded, err := s.metadataWithPrefix(metaTypedPrefix)
+	if err != nil {
+		return err
+	}
+
+	preds := make([]ast.PredicateSym, 0, len(cfg.typed))
+	for pred := range cfg.typed {
+		preds = append(preds, pred)
+	}
+	sort.Slice(preds, func(i, j int) bool { return predicateToKey(preds[i]) < predicateToKey(preds[j]) })
+	for _, pred := range preds {
+		columns := cfg.typed[pred]
+		if len(columns) != pred.Arity {
+			return fmt.Errorf("typed predicate %v: %d column types for arity %d", pred, len(columns), pred.Arity)
+		}
+		for _, c := range columns {
+			if s.dialect.columnTypeSQL(c) == "" {
+				return fmt.Errorf("typed predicate %v: unknown column type %q", pred, c)
+			}
+		}
+		key := metaTypedPrefix + predicateToKey(pred)
+		value := joinColumnTypes(columns)
+		if prev, ok := recorded[key]; ok {
+			if prev != value {
+				return fmt.Errorf("store was written with typed predicate %v (%s) and cannot be opened with (%s)", pred, prev, value)
+			}
+			continue
+		}
+		if s.readOnly {
+			return fmt.Errorf("typed predicate %v cannot be declared on a read-only store", pred)
+		}
+		var untyped int
+		if err := s.db.QueryRow("SELECT COUNT(*) FROM facts WHERE predicate = "+s.dialect.placeholder(1), predicateToKey(pred)).Scan(&untyped); err != nil {
+			return fmt.Errorf("failed to count facts of %v: %w", pred, err)
+		}
+		if untyped > 0 {
+			return fmt.Errorf("typed predicate %v: the store already holds %d untyped facts of it", pred, untyped)
+		}
+		if err := s.setMetadata(key, value); err != nil {
+			return err
+		}
+		recorded[key] = value
+	}
+
+	for key, value := range recorded {
+		pred, err := keyToPredicate(strings.TrimPrefix(key, metaTypedPrefix))
+		if err != nil {
+			return err
+		}
+		columns, err := splitColumnTypes(value, pred.Arity)
+		if err != nil {
+			return fmt.Errorf("typed predicate %v: %w", pred, err)
+		}
+		t, err := s.newTypedTable(pred, columns)
+		if err != nil {
+			return err
+		}
+		if s.typed == nil {
+			s.typed = make(map[ast.PredicateSym]*typedTable)
+		}
+		s.typed[pred] = t
+	}
+	return nil
+}
+
+// joinColumnTypes returns the metadata value recording columns.
+func joinColumnTypes(columns []ColumnType) string {
+	names := make([]string, len(columns))
+	for i, c := range columns {
+		names[i] = string(c)
+	}
+	return strings.Join(names, ",")
+}
+
+// splitColumnTypes parses a metadata value written by joinColumnTypes.
+func splitColumnTypes(value string, arity int) ([]ColumnType, error) {
+	if arity == 0 && value == "" {
+		return nil, nil
+	}
+	names := strings.Split(value, ",")
+	if len(names) != arity {
+		return nil, fmt.Errorf("%d column types recorded for arity %d", len(names), arity)
+	}
+	columns := make([]ColumnType, len(names))
+	for i, name := range names {
+		columns[i] = ColumnType(name)
+	}
+	return columns, nil
+}
+
+// quoteIdent quotes an SQL identifier.
+func quoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// newTypedTable creates the table of pred and prepares its statements. On a
+// read-only store, the table must exist and only the contains statement is
+// prepared.
+func (s *FactStoreDB) newTypedTable(pred ast.PredicateSym, columns []ColumnType) (*typedTable, error) {
+	t := &typedTable{
+		pred:    pred,
+		columns: columns,
+		name:    quoteIdent("facts_" + predicateToKey(pred)),
+	}
+	columnTypes := make([]string, len(columns))
+	for i, c := range columns {
+		sqlType := s.dialect.columnTypeSQL(c)
+		if sqlType == "" {
+			return nil, fmt.Errorf("typed predicate %v: unknown column type %q", pred, c)
+		}
+		columnTypes[i] = sqlType
+	}
+	ph := s.dialect.placeholder
+	var err error
+	if s.readOnly {
+		if t.containsStmt, err = s.db.Prepare("SELECT COUNT(*) FROM " + t.name + " WHERE atom_hash = " + ph(1)); err != nil {
+			return nil, fmt.Errorf("failed to prepare contains statement of typed predicate %v: %w", pred, err)
+		}
+		return t, nil
+	}
+	if _, err := s.db.Exec(s.dialect.createTypedTableSQL(t.name, columnTypes)); err != nil {
+		return nil, fmt.Errorf("failed to create table of typed predicate %v: %w", pred, err)
+	}
+
+	var insert strings.Builder
+	insert.WriteString("INSERT INTO " + t.name + " (atom_hash")
+	for i := range columns {
+		insert.WriteString(", " + typedColumn(i))
+	}
+	insert.WriteString(") VALUES (" + ph(1))
+	for i := range columns {
+		insert.WriteString(", " + ph(i+2))
+	}
+	insert.WriteString(") ON CONFLICT (atom_hash) DO NOTHING")
+
+	if t.addStmt, err = s.db.Prepare(insert.String()); err != nil {
+		return nil, fmt.Errorf("failed to prepare add statement of typed predicate %v: %w", pred, err)
+	}
+	if t.removeStmt, err = s.db.Prepare("DELETE FROM " + t.name + " WHERE atom_hash = " + ph(1)); err != nil {
+		return nil, fmt.Errorf("failed to prepare remove statement of typed predicate %v: %w", pred, err)
+	}
+	if t.containsStmt, err = s.db.Prepare("SELECT COUNT(*) FROM " + t.name + " WHERE atom_hash = " + ph(1)); err != nil {
+		return nil, fmt.Errorf("failed to prepare contains statement of typed predicate %v: %w", pred, err)
+	}
+	return t, nil
+}
+
+// typedColumn returns the name of the column holding argument i.
+func typedColumn(i int) string {
+	return "a" + strconv.Itoa(i)
+}
+
+// typedColumnsSQL returns the definitions of argument columns of the given SQL types,
+// each preceded by a comma.
+func typedColumnsSQL(columnTypes []string) string {
+	var sb strings.Builder
+	for i, sqlType := range columnTypes {
+		sb.WriteString(", " + typedColumn(i) + " " + sqlType + " NOT NULL")
+	}
+	return sb.String()
+}
+
+// columnValue returns the column value of c in a column of type t, or false
+// if c does not have that type.
+func columnValue(t ColumnType, c ast.Constant) (any, bool) {
+	switch {
+	case t == ColumnNumber && c.Type == ast.NumberType:
+		return c.NumValue, true
+	case t == ColumnFloat64 && c.Type == ast.Float64Type:
+		f, err := c.Float64Value()
+		return f, err == nil
+	case t == ColumnString && c.Type == ast.StringType, t == ColumnName && c.Type == ast.NameType:
+		return c.Symbol, true
+	case t == ColumnBytes && c.Type == ast.BytesType:
+		return []byte(c.Symbol), true
+	default:
+		return nil, false
+	}
+}
+
+// row returns the atom_hash and column values of atom.
+func (t *typedTable) row(atom ast.Atom) ([]any, error) {
+	hash, err := atomHash(atom)
+	if err != nil {
+		return nil, err
+	}
+	values := make([]any, 0, len(atom.Args)+1)
+	values = append(values, hash)
+	for i, arg := range atom.Args {
+		// atomHash checked that the arguments are constants.
+		v, ok := columnValue(t.columns[i], arg.(ast.Constant))
+		if !ok {
+			return nil, fmt.Errorf("argument %d of %v is not a %s", i, atom, t.columns[i])
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+// add inserts atom and reports whether it was not present before.
+func (t *typedTable) add(atom ast.Atom) bool {
+	values, err := t.row(atom)
+	if err != nil {
+		// Cannot store atoms that do not match the declared types
+		return false
+	}
+	res, err := t.addStmt.Exec(values...)
+	if err != nil {
+		log.Printf("DBFactStore failed to add to typed predicate %v: %v", t.pred, err)
+		return false
+	}
+	rowsAffected, err := res.RowsAffected()
+	return err == nil && rowsAffected > 0
+}
+
+// typedPage selects a keyset page of the facts of a typed table, in
+// atom_hash order.
+type typedPage struct {
+	// after is the atom_hash the page starts after, nil for the first page.
+	after *int64
+	// limit caps the number of facts; 0 means no limit.
+	limit int
+}
+
+// newTypedPage returns the page that cfg selects, or nil if it selects all
+// matching facts in no particular order.
+func newTypedPage(pattern ast.Atom, cfg *queryConfig) (*typedPage, error) {
+	if !cfg.wantCursor && !cfg.ordered() {
+		return nil, nil
+	}
+	page := &typedPage{limit: cfg.limit}
+	if cfg.after != "" {
+		// Without OrderBy, cursors hold the atom_hash only, as for untyped predicates.
+		values, err := decodeCursor(cfg.after, orderFingerprint(pattern, nil), 1)
+		if err != nil {
+			return nil, err
+		}
+		hash, ok := values[0].(int64)
+		if !ok {
+			return nil, errors.New("invalid cursor: atom_hash is not an integer")
+		}
+		page.after = &hash
+	}
+	return page, nil
+}
+
+// buildQuery returns the SQL selecting the arguments of the facts matching
+// pattern, with its parameters and statement cache key. With a page, the
+// atom_hash is selected after the arguments to build the next cursor. It
+// returns false if a constant of pattern does not have the type of its
+// column, so that no fact can match.
+func (t *typedTable) buildQuery(d dialect, pattern ast.Atom, page *typedPage) (string, []any, string, bool) {
+	var sb strings.Builder
+	var params []any
+	key := make([]byte, 0, len(pattern.Args)+2)
+	sb.WriteString("SELECT ")
+	for i := range t.columns {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		sb.WriteString(typedColumn(i))
+	}
+	if page != nil {
+		sb.WriteString(", atom_hash")
+	}
+	sb.WriteString(" FROM " + t.name)
+	// where appends the condition comparing lhs with the parameter v.
+	where := func(lhs string, v any) {
+		params = append(params, v)
+		if len(params) == 1 {
+			sb.WriteString(" WHERE ")
+		} else {
+			sb.WriteString(" AND ")
+		}
+		sb.WriteString(lhs + d.placeholder(len(params)))
+	}
+	for i, arg := range pattern.Args {
+		c, ok := arg.(ast.Constant)
+		if !ok {
+			key = append(key, '_')
+			continue
+		}
+		v, ok := columnValue(t.columns[i], c)
+		if !ok {
+			return "", nil, "", false
+		}
+		where(typedColumn(i)+" = ", v)
+		key = append(key, 'c')
+	}
+	if page != nil {
+		key = append(key, '|')
+		if page.after != nil {
+			where("atom_hash > ", *page.after)
+			key = append(key, 'a')
+		}
+		sb.WriteString(" ORDER BY atom_hash")
+		if page.limit > 0 {
+			params = append(params, page.limit)
+			sb.WriteString(" LIMIT " + d.placeholder(len(params)))
+			key = append(key, 'l')
+		}
+	}
+	return sb.String(), params, metaTypedPrefix + predicateToKey(t.pred) + ":" + string(key), true
+}
+
+// requireUnfiltered returns an error if cfg filters or orders the facts,
+// which typed predicates do not support. Limit and After page through the
+// facts in atom_hash order.
+func (t *typedTable) requireUnfiltered(cfg *queryConfig) error {
+	if len(cfg.constraints) > 0 || len(cfg.notExists) > 0 || len(cfg.orderBy) > 0 {
+		return fmt.Errorf("filtering and ordering are not supported for typed predicate %v", t.pred)
+	}
+	return nil
+}
+
+// typedScanner decodes rows selecting all argument columns of a typed table.
+type typedScanner struct {
+	t       *typedTable
+	numbers []int64
+	floats  []float64
+	texts   []string
+	blobs   [][]byte
+	// dest holds the scan destinations of the columns.
+	dest []any
+}
+
+func (t *typedTable) newScanner() *typedScanner {
+	sc := &typedScanner{
+		t:       t,
+		numbers: make([]int64, len(t.columns)),
+		floats:  make([]float64, len(t.columns)),
+		texts:   make([]string, len(t.columns)),
+		blobs:   make([][]byte, len(t.columns)),
+		dest:    make([]any, len(t.columns)),
+	}
+	for i, c := range t.columns {
+		switch c {
+		case ColumnNumber:
+			sc.dest[i] = &sc.numbers[i]
+		case ColumnFloat64:
+			sc.dest[i] = &sc.floats[i]
+		case ColumnString, ColumnName:
+			sc.dest[i] = &sc.texts[i]
+		case ColumnBytes:
+			sc.dest[i] = &sc.blobs[i]
+		}
+	}
+	return sc
+}
+
+// atom returns the fact of the last scanned row.
+func (sc *typedScanner) atom() (ast.Atom, error) {
+	args := make([]ast.BaseTerm, len(sc.t.columns))
+	for i, c := range sc.t.columns {
+		switch c {
+		case ColumnNumber:
+			args[i] = ast.Number(sc.numbers[i])
+		case ColumnFloat64:
+			args[i] = ast.Float64(sc.floats[i])
+		case ColumnString:
+			args[i] = ast.String(sc.texts[i])
+		case ColumnName:
+			name, err := ast.Name(sc.texts[i])
+			if err != nil {
+				return ast.Atom{}, fmt.Errorf("failed to create name from %q: %w", sc.texts[i], err)
+			}
+			args[i] = name
+		case ColumnBytes:
+			args[i] = ast.Bytes(slices.Clone(sc.blobs[i]))
+		}
+	}
+	return ast.Atom{Predicate: sc.t.pred, Args: args}, nil
+}
+
+// getTypedFacts streams the facts of a typed predicate that match pattern.
+// If cfg.wantCursor is set and the limit was reached, it returns the cursor
+// of the next page.
+func (s *FactStoreDB) getTypedFacts(t *typedTable, pattern ast.Atom, cfg *queryConfig, callback func(ast.Atom) error) (string, error) {
+	if err := t.requireUnfiltered(cfg); err != nil {
+		return "", err
+	}
+	page, err := newTypedPage(pattern, cfg)
+	if err != nil {
+		return "", err
+	}
+	profile := cfg.profile
+	if profile != nil {
+		*profile = QueryProfile{}
+		start := time.Now()
+		// Set the query time however the query ends, also when the callback
+		// stops it early.
+		defer func() {
+			profile.QueryTime = time.Since(start) - profile.DecodeTime - profile.CallbackTime
+		}()
+	}
+
+	query, params, key, ok := t.buildQuery(s.dialect, pattern, page)
+	if !ok {
+		return "", nil
+	}
+	var rows *sql.Rows
+	if s.stmts != nil {
+		rows, err = s.stmts.query(key, func() (string, error) { return query, nil }, params...)
+	} else {
+		rows, err = s.db.Query(query, params...)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to query facts: %w", err)
+	}
+	defer rows.Close()
+
+	sc := t.newScanner()
+	dest := sc.dest
+	var lastHash int64
+	if page != nil {
+		dest = append(slices.Clip(dest), &lastHash)
+	}
+	count := 0
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return "", fmt.Errorf("failed to scan row: %w", err)
+		}
+		count++
+
+		var t0 time.Time
+		if profile != nil {
+			t0 = time.Now()
+		}
+		atom, err := sc.atom()
+		if err != nil {
+			return "", err
+		}
+
+		var t1 time.Time
+		if profile != nil {
+			t1 = time.Now()
+			profile.DecodeTime += t1.Sub(t0)
+		}
+		err = callback(atom)
+		if profile != nil {
+			profile.CallbackTime += time.Since(t1)
+			profile.Rows = count
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+
+	// A full page may be followed by more results.
+	if !cfg.wantCursor || count == 0 || count < cfg.limit {
+		return "", nil
+	}
+	return encodeCursor(orderFingerprint(pattern, nil), []any{lastHash})
+}
+
+// typedPredicates returns the typed predicates that have facts, sorted by key.
+func (s *FactStoreDB) typedPredicates() []ast.PredicateSym {
+	var preds []ast.PredicateSym
+	for pred, t := range s.typed {
+		var one int
+		err := s.db.QueryRow("SELECT 1 FROM " + t.name + " LIMIT 1").Scan(&one)
+		if err == nil {
+			preds = append(preds, pred)
+		} else if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("DBFactStore failed to query typed predicate %v: %v", pred, err)
+		}
+	}
+	sort.Slice(preds, func(i, j int) bool { return predicateToKey(preds[i]) < predicateToKey(preds[j]) })
+	return preds
+}
+
+// requireUntyped returns an error if pred is a typed predicate, which op does not support.
+func (s *FactStoreDB) requireUntyped(op string, pred ast.PredicateSym) error {
+	if s.typed[pred] != nil {
+		return fmt.Errorf("%s is not supported for typed predicate %v", op, pred)
+	}
+	return nil
+}
+
+// close closes the prepared statements of the table.
+func (t *typedTable) close() {
+	for _, stmt := range []*sql.Stmt{t.addStmt, t.removeStmt, t.containsStmt} {
+		if stmt != nil {
+			stmt.Close()
+		}
+	}
+}
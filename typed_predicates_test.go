@@ -0,0 +1,249 @@
+package factstoredb
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/google/mangle/ast"
+)
+
+// newTypedSQLiteStore is a factory for in-memory SQLite stores with a typed reading/4 predicate.
+func newTypedSQLiteStore() (*FactStoreDB, error) {
+	return NewFactStoreSQLite(":memory:", WithTypedPredicate(
+		ast.PredicateSym{Symbol: "reading", Arity: 4},
+		ColumnName, ColumnNumber, ColumnFloat64, ColumnString,
+	))
+}
+
+func TestSQLiteTypedPredicates(t *testing.T) {
+	t.Run("ReadWrite", func(t *testing.T) {
+		store, err := newTypedSQLiteStore()
+		if err != nil {
+			t.Fatalf("Failed to create store: %v", err)
+		}
+		t.Cleanup(func() { store.Close() })
+
+		facts := []ast.Atom{
+			evalAtom(`reading(/s1, 1700000000, 21.5, "ok")`),
+			evalAtom(`reading(/s1, 1700000060, 22.0, "ok")`),
+			evalAtom(`reading(/s2, 1700000000, -3.25, "with \"quotes\"")`),
+		}
+		for _, f := range facts {
+			if !store.Add(f) {
+				t.Errorf("Add(%v) = false, want true", f)
+			}
+		}
+		if store.Add(facts[0]) {
+			t.Errorf("Add(%v) twice = true, want false", facts[0])
+		}
+		if bad := evalAtom(`reading(/s1, "late", 1.0, "ok")`); store.Add(bad) {
+			t.Errorf("Add(%v) with a mistyped argument = true, want false", bad)
+		}
+		store.Add(evalAtom("other(/x)"))
+
+		var untyped int
+		if err := store.db.QueryRow("SELECT COUNT(*) FROM facts").Scan(&untyped); err != nil {
+			t.Fatalf("Failed to count facts: %v", err)
+		}
+		if untyped != 1 {
+			t.Errorf("facts table holds %d facts, want only other(/x)", untyped)
+		}
+		if got := store.EstimateFactCount(); got != 4 {
+			t.Errorf("EstimateFactCount() = %d, want 4", got)
+		}
+		var preds []string
+		for _, p := range store.ListPredicates() {
+			preds = append(preds, predicateToKey(p))
+		}
+		slices.Sort(preds)
+		if want := []string{"other_1", "reading_4"}; !slices.Equal(preds, want) {
+			t.Errorf("ListPredicates() = %v, want %v", preds, want)
+		}
+
+		for _, f := range facts {
+			if !store.Contains(f) {
+				t.Errorf("Contains(%v) = false, want true", f)
+			}
+		}
+		tests := []struct {
+			pattern string
+			want    int
+		}{
+			{"reading(S, T, V, M)", 3},
+			{"reading(/s1, T, V, M)", 2},
+			{"reading(S, 1700000000, V, M)", 2},
+			{"reading(S, T, 22.0, M)", 1},
+			{`reading(S, T, V, "ok")`, 2},
+			{"reading(/s3, T, V, M)", 0},
+			// Constants of another type than the column match nothing.
+			{`reading("/s1", T, V, M)`, 0},
+		}
+		for _, tt := range tests {
+			var got []ast.Atom
+			if err := store.GetFacts(atom(tt.pattern), func(a ast.Atom) error {
+				got = append(got, a)
+				return nil
+			}); err != nil {
+				t.Fatalf("GetFacts(%s) error = %v", tt.pattern, err)
+			}
+			if len(got) != tt.want {
+				t.Errorf("GetFacts(%s) = %v, want %d facts", tt.pattern, got, tt.want)
+			}
+			for _, a := range got {
+				if !slices.ContainsFunc(facts, func(f ast.Atom) bool { return f.Equals(a) }) {
+					t.Errorf("GetFacts(%s) returned unknown fact %v", tt.pattern, a)
+				}
+			}
+		}
+
+		if !store.Remove(facts[1]) || store.Contains(facts[1]) || store.Remove(facts[1]) {
+			t.Errorf("Remove(%v) did not remove it exactly once", facts[1])
+		}
+
+		var buf bytes.Buffer
+		if _, err := store.WriteTo(&buf); err != nil {
+			t.Fatalf("WriteTo() error = %v", err)
+		}
+		restored, err := newTypedSQLiteStore()
+		if err != nil {
+			t.Fatalf("Failed to create store: %v", err)
+		}
+		defer restored.Close()
+		if _, err := restored.ReadFrom(&buf); err != nil {
+			t.Fatalf("ReadFrom() error = %v", err)
+		}
+		if !restored.Contains(facts[0]) || !restored.Contains(facts[2]) || restored.EstimateFactCount() != 3 {
+			t.Errorf("ReadFrom() of WriteTo() output lost facts, got %d", restored.EstimateFactCount())
+		}
+	})
+
+	t.Run("Queries", func(t *testing.T) {
+		store, err := newTypedSQLiteStore()
+		if err != nil {
+			t.Fatalf("Failed to create store: %v", err)
+		}
+		t.Cleanup(func() { store.Close() })
+
+		store.Add(evalAtom(`reading(/s1, 1, 1.5, "ok")`))
+		store.Add(evalAtom(`reading(/s2, 2, 2.5, "ok")`))
+		store.Add(evalAtom("sensor(/s1)"))
+
+		if ok, err := store.Exists(atom("reading(/s2, T, V, M)")); err != nil || !ok {
+			t.Errorf("Exists(reading(/s2, T, V, M)) = %v, %v, want true", ok, err)
+		}
+		var got []string
+		err = store.GetBindings(atom("reading(S, T, V, _)"), func(subst ast.ConstSubstMap) error {
+			got = append(got, subst[ast.Variable{Symbol: "S"}].String())
+			return nil
+		})
+		slices.Sort(got)
+		if err != nil || !slices.Equal(got, []string{"/s1", "/s2"}) {
+			t.Errorf("GetBindings() = %v, %v, want [/s1 /s2]", got, err)
+		}
+
+		if err := store.CreateArgIndex(0); err != nil {
+			t.Fatalf("CreateArgIndex(0) error = %v", err)
+		}
+		exp, err := store.Explain(atom("reading(/s1, T, V, M)"))
+		if err != nil {
+			t.Fatalf("Explain() error = %v", err)
+		}
+		if !strings.Contains(exp.SQL, `"facts_reading_4"`) || !strings.Contains(exp.Plan, "idx_facts_reading_4_a0") {
+			t.Errorf("Explain() = %q with plan %q, want a lookup on the typed table index", exp.SQL, exp.Plan)
+		}
+
+		// A query stopped by its callback records its time.
+		var p QueryProfile
+		if err := store.Query(atom("reading(S, T, V, M)"), func(ast.Atom) error {
+			return errStopIteration
+		}, WithProfile(&p)); !errors.Is(err, errStopIteration) {
+			t.Fatalf("Query() error = %v, want %v", err, errStopIteration)
+		}
+		if p.Rows != 1 || p.QueryTime <= 0 {
+			t.Errorf("profile of a stopped query = %+v, want 1 row and a query time", p)
+		}
+
+		// Options that are evaluated on the facts table are refused.
+		if err := store.Query(atom("reading(S, T, V, M)"), func(ast.Atom) error { return nil }, Where(Gt(1, ast.Number(1)))); err == nil {
+			t.Error("Query() with Where on a typed predicate succeeded, want error")
+		}
+		if err := store.Query(atom("sensor(S)"), func(ast.Atom) error { return nil }, NotExists(atom("reading(S, _, _, _)"))); err == nil {
+			t.Error("Query() negating a typed predicate succeeded, want error")
+		}
+		if _, err := store.Aggregate(atom("reading(S, T, V, M)"), nil, []Aggregate{Count()}); err == nil {
+			t.Error("Aggregate() over a typed predicate succeeded, want error")
+		}
+	})
+}
+
+func TestTypedDecls(t *testing.T) {
+	decls := []ast.Decl{
+		{
+			DeclaredAtom: atom("edge(X, Y, W)"),
+			Bounds:       []ast.BoundDecl{ast.NewBoundDecl(ast.NameBound, ast.NameBound, ast.NumberBound)},
+		},
+		// Bounds other than the basic types keep a predicate in the facts table.
+		{
+			DeclaredAtom: atom("tag(X, Y)"),
+			Bounds:       []ast.BoundDecl{ast.NewBoundDecl(ast.NameBound, ast.AnyBound)},
+		},
+		{DeclaredAtom: atom("blob(X)")},
+	}
+	store, err := NewFactStoreSQLite(":memory:", WithTypedDecls(decls...))
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	var typed []string
+	for pred := range store.typed {
+		typed = append(typed, predicateToKey(pred))
+	}
+	if !slices.Equal(typed, []string{"edge_3"}) {
+		t.Errorf("typed predicates = %v, want [edge_3]", typed)
+	}
+	if fact := evalAtom("edge(/a, /b, 3)"); !store.Add(fact) || !store.Contains(fact) {
+		t.Errorf("Add(%v) to a typed predicate failed", fact)
+	}
+}
+
+func TestTypedPredicateMetadata(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "facts.db")
+	pred := ast.PredicateSym{Symbol: "p", Arity: 2}
+	fact := evalAtom(`p(/a, b"\x00\x01")`)
+
+	store, err := NewFactStoreSQLite(path, WithTypedPredicate(pred, ColumnName, ColumnBytes))
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	store.Add(fact)
+	store.Add(evalAtom("q(/a, 1)"))
+	store.Close()
+
+	// The recorded typed predicates are used when none are declared.
+	store, err = NewFactStoreSQLite(path)
+	if err != nil {
+		t.Fatalf("Failed to reopen store: %v", err)
+	}
+	if store.typed[pred] == nil || !store.Contains(fact) {
+		t.Errorf("reopened store lost typed predicate %v", pred)
+	}
+	store.Close()
+
+	if _, err := NewFactStoreSQLite(path, WithTypedPredicate(pred, ColumnName, ColumnString)); err == nil {
+		t.Error("opening a store with different column types succeeded, want error")
+	}
+	if _, err := NewFactStoreSQLite(path, WithTypedPredicate(ast.PredicateSym{Symbol: "q", Arity: 2}, ColumnName, ColumnNumber)); err == nil {
+		t.Error("declaring a predicate with untyped facts succeeded, want error")
+	}
+	if _, err := NewFactStoreSQLite(":memory:", WithTypedPredicate(pred, ColumnName)); err == nil {
+		t.Error("declaring a typed predicate with too few columns succeeded, want error")
+	}
+	if _, err := NewFactStoreSQLite(":memory:", WithTypedPredicate(pred, ColumnName, "uuid")); err == nil {
+		t.Error("declaring a typed predicate with an unknown column type succeeded, want error")
+	}
+}
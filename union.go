@@ -0,0 +1,171 @@
+package factstoredb
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/google/mangle/ast"
+	"github.com/google/mangle/factstore"
+)
+
+// UnionStore presents the facts of several stores as one, for example facts
+// partitioned per region or per source, so that Mangle programs are
+// evaluated over all of them. Members can be any read-only fact store, such
+// as a FactStoreDB, a ReadOnlyFactStoreDB bundle or an OverlayStore. A fact
+// held by several members appears once.
+//
+// A UnionStore is read-only; WritableUnionStore routes writes to one store.
+// The union does not own its members: closing them is up to the caller.
+type UnionStore struct {
+	members []factstore.ReadOnlyFactStore
+}
+
+// Verify that UnionStore implements the ReadOnlyFactStore interface
+var _ factstore.ReadOnlyFactStore = (*UnionStore)(nil)
+
+// NewUnionStore returns the union of members.
+func NewUnionStore(members []factstore.ReadOnlyFactStore) (*UnionStore, error) {
+	if len(members) == 0 {
+		return nil, errors.New("a union store needs at least one member")
+	}
+	return &UnionStore{members: slices.Clone(members)}, nil
+}
+
+// errUnionStopped stops the members of a union streaming facts after the
+// callback of GetFacts failed.
+var errUnionStopped = errors.New("union stopped")
+
+// GetFacts returns a stream of facts that match a given atom. The members are
+// queried concurrently and a fact held by several members is returned once,
+// identified by its atom hash. The callback is called from the calling
+// goroutine only; facts of different members arrive in no particular order.
+func (u *UnionStore) GetFacts(pattern ast.Atom, callback func(ast.Atom) error) error {
+	facts := make(chan ast.Atom, 64)
+	done := make(chan struct{})
+	errs := make([]error, len(u.members))
+	var wg sync.WaitGroup
+	for i, member := range u.members {
+		wg.Go(func() {
+			errs[i] = member.GetFacts(pattern, func(atom ast.Atom) error {
+				select {
+				case facts <- atom:
+					return nil
+				case <-done:
+					return errUnionStopped
+				}
+			})
+		})
+	}
+	go func() {
+		wg.Wait()
+		close(facts)
+	}()
+
+	seen := make(map[int64]bool)
+	var err error
+	for atom := range facts {
+		if err != nil {
+			continue // Drain until all members have stopped.
+		}
+		var hash int64
+		if hash, err = atomHash(atom); err != nil {
+			close(done)
+			continue
+		}
+		if seen[hash] {
+			continue
+		}
+		seen[hash] = true
+		if err = callback(atom); err != nil {
+			close(done)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	for i, memberErr := range errs {
+		if memberErr != nil {
+			return fmt.Errorf("member %d: %w", i, memberErr)
+		}
+	}
+	return nil
+}
+
+// Contains returns true if given atom is present in any member.
+func (u *UnionStore) Contains(atom ast.Atom) bool {
+	for _, member := range u.members {
+		if member.Contains(atom) {
+			return true
+		}
+	}
+	return false
+}
+
+// ListPredicates lists the predicates of all members.
+func (u *UnionStore) ListPredicates() []ast.PredicateSym {
+	var predicates []ast.PredicateSym
+	seen := make(map[ast.PredicateSym]bool)
+	for _, member := range u.members {
+		for _, pred := range member.ListPredicates() {
+			if !seen[pred] {
+				seen[pred] = true
+				predicates = append(predicates, pred)
+			}
+		}
+	}
+	return predicates
+}
+
+// EstimateFactCount returns the sum of the fact counts of the members. Facts
+// held by several members are counted once per member.
+func (u *UnionStore) EstimateFactCount() int {
+	count := 0
+	for _, member := range u.members {
+		count += member.EstimateFactCount()
+	}
+	return count
+}
+
+// WritableUnionStore is a UnionStore that writes to one store, its writer,
+// which is queried as one of its members. Writes only change the writer, so
+// a removed fact stays in the union if another member holds it.
+type WritableUnionStore struct {
+	*UnionStore
+	writer factstore.FactStoreWithRemove
+}
+
+// Verify that WritableUnionStore implements the FactStoreWithRemove interface
+var _ factstore.FactStoreWithRemove = (*WritableUnionStore)(nil)
+
+// NewWritableUnionStore returns the union of members and writer, with writes
+// going to writer. writer should not be listed in members.
+func NewWritableUnionStore(members []factstore.ReadOnlyFactStore, writer factstore.FactStoreWithRemove) (*WritableUnionStore, error) {
+	if writer == nil {
+		return nil, errors.New("a writable union store needs a writer")
+	}
+	union, err := NewUnionStore(append(slices.Clone(members), writer))
+	if err != nil {
+		return nil, err
+	}
+	return &WritableUnionStore{UnionStore: union, writer: writer}, nil
+}
+
+// Add adds a fact to the writer and returns true if it was not in the union.
+func (u *WritableUnionStore) Add(atom ast.Atom) bool {
+	if u.Contains(atom) {
+		return false
+	}
+	return u.writer.Add(atom)
+}
+
+// Remove removes a fact from the writer and returns true if it was there.
+func (u *WritableUnionStore) Remove(atom ast.Atom) bool {
+	return u.writer.Remove(atom)
+}
+
+// Merge merges the contents of other into the writer.
+func (u *WritableUnionStore) Merge(other factstore.ReadOnlyFactStore) {
+	u.writer.Merge(other)
+}
@@ -0,0 +1,109 @@
+package factstoredb
+
+import (
+	"errors"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/google/mangle/ast"
+	"github.com/google/mangle/factstore"
+)
+
+func TestUnionStore(t *testing.T) {
+	newStore := func() *FactStoreDB {
+		store, err := NewFactStoreSQLite(":memory:")
+		if err != nil {
+			t.Fatalf("Failed to create store: %v", err)
+		}
+		t.Cleanup(func() { store.Close() })
+		return store
+	}
+
+	// Members can be stores, read-only bundles and overlays.
+	first := newStore()
+	first.Add(evalAtom("edge(/a, /b)"))
+	first.Add(evalAtom("edge(/b, /c)"))
+
+	path := filepath.Join(t.TempDir(), "bundle.db")
+	store, err := NewFactStoreSQLite(path)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	store.Add(evalAtom("edge(/b, /c)"))
+	store.Add(evalAtom("edge(/c, /d)"))
+	store.Close()
+	bundle, err := NewFactStoreSQLiteReadOnly(path)
+	if err != nil {
+		t.Fatalf("NewFactStoreSQLiteReadOnly() error = %v", err)
+	}
+	defer bundle.Close()
+
+	overlay := NewOverlayStore(newStore(), newStore())
+	overlay.Add(evalAtom(`label(/a, "start")`))
+
+	members := []factstore.ReadOnlyFactStore{first, bundle, overlay}
+	union, err := NewUnionStore(members)
+	if err != nil {
+		t.Fatalf("NewUnionStore() error = %v", err)
+	}
+	var got []string
+	if err := union.GetFacts(atom("edge(X, Y)"), func(a ast.Atom) error {
+		got = append(got, a.String())
+		return nil
+	}); err != nil {
+		t.Fatalf("GetFacts() error = %v", err)
+	}
+	sort.Strings(got)
+	want := []string{"edge(/a,/b)", "edge(/b,/c)", "edge(/c,/d)"}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] || got[2] != want[2] {
+		t.Errorf("GetFacts(edge(X, Y)) = %v, want %v", got, want)
+	}
+	if !union.Contains(evalAtom(`label(/a, "start")`)) || union.Contains(evalAtom("edge(/d, /a)")) {
+		t.Error("Contains() does not reflect the members")
+	}
+	if got := len(union.ListPredicates()); got != 2 {
+		t.Errorf("ListPredicates() = %v, want edge and label", union.ListPredicates())
+	}
+	if got := union.EstimateFactCount(); got != 5 {
+		t.Errorf("EstimateFactCount() = %d, want 5", got)
+	}
+
+	// An error of the callback stops all members.
+	stop := errors.New("stop")
+	calls := 0
+	if err := union.GetFacts(atom("edge(X, Y)"), func(ast.Atom) error {
+		calls++
+		return stop
+	}); !errors.Is(err, stop) || calls != 1 {
+		t.Errorf("GetFacts() with a failing callback = %v after %d calls, want %v after 1", err, calls, stop)
+	}
+
+	writer := newStore()
+	writable, err := NewWritableUnionStore(members, writer)
+	if err != nil {
+		t.Fatalf("NewWritableUnionStore() error = %v", err)
+	}
+	if writable.Add(evalAtom("edge(/a, /b)")) {
+		t.Error("Add() of a fact of another member = true, want false")
+	}
+	if !writable.Add(evalAtom("edge(/d, /a)")) || !writer.Contains(evalAtom("edge(/d, /a)")) {
+		t.Error("Add() did not write to the writer")
+	}
+	if got := writable.EstimateFactCount(); got != 6 {
+		t.Errorf("EstimateFactCount() of writable union = %d, want 6", got)
+	}
+	if !writable.Remove(evalAtom("edge(/d, /a)")) || writable.Contains(evalAtom("edge(/d, /a)")) {
+		t.Error("Remove() did not remove from the writer")
+	}
+	if writable.Remove(evalAtom("edge(/a, /b)")) || !writable.Contains(evalAtom("edge(/a, /b)")) {
+		t.Error("Remove() of a fact of another member changed the union")
+	}
+
+	if _, err := NewWritableUnionStore(members, nil); err == nil {
+		t.Error("NewWritableUnionStore() without a writer succeeded, want error")
+	}
+	if _, err := NewUnionStore(nil); err == nil {
+		t.Error("NewUnionStore() without members succeeded, want error")
+	}
+}
@@ -0,0 +1,366 @@
+package factstoredb
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"strconv"
+
+	"github.com/google/mangle/ast"
+)
+
+// IssueKind classifies the problems Verify finds in rows of the facts table.
+type IssueKind int
+
+const (
+	// IssueHashMismatch is a row whose atom_hash differs from the hash of its fact.
+	IssueHashMismatch IssueKind = iota + 1
+	// IssueUndecodableArgs is a row whose args cannot be decoded.
+	IssueUndecodableArgs
+	// IssueBadPredicate is a row whose predicate is not a "symbol_arity" key.
+	IssueBadPredicate
+	// IssueArityMismatch is a row with a different number of args than its predicate.
+	IssueArityMismatch
+)
+
+// String returns a short name of the kind.
+func (k IssueKind) String() string {
+	switch k {
+	case IssueHashMismatch:
+		return "hash mismatch"
+	case IssueUndecodableArgs:
+		return "undecodable args"
+	case IssueBadPredicate:
+		return "bad predicate"
+	case IssueArityMismatch:
+		return "arity mismatch"
+	default:
+		return "IssueKind(" + strconv.Itoa(int(k)) + ")"
+	}
+}
+
+// Issue is a problem with a row of the facts table.
+type Issue struct {
+	Kind IssueKind
+	// Predicate is the predicate column of the row.
+	Predicate string
+	// AtomHash is the atom_hash column of the row.
+	AtomHash int64
+	// Detail describes the problem.
+	Detail string
+
+	// hash is the recomputed atom_hash of a hash mismatch.
+	hash int64
+	// rehash reports whether the row can get hash: not if the type of its
+	// numbers is ambiguous.
+	rehash bool
+}
+
+func (i Issue) String() string {
+	return fmt.Sprintf("%s: row %s/%d: %s", i.Kind, i.Predicate, i.AtomHash, i.Detail)
+}
+
+// VerifyReport is the result of Verify.
+type VerifyReport struct {
+	// Rows is the number of rows checked.
+	Rows int
+	// Issues lists the problems found, in table order.
+	Issues []Issue
+	// Unverified counts the rows whose atom_hash cannot be checked: the JSON
+	// codec stores floats with integral values as numbers, and rows with too
+	// many integral numbers have too many candidate facts to hash.
+	Unverified int
+	// Rehashed and Quarantined count the rows fixed in Repair mode.
+	Rehashed    int
+	Quarantined int
+}
+
+// OK reports whether no issues were found.
+func (r *VerifyReport) OK() bool {
+	return len(r.Issues) == 0
+}
+
+// VerifyOption configures Verify.
+type VerifyOption func(*verifyConfig)
+
+type verifyConfig struct {
+	repair bool
+}
+
+// Repair makes Verify fix the rows it reports. Rows with a hash mismatch get
+// the recomputed atom_hash, unless a row with that hash exists already or the
+// type of their numbers is ambiguous. Those rows and all rows with other issues are moved to the facts_quarantine
+// table, which has the columns of the facts table and a reason column, so
+// that they can be inspected or restored by hand.
+func Repair() VerifyOption {
+	return func(c *verifyConfig) {
+		c.repair = true
+	}
+}
+
+// Verify checks every row of the facts table: its predicate key must parse,
+// its args must decode into as many constants as the predicate's arity, and
+// its atom_hash must equal the hash recomputed from the decoded fact. With
+// the JSON codec, integral numbers also match if they were stored as floats. Tables
+// of typed predicates are not checked, since their columns are typed by the
+// database. Verify reads the whole table and stops early only if ctx is done.
+func (s *FactStoreDB) Verify(ctx context.Context, opts ...VerifyOption) (VerifyReport, error) {
+	cfg := &verifyConfig{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	report, err := s.verifyRows(ctx)
+	if err != nil || !cfg.repair || report.OK() {
+		return report, err
+	}
+	err = s.repair(ctx, &report)
+	return report, err
+}
+
+// verifyRows scans the facts table and collects the issues of its rows.
+func (s *FactStoreDB) verifyRows(ctx context.Context) (VerifyReport, error) {
+	var report VerifyReport
+	rows, err := s.db.QueryContext(ctx, "SELECT predicate, atom_hash, "+s.codec.selectSQL(s.dialect)+" FROM facts")
+	if err != nil {
+		return report, fmt.Errorf("failed to query facts: %w", err)
+	}
+	defer rows.Close()
+
+	var predicate string
+	var storedHash int64
+	var args sql.RawBytes
+	for rows.Next() {
+		if err := rows.Scan(&predicate, &storedHash, &args); err != nil {
+			return report, fmt.Errorf("failed to scan row: %w", err)
+		}
+		report.Rows++
+		issue := Issue{Predicate: predicate, AtomHash: storedHash}
+
+		pred, err := keyToPredicate(predicate)
+		if err != nil {
+			issue.Kind, issue.Detail = IssueBadPredicate, err.Error()
+			report.Issues = append(report.Issues, issue)
+			continue
+		}
+		fact, err := s.codec.decodeArgs(s.dialect, pred, args)
+		if err == nil && s.dict != nil {
+			err = s.dict.resolveArgs(fact.Args)
+		}
+		if err != nil {
+			issue.Kind, issue.Detail = IssueUndecodableArgs, err.Error()
+			report.Issues = append(report.Issues, issue)
+			continue
+		}
+		if len(fact.Args) != pred.Arity {
+			issue.Kind = IssueArityMismatch
+			issue.Detail = fmt.Sprintf("%d args for arity %d", len(fact.Args), pred.Arity)
+			report.Issues = append(report.Issues, issue)
+			continue
+		}
+		hash, err := atomHash(fact)
+		if err != nil {
+			issue.Kind, issue.Detail = IssueUndecodableArgs, err.Error()
+			report.Issues = append(report.Issues, issue)
+			continue
+		}
+		if hash == storedHash {
+			continue
+		}
+		issue.Kind, issue.hash = IssueHashMismatch, hash
+		issue.Detail = fmt.Sprintf("%v hashes to %d", fact, hash)
+		if !s.codec.keepsFloats() {
+			match, verifiable, ambiguous, err := floatVariantMatches(fact, storedHash)
+			if err != nil {
+				return report, err
+			}
+			if match {
+				continue
+			}
+			if !verifiable {
+				report.Unverified++
+				continue
+			}
+			if ambiguous {
+				// The row is wrong whichever numbers were floats, but it
+				// is not known which were.
+				issue.Detail = fmt.Sprintf("%v and its variants with floats do not hash to %d", fact, storedHash)
+				report.Issues = append(report.Issues, issue)
+				continue
+			}
+		}
+		issue.hash, issue.rehash = hash, true
+		report.Issues = append(report.Issues, issue)
+	}
+	return report, rows.Err()
+}
+
+// maxFloatVariants is the largest number of variants of a fact that
+// floatVariantMatches hashes.
+const maxFloatVariants = 256
+
+// floatVariantMatches reports whether fact, or a variant of it with some
+// integral numbers taken as floats, hashes to stored, since the JSON codec
+// stores floats with integral values as numbers. It is not verifiable if the
+// fact has more than maxFloatVariants variants. ambiguous reports whether
+// the fact has variants at all.
+func floatVariantMatches(fact ast.Atom, stored int64) (match, verifiable, ambiguous bool, err error) {
+	args := make([][]ast.Constant, len(fact.Args))
+	for i, arg := range fact.Args {
+		args[i] = floatVariants(arg.(ast.Constant))
+	}
+	variants := constantProduct(args)
+	if variants == nil {
+		return false, false, true, nil
+	}
+	for _, variant := range variants[1:] {
+		hash, err := atomHash(ast.Atom{Predicate: fact.Predicate, Args: toBaseTerms(variant)})
+		if err != nil {
+			return false, false, false, err
+		}
+		if hash == stored {
+			return true, true, true, nil
+		}
+	}
+	return false, true, len(variants) > 1, nil
+}
+
+// floatVariants returns c followed by the constants that the JSON codec
+// stores like c, with some of its integral numbers being floats, or nil if
+// there are more than maxFloatVariants.
+func floatVariants(c ast.Constant) []ast.Constant {
+	switch c.Type {
+	case ast.NumberType:
+		n, _ := c.NumberValue()
+		return []ast.Constant{c, ast.Float64(float64(n))}
+	case ast.ListShape:
+		var elems [][]ast.Constant
+		c.ListValues(func(e ast.Constant) error {
+			elems = append(elems, floatVariants(e))
+			return nil
+		}, func() error { return nil })
+		return buildVariants(elems, func(parts []ast.Constant) ast.Constant { return ast.List(parts) })
+	case ast.PairShape:
+		fst, snd, err := c.PairValue()
+		if err != nil {
+			return []ast.Constant{c}
+		}
+		return buildVariants([][]ast.Constant{floatVariants(fst), floatVariants(snd)}, func(parts []ast.Constant) ast.Constant {
+			return ast.Pair(&parts[0], &parts[1])
+		})
+	case ast.MapShape, ast.StructShape:
+		var kvs [][]ast.Constant
+		each := func(k, v ast.Constant) error {
+			kvs = append(kvs, floatVariants(k), floatVariants(v))
+			return nil
+		}
+		if c.Type == ast.MapShape {
+			c.MapValues(each, func() error { return nil })
+		} else {
+			c.StructValues(each, func() error { return nil })
+		}
+		return buildVariants(kvs, func(parts []ast.Constant) ast.Constant {
+			kvMap := make(map[*ast.Constant]*ast.Constant, len(parts)/2)
+			for i := 0; i < len(parts); i += 2 {
+				kvMap[&parts[i]] = &parts[i+1]
+			}
+			if c.Type == ast.MapShape {
+				return *ast.Map(kvMap)
+			}
+			return *ast.Struct(kvMap)
+		})
+	default:
+		return []ast.Constant{c}
+	}
+}
+
+// buildVariants builds a constant of each combination of the variants of
+// its parts, or returns nil if there are more than maxFloatVariants.
+func buildVariants(parts [][]ast.Constant, build func([]ast.Constant) ast.Constant) []ast.Constant {
+	combinations := constantProduct(parts)
+	if combinations == nil {
+		return nil
+	}
+	variants := make([]ast.Constant, len(combinations))
+	for i, combination := range combinations {
+		variants[i] = build(combination)
+	}
+	return variants
+}
+
+// constantProduct returns the combinations of one constant of each of sets,
+// starting with the combination of their first constants, or nil if a set
+// is nil or there are more than maxFloatVariants combinations.
+func constantProduct(sets [][]ast.Constant) [][]ast.Constant {
+	combinations := [][]ast.Constant{nil}
+	for _, set := range sets {
+		if set == nil || len(combinations)*len(set) > maxFloatVariants {
+			return nil
+		}
+		next := make([][]ast.Constant, 0, len(combinations)*len(set))
+		for _, c := range set {
+			for _, combination := range combinations {
+				next = append(next, append(slices.Clone(combination), c))
+			}
+		}
+		combinations = next
+	}
+	return combinations
+}
+
+// toBaseTerms converts constants to the args of an atom.
+func toBaseTerms(constants []ast.Constant) []ast.BaseTerm {
+	terms := make([]ast.BaseTerm, len(constants))
+	for i, c := range constants {
+		terms[i] = c
+	}
+	return terms
+}
+
+// repair fixes the rows of the reported issues in a single transaction.
+func (s *FactStoreDB) repair(ctx context.Context, report *VerifyReport) error {
+	// Quarantined rows keep the column the codec stores args in.
+	args := s.codec.column()
+	if _, err := s.db.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS facts_quarantine ("+
+		"predicate TEXT NOT NULL, atom_hash BIGINT NOT NULL, "+args+" "+s.codec.columnType(s.dialect)+" NOT NULL, reason TEXT NOT NULL)"); err != nil {
+		return fmt.Errorf("failed to create quarantine table: %w", err)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin repair: %w", err)
+	}
+	defer tx.Rollback() // Rollback is a no-op if Commit succeeds
+
+	ph := s.dialect.placeholder
+	rehashed, quarantined := 0, 0
+	for _, issue := range report.Issues {
+		if issue.rehash {
+			var taken int
+			if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM facts WHERE atom_hash = "+ph(1), issue.hash).Scan(&taken); err != nil {
+				return fmt.Errorf("failed to look up hash %d: %w", issue.hash, err)
+			}
+			if taken == 0 {
+				if _, err := tx.ExecContext(ctx, "UPDATE facts SET atom_hash = "+ph(1)+" WHERE atom_hash = "+ph(2), issue.hash, issue.AtomHash); err != nil {
+					return fmt.Errorf("failed to rehash row %d: %w", issue.AtomHash, err)
+				}
+				rehashed++
+				continue
+			}
+		}
+		if _, err := tx.ExecContext(ctx, "INSERT INTO facts_quarantine (predicate, atom_hash, "+args+", reason)"+
+			" SELECT predicate, atom_hash, "+args+", "+ph(1)+" FROM facts WHERE atom_hash = "+ph(2), issue.String(), issue.AtomHash); err != nil {
+			return fmt.Errorf("failed to quarantine row %d: %w", issue.AtomHash, err)
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM facts WHERE atom_hash = "+ph(1), issue.AtomHash); err != nil {
+			return fmt.Errorf("failed to remove quarantined row %d: %w", issue.AtomHash, err)
+		}
+		quarantined++
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit repair: %w", err)
+	}
+	report.Rehashed, report.Quarantined = rehashed, quarantined
+	return nil
+}
@@ -0,0 +1,135 @@
+package factstoredb
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+
+	"github.com/google/mangle/ast"
+)
+
+func TestVerify(t *testing.T) {
+	store, err := NewFactStoreSQLite(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer store.Close()
+	ctx := context.Background()
+
+	good := evalAtom(`p(/a, "x", [1, 2])`)
+	moved := evalAtom("p(/b, 2.5, /c)")
+	store.Add(good)
+	store.Add(moved)
+	report, err := store.Verify(ctx)
+	if err != nil || !report.OK() || report.Rows != 2 {
+		t.Fatalf("Verify() of a healthy store = %+v, %v, want 2 rows without issues", report, err)
+	}
+
+	// Corrupt the store behind its back.
+	movedHash, _ := atomHash(moved)
+	for _, stmt := range []string{
+		"UPDATE facts SET atom_hash = 42 WHERE atom_hash = " + strconv.FormatInt(movedHash, 10),
+		`INSERT INTO facts (predicate, atom_hash, args) VALUES ('nokey', 1, jsonb('["/a"]'))`,
+		`INSERT INTO facts (predicate, atom_hash, args) VALUES ('q_1', 2, jsonb('[{"fn:bogus": []}]'))`,
+		`INSERT INTO facts (predicate, atom_hash, args) VALUES ('q_3', 3, jsonb('["/a", 1]'))`,
+	} {
+		if _, err := store.db.Exec(stmt); err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+
+	report, err = store.Verify(ctx)
+	if err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+	kinds := make(map[int64]IssueKind)
+	for _, issue := range report.Issues {
+		kinds[issue.AtomHash] = issue.Kind
+	}
+	want := map[int64]IssueKind{
+		42: IssueHashMismatch,
+		1:  IssueBadPredicate,
+		2:  IssueUndecodableArgs,
+		3:  IssueArityMismatch,
+	}
+	if report.Rows != 5 || len(kinds) != len(want) {
+		t.Errorf("Verify() = %d rows with issues %v, want 5 rows with %v", report.Rows, report.Issues, want)
+	}
+	for hash, kind := range want {
+		if kinds[hash] != kind {
+			t.Errorf("issue of row %d = %v, want %v", hash, kinds[hash], kind)
+		}
+	}
+	if store.Contains(moved) {
+		t.Errorf("Contains(%v) = true for a row with a wrong hash", moved)
+	}
+
+	report, err = store.Verify(ctx, Repair())
+	if err != nil {
+		t.Fatalf("Verify(Repair()) error = %v", err)
+	}
+	if report.Rehashed != 1 || report.Quarantined != 3 {
+		t.Errorf("Verify(Repair()) rehashed %d and quarantined %d rows, want 1 and 3", report.Rehashed, report.Quarantined)
+	}
+	if !store.Contains(moved) || !store.Contains(good) {
+		t.Errorf("repaired store lost facts")
+	}
+	var quarantined int
+	if err := store.db.QueryRow("SELECT COUNT(*) FROM facts_quarantine WHERE reason <> ''").Scan(&quarantined); err != nil || quarantined != 3 {
+		t.Errorf("quarantine table holds %d rows (%v), want 3", quarantined, err)
+	}
+	if report, err := store.Verify(ctx); err != nil || !report.OK() || report.Rows != 2 {
+		t.Errorf("Verify() after repair = %+v, %v, want 2 rows without issues", report, err)
+	}
+
+	// A rehash that would collide with an existing row quarantines the row.
+	goodHash, _ := atomHash(good)
+	if _, err := store.db.Exec("INSERT INTO facts (predicate, atom_hash, args) SELECT predicate, 7, args FROM facts WHERE atom_hash = ?", goodHash); err != nil {
+		t.Fatalf("Failed to duplicate row: %v", err)
+	}
+	if report, err := store.Verify(ctx, Repair()); err != nil || report.Rehashed != 0 || report.Quarantined != 1 {
+		t.Errorf("Verify(Repair()) of a duplicate = %+v, %v, want it quarantined", report, err)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := store.Verify(cancelled); !errors.Is(err, context.Canceled) {
+		t.Errorf("Verify() with a cancelled context: error = %v, want context.Canceled", err)
+	}
+}
+
+func TestVerifyIntegralFloats(t *testing.T) {
+	store, err := NewFactStoreSQLite(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer store.Close()
+	ctx := context.Background()
+
+	// The JSON codec stores 2.0 as 2, which decodes as a number.
+	float := evalAtom("p(2.0, 3)")
+	nested := evalAtom("p([2.0, 1], {/k: 4.0})")
+	many := evalAtom("p([1.0, 2, 3, 4, 5, 6, 7, 8, 9], 3)")
+	for _, fact := range []ast.Atom{float, nested, many, evalAtom("p(2, 3)")} {
+		store.Add(fact)
+	}
+	report, err := store.Verify(ctx, Repair())
+	if err != nil || !report.OK() || report.Rows != 4 || report.Unverified != 1 {
+		t.Fatalf("Verify(Repair()) of integral floats = %+v, %v, want 4 rows without issues, 1 unverified", report, err)
+	}
+	if !store.Contains(float) || !store.Contains(nested) || !store.Contains(many) {
+		t.Errorf("Verify(Repair()) of a healthy store lost integral floats")
+	}
+
+	// A wrong hash of a row with an integral number is quarantined, since it
+	// is not known whether the number was a float.
+	floatHash, _ := atomHash(float)
+	if _, err := store.db.Exec("UPDATE facts SET atom_hash = 42 WHERE atom_hash = ?", floatHash); err != nil {
+		t.Fatalf("Failed to corrupt row: %v", err)
+	}
+	report, err = store.Verify(ctx, Repair())
+	if err != nil || len(report.Issues) != 1 || report.Rehashed != 0 || report.Quarantined != 1 {
+		t.Errorf("Verify(Repair()) of an ambiguous hash mismatch = %+v, %v, want it quarantined", report, err)
+	}
+}